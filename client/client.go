@@ -0,0 +1,375 @@
+// Package client is a Go SDK for the task server. A Client keeps a pool of connections and
+// pipelines requests over them, so a single Client can be shared by many goroutines.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	// ErrClosed is returned by requests made after Close.
+	ErrClosed = errors.New("client closed")
+	// ErrRejected is returned when the server doesn't accept the protocol asked by the client.
+	ErrRejected = errors.New("server rejected the connection")
+	// ErrConnectionLost is returned when a connection breaks while a request waits for its result.
+	// The command may have run, so these requests are not retried.
+	ErrConnectionLost = errors.New("connection lost")
+	// ErrRequestInFlight is returned when a request reuses the id of a request still in flight.
+	ErrRequestInFlight = errors.New("request id already in flight")
+	// ErrRequestNotFound is returned by Cancel when no request with that id is in flight.
+	ErrRequestNotFound = errors.New("request not found")
+	// ErrStreamingUnsupported is returned by ExecuteStream when the server can't stream output.
+	ErrStreamingUnsupported = errors.New("server does not support output streaming")
+)
+
+// defaultMaxPipelined is the pipeline limit of the connections to servers that don't announce it.
+const defaultMaxPipelined = 16
+
+type ClientConfig struct {
+	// Addr is the host:port of the server. Defaults to localhost:3000.
+	Addr string
+
+	// MaxConns is the number of connections kept open to the server. Requests are pipelined over
+	// them, so a few connections serve many concurrent requests. Defaults to 2.
+	MaxConns int
+
+	// DialTimeout limits how long opening a connection, including the hello, can take.
+	DialTimeout time.Duration
+	// WriteTimeout limits how long sending a request can take.
+	WriteTimeout time.Duration
+
+	// TLS encrypts the connections when not nil, for servers behind a TLS terminating proxy.
+	TLS *tls.Config
+	// Token is sent in the hello of every connection, to servers configured with a client token.
+	Token string
+	// Dial opens the connections, it can be replaced to go through a proxy or tunnel.
+	// Defaults to net.Dialer.
+	Dial func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+	// Codec used to encode messages, json (default), msgpack or cbor.
+	Codec string
+	// Compression of the frames, none (default), gzip or zstd.
+	Compression string
+	// MaxResponseSize is the largest response, in bytes, accepted from the server.
+	MaxResponseSize int
+
+	// MaxRetries is how many times a request that couldn't be sent is retried. Defaults to 3,
+	// a negative value disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on every retry up to MaxRetryBackoff.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+type Client struct {
+	config ClientConfig
+
+	mu     sync.Mutex
+	conns  []*conn
+	closed bool
+
+	// inFlight maps the id of every request waiting for a result to its connection, for Cancel.
+	inFlight map[string]*conn
+
+	// released is closed, and replaced, every time a connection can take a new request, waking
+	// up the requests waiting for one while every connection is at its pipeline limit.
+	releasedMu sync.Mutex
+	released   chan struct{}
+}
+
+// NewClient creates a client. Connections are opened when the first requests are made.
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.Addr == "" {
+		config.Addr = "localhost:3000"
+	}
+
+	if config.MaxConns <= 0 {
+		config.MaxConns = 2
+	}
+
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 30 * time.Second
+	}
+
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{}).DialContext
+	}
+
+	if config.Codec == "" {
+		config.Codec = common.CodecJSON
+	}
+
+	if config.Compression == "" {
+		config.Compression = common.CompressionNone
+	}
+
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = common.DefaultMaxFrameSize
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = 5 * time.Second
+	}
+
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", config.Addr, err)
+	}
+
+	if config.TLS != nil && config.TLS.ServerName == "" {
+		host, _, _ := net.SplitHostPort(config.Addr)
+		config.TLS = config.TLS.Clone()
+		config.TLS.ServerName = host
+	}
+
+	return &Client{
+		config:   config,
+		inFlight: map[string]*conn{},
+		released: make(chan struct{}),
+	}, nil
+}
+
+// Execute runs a command on the server and waits for its result. Requests without an ID get a
+// random one, and requests without a trace context are traced as part of the trace of ctx.
+// Cancelling ctx cancels the command on the server.
+func (c *Client) Execute(ctx context.Context, request models.TaskRequest) (models.TaskResult, error) {
+	return c.ExecuteStream(ctx, request, nil)
+}
+
+// ExecuteStream runs a command like Execute, calling onOutput with the output of the command
+// while it runs. onOutput is called from the goroutine reading the connection, so it must not block.
+func (c *Client) ExecuteStream(ctx context.Context, request models.TaskRequest, onOutput func(models.OutputChunk)) (models.TaskResult, error) {
+	if request.ID == "" {
+		request.ID = uuid.New().String()
+	}
+
+	if request.Traceparent == "" {
+		carrier := propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(ctx, carrier)
+		request.Traceparent, request.Tracestate = carrier.Get("traceparent"), carrier.Get("tracestate")
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff(attempt)); err != nil {
+				return models.TaskResult{}, err
+			}
+		}
+
+		conn, err := c.conn(ctx)
+		if err != nil {
+			if errors.Is(err, ErrClosed) || errors.Is(err, ErrRejected) || ctx.Err() != nil {
+				return models.TaskResult{}, err
+			}
+			lastErr = err
+			continue
+		}
+
+		if onOutput != nil && !conn.streaming() {
+			conn.release()
+			return models.TaskResult{}, ErrStreamingUnsupported
+		}
+
+		if !c.track(request.ID, conn) {
+			conn.release()
+			return models.TaskResult{}, fmt.Errorf("%w: %s", ErrRequestInFlight, request.ID)
+		}
+
+		pending, err := conn.send(request, onOutput)
+		if err != nil {
+			conn.release()
+			c.untrack(request.ID)
+			lastErr = err
+			continue
+		}
+
+		return c.wait(ctx, conn, pending, request.ID)
+	}
+
+	return models.TaskResult{}, fmt.Errorf("request not sent after %d attempts: %w", c.config.MaxRetries+1, lastErr)
+}
+
+// Cancel stops a request made by this client that is still in flight.
+func (c *Client) Cancel(id string) error {
+	c.mu.Lock()
+	conn, ok := c.inFlight[id]
+	c.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrRequestNotFound, id)
+	}
+
+	return conn.cancel(id)
+}
+
+// Close closes every connection, requests still in flight fail.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, conn := range c.conns {
+		conn.fail(ErrClosed)
+	}
+	c.conns = nil
+
+	return nil
+}
+
+func (c *Client) wait(ctx context.Context, conn *conn, pending *call, id string) (models.TaskResult, error) {
+	defer c.untrack(id)
+
+	select {
+	case <-pending.done:
+		return pending.result, pending.err
+	case <-ctx.Done():
+		conn.forget(id)
+		conn.cancel(id)
+		return models.TaskResult{}, ctx.Err()
+	}
+}
+
+// conn returns the least busy connection of the pool with a slot acquired for a request, opening
+// a new one while the pool isn't full. When every connection has as many requests in flight as the
+// server reads ahead, it waits for one of them to be answered.
+func (c *Client) conn(ctx context.Context) (*conn, error) {
+	for {
+		// Taken before looking for a slot, so a slot released meanwhile isn't missed
+		c.releasedMu.Lock()
+		released := c.released
+		c.releasedMu.Unlock()
+
+		conn, err := c.acquire(ctx)
+		if conn != nil || err != nil {
+			return conn, err
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquire returns a connection with a slot acquired, or nil when every connection is full.
+// Connections are opened holding the lock, so concurrent requests wait for the dial in progress
+// instead of opening more than MaxConns connections.
+func (c *Client) acquire(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	var best *conn
+	live := c.conns[:0]
+	for _, conn := range c.conns {
+		if conn.broken() {
+			continue
+		}
+		live = append(live, conn)
+
+		if !conn.full() && (best == nil || conn.load() < best.load()) {
+			best = conn
+		}
+	}
+	c.conns = live
+
+	// Slots are only acquired holding the lock, and released by the connections, so best still has one
+	if best != nil && (best.load() == 0 || len(c.conns) >= c.config.MaxConns) && best.acquire() {
+		return best, nil
+	}
+
+	if len(c.conns) >= c.config.MaxConns {
+		return nil, nil
+	}
+
+	conn, err := dial(ctx, c.config, c.release)
+	if err != nil {
+		return nil, err
+	}
+	c.conns = append(c.conns, conn)
+	conn.acquire()
+
+	return conn, nil
+}
+
+// release wakes up the requests waiting for a connection.
+func (c *Client) release() {
+	c.releasedMu.Lock()
+	defer c.releasedMu.Unlock()
+
+	close(c.released)
+	c.released = make(chan struct{})
+}
+
+func (c *Client) track(id string, conn *conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.inFlight[id]; ok {
+		return false
+	}
+
+	c.inFlight[id] = conn
+	return true
+}
+
+func (c *Client) untrack(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, id)
+}
+
+// backoff returns the wait before a retry, with jitter so clients don't retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.config.RetryBackoff << (attempt - 1)
+	if wait <= 0 || wait > c.config.MaxRetryBackoff {
+		wait = c.config.MaxRetryBackoff
+	}
+
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startServer runs a server whose commands are handled by run, and returns its address.
+func startServer(t *testing.T, run func(ctx context.Context, request models.TaskRequest) models.TaskResult) string {
+	return startServerWithConfig(t, server.ServerConfig{MaxConn: 10}, run)
+}
+
+// startServerWithConfig runs a server like startServer, with the settings of config.
+func startServerWithConfig(t *testing.T, config server.ServerConfig, run func(ctx context.Context, request models.TaskRequest) models.TaskResult) string {
+	port := rand.Intn(2001) + 7000
+	config.Port = port
+	config.Addr = "localhost"
+
+	newServer, err := server.NewServer(config)
+	assert.Nil(t, err, "Creating the server should not return error")
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		server.CodecFromContext(ctx).Unmarshal(req, &request)
+
+		result := run(ctx, request)
+		result.ID = request.ID
+		return result
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go newServer.Start(ctx, callback)
+
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+func echo(ctx context.Context, request models.TaskRequest) models.TaskResult {
+	return models.TaskResult{Command: request.Command, Output: strings.Join(request.Command, " ")}
+}
+
+func TestNewClient_SUCCESS_Default_Values(t *testing.T) {
+	client, err := NewClient(ClientConfig{})
+
+	assert.Nil(t, err, "Creating a client with default values should not return error")
+	assert.Equal(t, "localhost:3000", client.config.Addr, "The default addr should've been assigned to localhost:3000")
+	assert.Equal(t, 2, client.config.MaxConns, "The default MaxConns should've been assigned to 2")
+	assert.Equal(t, common.CodecJSON, client.config.Codec, "The default codec should've been assigned to json")
+	assert.Equal(t, common.CompressionNone, client.config.Compression, "Compression should be disabled by default")
+	assert.Equal(t, 3, client.config.MaxRetries, "The default MaxRetries should've been assigned to 3")
+}
+
+func TestNewClient_ERROR_Invalid_Addr(t *testing.T) {
+	_, err := NewClient(ClientConfig{Addr: "localhost"})
+
+	assert.NotNil(t, err, "An address without port should return error")
+}
+
+func TestExecute_SUCCESS(t *testing.T) {
+	addr := startServer(t, echo)
+	client, _ := NewClient(ClientConfig{Addr: addr})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.Execute(ctx, models.TaskRequest{Command: []string{"echo", "hello"}})
+	assert.Nil(t, err, "Executing a request should not return error")
+	assert.Equal(t, "echo hello", result.Output, "The result of the command should be returned")
+	assert.NotEmpty(t, result.ID, "Requests without an id should get one")
+}
+
+func TestExecute_SUCCESS_Large_Response_Compressed(t *testing.T) {
+	largeOutput := strings.Repeat("journal line\n", 200000)
+	addr := startServer(t, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		return models.TaskResult{Output: largeOutput}
+	})
+
+	for _, compression := range []string{common.CompressionNone, common.CompressionGzip, common.CompressionZstd} {
+		client, _ := NewClient(ClientConfig{Addr: addr, Codec: common.CodecMsgPack, Compression: compression})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result, err := client.Execute(ctx, models.TaskRequest{Command: []string{"journalctl"}})
+		cancel()
+		client.Close()
+
+		assert.Nil(t, err, "Executing a request should not return error with %s compression", compression)
+		assert.Equal(t, len(largeOutput), len(result.Output), "The whole response should be read with %s compression", compression)
+	}
+}
+
+func TestExecute_SUCCESS_Concurrent_Requests_Share_The_Pool(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		time.Sleep(100 * time.Millisecond)
+		return echo(ctx, request)
+	})
+	client, _ := NewClient(ClientConfig{Addr: addr, MaxConns: 2})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	results := make([]models.TaskResult, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = client.Execute(ctx, models.TaskRequest{Command: []string{"echo", fmt.Sprint(i)}})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		assert.Equal(t, fmt.Sprintf("echo %d", i), result.Output, "Every request should receive its own result")
+	}
+	assert.LessOrEqual(t, len(client.conns), 2, "The pool should not open more than MaxConns connections")
+}
+
+func TestExecute_SUCCESS_Requests_Under_The_Pipeline_Limit(t *testing.T) {
+	var client *Client
+	var mu sync.Mutex
+	maxLoad := 0
+
+	addr := startServerWithConfig(t, server.ServerConfig{MaxConn: 10, MaxPipelinedRequests: 2}, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		client.mu.Lock()
+		for _, conn := range client.conns {
+			mu.Lock()
+			maxLoad = max(maxLoad, conn.load())
+			mu.Unlock()
+		}
+		client.mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+		return echo(ctx, request)
+	})
+	client, _ = NewClient(ClientConfig{Addr: addr, MaxConns: 2})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	results := make([]models.TaskResult, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = client.Execute(ctx, models.TaskRequest{Command: []string{"echo", fmt.Sprint(i)}})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		assert.Equal(t, fmt.Sprintf("echo %d", i), result.Output, "Requests over the pipeline limit should wait for a slot")
+	}
+	assert.LessOrEqual(t, maxLoad, 2, "A connection should not have more requests in flight than the server reads ahead")
+	assert.LessOrEqual(t, len(client.conns), 2, "The pool should not open more than MaxConns connections")
+}
+
+func TestExecute_SUCCESS_Propagates_Trace_Context(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		return models.TaskResult{Output: request.Traceparent}
+	})
+	client, _ := NewClient(ClientConfig{Addr: addr})
+	defer client.Close()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	result, err := client.Execute(ctx, models.TaskRequest{Command: []string{"echo"}})
+	assert.Nil(t, err, "Executing a request should not return error")
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", result.Output, "The trace context of ctx should be sent with the request")
+}
+
+func TestExecuteStream_SUCCESS(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		writeOutput, _ := server.OutputWriterFromContext(ctx)
+		writeOutput(models.OutputChunk{Type: models.MessageTypeOutput, ID: request.ID, Stream: models.StreamStdout, Data: "partial"})
+		return models.TaskResult{Output: "partial"}
+	})
+	client, _ := NewClient(ClientConfig{Addr: addr})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var chunks []models.OutputChunk
+	result, err := client.ExecuteStream(ctx, models.TaskRequest{Command: []string{"tail"}}, func(chunk models.OutputChunk) {
+		chunks = append(chunks, chunk)
+	})
+
+	assert.Nil(t, err, "Executing a request should not return error")
+	assert.Equal(t, "partial", result.Output, "The result should be returned after the output")
+	assert.Len(t, chunks, 1, "The output should be streamed while the command runs")
+	assert.Equal(t, "partial", chunks[0].Data, "The streamed output should be received")
+}
+
+func TestCancel_SUCCESS(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, request models.TaskRequest) models.TaskResult {
+		<-ctx.Done()
+		return models.TaskResult{ExitCode: -1, Error: "command cancelled"}
+	})
+	client, _ := NewClient(ClientConfig{Addr: addr})
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		client.Cancel("long")
+	}()
+
+	result, err := client.Execute(ctx, models.TaskRequest{ID: "long", Command: []string{"sleep", "60"}})
+	assert.Nil(t, err, "A cancelled request should still receive its result")
+	assert.Equal(t, "command cancelled", result.Error, "The command should be cancelled on the server")
+
+	err = client.Cancel("long")
+	assert.True(t, errors.Is(err, ErrRequestNotFound), "Finished requests can't be cancelled")
+}
+
+func TestExecute_ERROR_Retries_Exhausted(t *testing.T) {
+	dials := 0
+	client, _ := NewClient(ClientConfig{
+		Addr:         "localhost:1",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		Dial: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			dials++
+			return nil, errors.New("connection refused")
+		},
+	})
+
+	_, err := client.Execute(context.Background(), models.TaskRequest{Command: []string{"echo"}})
+	assert.NotNil(t, err, "A request that can't be sent should return error")
+	assert.Equal(t, 3, dials, "The request should be retried MaxRetries times")
+}
+
+func TestExecute_ERROR_Rejected(t *testing.T) {
+	addr := startServer(t, echo)
+	client, _ := NewClient(ClientConfig{Addr: addr, Codec: "xml"})
+	defer client.Close()
+
+	_, err := client.Execute(context.Background(), models.TaskRequest{Command: []string{"echo"}})
+	assert.True(t, errors.Is(err, ErrRejected), "Clients asking for an unsupported protocol should be rejected")
+}
+
+func TestExecute_ERROR_Client_Token(t *testing.T) {
+	addr := startServerWithConfig(t, server.ServerConfig{ClientToken: "secret"}, echo)
+
+	client, _ := NewClient(ClientConfig{Addr: addr, Token: "secret"})
+	defer client.Close()
+
+	result, err := client.Execute(context.Background(), models.TaskRequest{Command: []string{"echo", "hello"}})
+	assert.Nil(t, err, "Clients with the token should be accepted")
+	assert.Equal(t, "echo hello", result.Output, "Clients with the token should receive their result")
+
+	client, _ = NewClient(ClientConfig{Addr: addr, Token: "wrong", MaxRetries: -1})
+	defer client.Close()
+
+	_, err = client.Execute(context.Background(), models.TaskRequest{Command: []string{"echo", "hello"}})
+	assert.True(t, errors.Is(err, ErrRejected), "Clients with a wrong token should be rejected")
+}
@@ -0,0 +1,347 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+// conn is a pooled connection. Requests are pipelined over it and the messages read from the
+// server are dispatched by request id to the calls waiting for them.
+type conn struct {
+	netConn      net.Conn
+	framer       common.Framer
+	codec        common.Codec
+	protocol     models.HelloResult
+	writeTimeout time.Duration
+
+	// writeMu serializes the frames written by concurrent calls.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]*call
+	// err is set once the connection is broken, every later call fails with it.
+	err error
+
+	// inFlight counts the requests sent, forgotten ones included, until the server answers them.
+	// It can't exceed maxInFlight, the server stops reading the connection past its pipeline limit.
+	inFlight    int
+	maxInFlight int
+	// onRelease is called when a request is answered or the connection breaks.
+	onRelease func()
+}
+
+// call is a request waiting for its result.
+type call struct {
+	done     chan struct{}
+	result   models.TaskResult
+	err      error
+	onOutput func(models.OutputChunk)
+}
+
+// dial opens a connection and agrees on the protocol with a hello. Connections always use
+// length-prefixed framing so responses of any size can be read. onRelease is called every time
+// a request of the connection is answered, and when it breaks.
+func dial(ctx context.Context, config ClientConfig, onRelease func()) (*conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, config.DialTimeout)
+	defer cancel()
+
+	netConn, err := config.Dial(dialCtx, "tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TLS != nil {
+		tlsConn := tls.Client(netConn, config.TLS)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+
+	deadline, _ := dialCtx.Deadline()
+	netConn.SetDeadline(deadline)
+
+	c, err := handshake(netConn, config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	netConn.SetDeadline(time.Time{})
+	c.onRelease = onRelease
+	go c.readLoop()
+
+	return c, nil
+}
+
+func handshake(netConn net.Conn, config ClientConfig) (*conn, error) {
+	if _, err := netConn.Write(common.LengthPrefixedPreamble); err != nil {
+		return nil, err
+	}
+
+	framer := common.NewLengthPrefixedFramer(netConn, config.MaxResponseSize)
+	lib := common.NewCommonLib()
+
+	// The hello and its answer are always JSON, the negotiated codec applies from the next message on
+	hello, err := lib.Marshal(models.Hello{
+		Type:        models.MessageTypeHello,
+		Versions:    []int{models.ProtocolVersion},
+		Framing:     []string{common.FramingLengthPrefixed},
+		Codecs:      []string{config.Codec},
+		Compression: []string{config.Compression},
+		Features:    []string{models.FeaturePipelining, models.FeatureStreaming, models.FeatureCancel},
+		Token:       config.Token,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := framer.WriteFrame(hello); err != nil {
+		return nil, err
+	}
+
+	frame, err := framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.HelloResult
+	if err := lib.Unmarshal(frame, &result); err != nil {
+		return nil, fmt.Errorf("invalid hello from server: %w", err)
+	}
+
+	if result.Error != "" {
+		return nil, fmt.Errorf("%w: %s", ErrRejected, result.Error)
+	}
+
+	codec, err := lib.NewCodec(result.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Compression != common.CompressionNone {
+		compressor, err := lib.NewCompressor(result.Compression)
+		if err != nil {
+			return nil, err
+		}
+		framer = common.NewCompressedFramer(framer, compressor, common.DefaultCompressionThreshold, config.MaxResponseSize)
+	}
+
+	// Servers not announcing their limit use the default one
+	maxInFlight := result.MaxPipelined
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxPipelined
+	}
+
+	return &conn{
+		netConn:      netConn,
+		framer:       framer,
+		codec:        codec,
+		protocol:     result,
+		writeTimeout: config.WriteTimeout,
+		pending:      map[string]*call{},
+		maxInFlight:  maxInFlight,
+		onRelease:    func() {},
+	}, nil
+}
+
+// acquire reserves a slot for a request, it returns false when the connection is broken or
+// already has as many requests in flight as the server reads ahead.
+func (c *conn) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil || c.inFlight >= c.maxInFlight {
+		return false
+	}
+
+	c.inFlight++
+	return true
+}
+
+// release frees the slot of a request that wasn't sent.
+func (c *conn) release() {
+	c.mu.Lock()
+	if c.inFlight > 0 {
+		c.inFlight--
+	}
+	c.mu.Unlock()
+
+	c.onRelease()
+}
+
+// send writes a request and returns the call that will receive its result. An error means the
+// request didn't reach the server, so it is safe to retry it. The slot of the request must be
+// acquired first, it is released when the result arrives.
+func (c *conn) send(request models.TaskRequest, onOutput func(models.OutputChunk)) (*call, error) {
+	data, err := c.codec.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &call{
+		done:     make(chan struct{}),
+		onOutput: onOutput,
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.pending[request.ID] = pending
+	c.mu.Unlock()
+
+	if err := c.write(data); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// cancel asks the server to stop a request sent on this connection.
+func (c *conn) cancel(id string) error {
+	data, err := c.codec.Marshal(models.Envelope{
+		Type: models.MessageTypeCancel,
+		ID:   id,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.write(data)
+}
+
+func (c *conn) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.netConn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	return c.framer.WriteFrame(data)
+}
+
+// forget stops waiting for a request, its result is discarded when it arrives.
+func (c *conn) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// load is the number of requests in flight.
+func (c *conn) load() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.inFlight
+}
+
+// full reports whether the connection can't take another request until one is answered.
+func (c *conn) full() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.inFlight >= c.maxInFlight
+}
+
+func (c *conn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err != nil
+}
+
+func (c *conn) streaming() bool {
+	return slices.Contains(c.protocol.Features, models.FeatureStreaming)
+}
+
+// readLoop dispatches the output chunks and results sent by the server until the connection breaks.
+func (c *conn) readLoop() {
+	for {
+		frame, err := c.framer.ReadFrame()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		var envelope models.Envelope
+		if err := c.codec.Unmarshal(frame, &envelope); err != nil {
+			c.fail(fmt.Errorf("invalid message from server: %w", err))
+			return
+		}
+
+		if envelope.Type == models.MessageTypeOutput {
+			var chunk models.OutputChunk
+			if err := c.codec.Unmarshal(frame, &chunk); err != nil {
+				c.fail(fmt.Errorf("invalid output from server: %w", err))
+				return
+			}
+
+			c.mu.Lock()
+			pending := c.pending[chunk.ID]
+			c.mu.Unlock()
+
+			if pending != nil && pending.onOutput != nil {
+				pending.onOutput(chunk)
+			}
+			continue
+		}
+
+		var result models.TaskResult
+		if err := c.codec.Unmarshal(frame, &result); err != nil {
+			c.fail(fmt.Errorf("invalid result from server: %w", err))
+			return
+		}
+
+		// Results without an id report connection errors, like a request too large, and the server closes the connection
+		if result.ID == "" {
+			c.fail(fmt.Errorf("server error: %s", result.Error))
+			return
+		}
+
+		// Every request is answered once, forgotten ones too
+		c.mu.Lock()
+		pending, ok := c.pending[result.ID]
+		delete(c.pending, result.ID)
+		if c.inFlight > 0 {
+			c.inFlight--
+		}
+		c.mu.Unlock()
+
+		if ok {
+			pending.result = result
+			close(pending.done)
+		}
+		c.onRelease()
+	}
+}
+
+// fail closes the connection, failing every request still waiting for a result.
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.onRelease()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = fmt.Errorf("%w: %v", ErrConnectionLost, err)
+	}
+
+	for id, pending := range c.pending {
+		pending.err = c.err
+		close(pending.done)
+		delete(c.pending, id)
+	}
+	c.inFlight = 0
+
+	c.netConn.Close()
+}
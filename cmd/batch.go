@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/hriqueXimenes/sumo_logic_server/client"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+// executeBatch runs a batch file, or stdin when the file is -, printing a summary to stderr.
+// It returns the exit code of the client, 1 when any request didn't succeed.
+func executeBatch(taskClient *client.Client, file string, parallel int, defaultTimeout int, taskTimeout int) int {
+	input := io.Reader(os.Stdin)
+	if file != "-" {
+		batchFile, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening batch file:", err)
+			return exitCodeClientError
+		}
+		defer batchFile.Close()
+		input = batchFile
+	}
+
+	// Interrupting the client cancels the requests still running on the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary := runBatch(ctx, taskClient, input, parallel, defaultTimeout, taskTimeout, os.Stdout)
+	fmt.Fprintf(os.Stderr, "%d requests: %d succeeded, %d failed, %d timed out\n", summary.total, summary.succeeded, summary.failed, summary.timedOut)
+
+	if summary.succeeded != summary.total {
+		return 1
+	}
+
+	return 0
+}
+
+// timeoutError is the error reported by the server when a command exceeds its timeout.
+const timeoutError = models.ErrorTimeoutExceeded
+
+// batchSummary counts the results of a batch.
+type batchSummary struct {
+	mu        sync.Mutex
+	total     int
+	succeeded int
+	failed    int
+	timedOut  int
+}
+
+func (summary *batchSummary) add(result models.TaskResult) {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+
+	summary.total++
+	switch {
+	case result.Error == timeoutError:
+		summary.timedOut++
+	case result.ExitCode != 0:
+		summary.failed++
+	default:
+		summary.succeeded++
+	}
+}
+
+// runBatch sends every TaskRequest line of input, up to parallel at a time, and writes each
+// TaskResult as a JSON line to output as they finish. Lines without an id are identified as
+// line-<number>, and lines reusing the id of a previous line are rejected. Lines without a timeout
+// use defaultTimeout, or taskTimeout for the lines naming a task: 0 keeps the timeout of the task
+// on the server.
+func runBatch(ctx context.Context, taskClient *client.Client, input io.Reader, parallel int, defaultTimeout int, taskTimeout int, output io.Writer) *batchSummary {
+	summary := &batchSummary{}
+
+	var writeMu sync.Mutex
+	writeResult := func(result models.TaskResult) {
+		summary.add(result)
+
+		data, err := json.Marshal(result)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"id":%q,"exit_code":-1,"error":%q}`, result.ID, err.Error()))
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		output.Write(append(data, '\n'))
+	}
+
+	if parallel <= 0 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), common.DefaultMaxFrameSize)
+
+	// ids holds the id of every line sent, results with the same id couldn't be told apart
+	ids := map[string]int{}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var request models.TaskRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			writeResult(models.TaskResult{
+				ID:       lineID(line),
+				ExitCode: exitCodeRequestFailed,
+				Error:    fmt.Sprintf("Invalid request on line %d: %v", line, err),
+			})
+			continue
+		}
+
+		if request.ID == "" {
+			request.ID = lineID(line)
+		}
+
+		if previous, ok := ids[request.ID]; ok {
+			writeResult(models.TaskResult{
+				ID:       request.ID,
+				Command:  request.Command,
+				ExitCode: exitCodeRequestFailed,
+				Error:    fmt.Sprintf("Duplicate id %q on line %d, already used on line %d", request.ID, line, previous),
+			})
+			continue
+		}
+		ids[request.ID] = line
+
+		if request.Timeout == 0 && request.Task != "" {
+			request.Timeout = taskTimeout
+		} else if request.Timeout == 0 {
+			request.Timeout = defaultTimeout
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return summary
+		}
+
+		inFlight.Add(1)
+		go func(request models.TaskRequest) {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+
+			result, err := taskClient.Execute(ctx, request)
+			if err != nil {
+				result = models.TaskResult{
+					ID:       request.ID,
+					Command:  request.Command,
+					ExitCode: exitCodeRequestFailed,
+					Error:    err.Error(),
+				}
+			}
+
+			writeResult(result)
+		}(request)
+	}
+
+	if err := scanner.Err(); err != nil {
+		writeResult(models.TaskResult{
+			ID:       lineID(line + 1),
+			ExitCode: exitCodeRequestFailed,
+			Error:    fmt.Sprintf("Error reading line %d: %v", line+1, err),
+		})
+	}
+
+	return summary
+}
+
+// lineID identifies the result of a line without an id.
+func lineID(line int) string {
+	return "line-" + strconv.Itoa(line)
+}
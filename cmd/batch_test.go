@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/client"
+	"github.com/hriqueXimenes/sumo_logic_server/server"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBatch_ERROR_Duplicate_Ids(t *testing.T) {
+	port := rand.Intn(2001) + 9000
+	taskServer, err := server.NewServer(server.ServerConfig{Port: port, Addr: "localhost"})
+	assert.Nil(t, err, "Creating the server should not return error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go taskServer.Start(ctx, OnReceiveSignal)
+
+	taskClient, err := client.NewClient(client.ClientConfig{Addr: fmt.Sprintf("localhost:%d", port)})
+	assert.Nil(t, err, "Creating the client should not return error")
+	defer taskClient.Close()
+
+	input := strings.Join([]string{
+		`{"command":["echo","first"]}`,
+		`{"id":"1","command":["echo","explicit"]}`,
+		`{"id":"line-1","command":["echo","collision"]}`,
+		`{"id":"1","command":["echo","duplicate"]}`,
+	}, "\n")
+
+	var output bytes.Buffer
+	summary := runBatch(ctx, taskClient, strings.NewReader(input), 1, 1000, 0, &output)
+
+	// Results are written as they finish, the rejected lines first
+	outputs := map[string]string{}
+	failures := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var result models.TaskResult
+		assert.Nil(t, json.Unmarshal([]byte(line), &result), "Every result should be a JSON line")
+		if result.Error != "" {
+			failures[result.ID] = result.Error
+		} else {
+			outputs[result.ID] = result.Output
+		}
+	}
+
+	assert.Equal(t, 4, summary.total, "Every line should have a result")
+	assert.Equal(t, 2, summary.succeeded, "Only the lines with a unique id should be sent")
+	assert.Equal(t, "first\n", outputs["line-1"], "Lines without an id should be identified by their line number")
+	assert.Contains(t, failures["line-1"], "Duplicate id \"line-1\" on line 3", "Ids colliding with a line number should be rejected")
+	assert.Equal(t, "explicit\n", outputs["1"], "Explicit ids should be kept")
+	assert.Contains(t, failures["1"], "already used on line 2", "Duplicate ids should be rejected")
+}
@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/hriqueXimenes/sumo_logic_server/client"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Load generator to benchmark the server",
+		Long: `Opens concurrent connections to the server and sends a mix of commands at a target rate,
+then reports throughput, latency percentiles, queue wait times and errors.`,
+		Run: benchCommandExecute,
+	}
+)
+
+func init() {
+	benchCmd.Flags().IntP("port", "p", 3000, "Port of the server to benchmark")
+	benchCmd.Flags().StringP("address", "a", "localhost", "Address of the server to benchmark")
+	benchCmd.Flags().IntP("connections", "c", 4, "Number of concurrent connections, each one sends a request at a time")
+	benchCmd.Flags().Float64P("rate", "r", 0, "Target requests per second across all connections, 0 sends as fast as possible")
+	benchCmd.Flags().DurationP("duration", "d", 10*time.Second, "How long to send requests for")
+	benchCmd.Flags().IntP("requests", "n", 0, "Number of requests to send, overrides --duration when set")
+	benchCmd.Flags().StringArrayP("command", "s", []string{"echo bench"}, "Command line to send, prefix it with weight: to send it more often (e.g. 3:sleep 0.1)")
+	benchCmd.Flags().IntP("timeout", "t", 1000, "Command timeout limit")
+	benchCmd.Flags().String("token", "", "Client token sent to servers that require one. Defaults to "+envClientToken+".")
+	rootCmd.AddCommand(benchCmd)
+}
+
+// benchCommand is one of the commands of the mix, sent weight times as often as a command of weight 1.
+type benchCommand struct {
+	command []string
+	weight  int
+}
+
+// benchSample is the outcome of a single request.
+type benchSample struct {
+	latency   time.Duration
+	queueWait time.Duration
+	// sent is false when no result was received, so the queue wait is unknown
+	sent bool
+	err  string
+}
+
+func benchCommandExecute(cmd *cobra.Command, args []string) {
+	port, err := cmd.Flags().GetInt("port")
+	if err != nil {
+		fmt.Println("Error getting port:", err)
+		return
+	}
+
+	address, err := cmd.Flags().GetString("address")
+	if err != nil {
+		fmt.Println("Error getting address:", err)
+		return
+	}
+
+	connections, err := cmd.Flags().GetInt("connections")
+	if err != nil {
+		fmt.Println("Error getting connections:", err)
+		return
+	}
+
+	rate, err := cmd.Flags().GetFloat64("rate")
+	if err != nil {
+		fmt.Println("Error getting rate:", err)
+		return
+	}
+
+	duration, err := cmd.Flags().GetDuration("duration")
+	if err != nil {
+		fmt.Println("Error getting duration:", err)
+		return
+	}
+
+	requests, err := cmd.Flags().GetInt("requests")
+	if err != nil {
+		fmt.Println("Error getting requests:", err)
+		return
+	}
+
+	commandLines, err := cmd.Flags().GetStringArray("command")
+	if err != nil {
+		fmt.Println("Error getting commands:", err)
+		return
+	}
+
+	timeout, err := cmd.Flags().GetInt("timeout")
+	if err != nil {
+		fmt.Println("Error getting timeout:", err)
+		return
+	}
+
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		fmt.Println("Error getting token:", err)
+		return
+	}
+	if token == "" {
+		token = os.Getenv(envClientToken)
+	}
+
+	if connections <= 0 {
+		fmt.Println("You must use at least one connection")
+		return
+	}
+
+	// Rates over 1e9 would start requests less than a nanosecond apart
+	if math.IsNaN(rate) || rate < 0 || rate > 1e9 {
+		fmt.Println("Error: the rate must be between 0 and 1e9 requests per second")
+		return
+	}
+
+	mix, err := parseBenchMix(commandLines)
+	if err != nil {
+		fmt.Println("Error parsing commands:", err)
+		return
+	}
+
+	// Every worker has its own client, so each one holds a connection of its own
+	clients := make([]*client.Client, connections)
+	for i := range clients {
+		clients[i], err = client.NewClient(client.ClientConfig{
+			Addr:     net.JoinHostPort(address, strconv.Itoa(port)),
+			MaxConns: 1,
+			Token:    token,
+		})
+		if err != nil {
+			fmt.Println("Error creating client:", err)
+			return
+		}
+		defer clients[i].Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if requests <= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	fmt.Printf("Benchmarking %s with %d connections...\n", net.JoinHostPort(address, strconv.Itoa(port)), connections)
+
+	start := time.Now()
+	samples := runBench(ctx, clients, mix, rate, requests, timeout)
+	elapsed := time.Since(start)
+
+	printBenchReport(samples, elapsed)
+}
+
+// parseBenchMix parses the command lines of the mix, with their optional weight prefix.
+func parseBenchMix(commandLines []string) ([]benchCommand, error) {
+	mix := make([]benchCommand, 0, len(commandLines))
+	for _, line := range commandLines {
+		weight := 1
+		if prefix, rest, ok := strings.Cut(line, ":"); ok {
+			if parsed, err := strconv.Atoi(prefix); err == nil {
+				if parsed <= 0 {
+					return nil, fmt.Errorf("the weight of %q must be positive", line)
+				}
+				weight, line = parsed, rest
+			}
+		}
+
+		command, err := shlex.Split(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command %q: %w", line, err)
+		}
+
+		if len(command) == 0 {
+			return nil, fmt.Errorf("empty command in %q", line)
+		}
+
+		mix = append(mix, benchCommand{command: command, weight: weight})
+	}
+
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("no commands to send")
+	}
+
+	return mix, nil
+}
+
+// runBench sends requests from every client until ctx is done or the number of requests is reached.
+// With a rate, requests are started at evenly spaced intervals, otherwise as fast as the clients can.
+func runBench(ctx context.Context, clients []*client.Client, mix []benchCommand, rate float64, requests int, timeout int) []benchSample {
+	var totalWeight int
+	for _, command := range mix {
+		totalWeight += command.weight
+	}
+
+	pick := func(random *rand.Rand) []string {
+		n := random.Intn(totalWeight)
+		for _, command := range mix {
+			if n < command.weight {
+				return command.command
+			}
+			n -= command.weight
+		}
+		return mix[len(mix)-1].command
+	}
+
+	// tokens hands out the permission to send a request, limiting the rate and the number of requests
+	tokens := make(chan struct{})
+	go func() {
+		defer close(tokens)
+
+		var ticker *time.Ticker
+		if rate > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+		}
+
+		for sent := 0; requests <= 0 || sent < requests; sent++ {
+			if ticker != nil {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var samples []benchSample
+
+	var workers sync.WaitGroup
+	for i, taskClient := range clients {
+		workers.Add(1)
+		go func(seed int64, taskClient *client.Client) {
+			defer workers.Done()
+			random := rand.New(rand.NewSource(seed))
+
+			for range tokens {
+				sample := benchRequest(ctx, taskClient, models.TaskRequest{
+					Command: pick(random),
+					Timeout: timeout,
+				})
+
+				// Requests interrupted by the end of the benchmark are not counted
+				if ctx.Err() != nil && sample.err == context.Canceled.Error() {
+					return
+				}
+
+				mu.Lock()
+				samples = append(samples, sample)
+				mu.Unlock()
+			}
+		}(time.Now().UnixNano()+int64(i), taskClient)
+	}
+	workers.Wait()
+
+	return samples
+}
+
+func benchRequest(ctx context.Context, taskClient *client.Client, request models.TaskRequest) benchSample {
+	start := time.Now()
+	result, err := taskClient.Execute(ctx, request)
+	latency := time.Since(start)
+
+	sample := benchSample{latency: latency}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		sample.err = context.Canceled.Error()
+	case err != nil:
+		sample.err = benchErrorKind(err)
+	case result.Error == timeoutError:
+		sample.err = timeoutError
+	case result.ExitCode != 0:
+		sample.err = fmt.Sprintf("exit code %d", result.ExitCode)
+	}
+
+	// Servers reporting their timing tell how long the request waited for a free slot, for the
+	// others it is estimated as the time not spent running the command
+	if err == nil {
+		sample.sent = true
+		if result.StartedAtNs != 0 {
+			sample.queueWait = time.Duration(result.QueueWaitMs * float64(time.Millisecond))
+		} else {
+			execution := time.Duration(result.DurationMs * float64(time.Millisecond))
+			sample.queueWait = max(latency-execution, 0)
+		}
+	}
+
+	return sample
+}
+
+// benchErrorKind groups the errors of the client, whose messages carry details like the addresses
+// of the connection, into the kinds reported by the benchmark.
+func benchErrorKind(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, client.ErrRejected):
+		return "connection rejected"
+	case errors.Is(err, client.ErrConnectionLost):
+		return "connection lost"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "network timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	}
+
+	return err.Error()
+}
+
+func printBenchReport(samples []benchSample, elapsed time.Duration) {
+	var latencies, queueWaits []time.Duration
+	failures := map[string]int{}
+	for _, sample := range samples {
+		latencies = append(latencies, sample.latency)
+		if sample.sent {
+			queueWaits = append(queueWaits, sample.queueWait)
+		}
+		if sample.err != "" {
+			failures[sample.err]++
+		}
+	}
+
+	failed := 0
+	for _, count := range failures {
+		failed += count
+	}
+
+	fmt.Printf("Requests:    %d (%d succeeded, %d failed)\n", len(samples), len(samples)-failed, failed)
+	fmt.Printf("Duration:    %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %.2f req/s\n", float64(len(samples))/elapsed.Seconds())
+
+	if len(samples) == 0 {
+		return
+	}
+
+	fmt.Printf("Latency:     %s\n", formatPercentiles(latencies))
+	if len(queueWaits) > 0 {
+		fmt.Printf("Queue wait:  %s\n", formatPercentiles(queueWaits))
+	}
+
+	if len(failures) == 0 {
+		return
+	}
+
+	kinds := make([]string, 0, len(failures))
+	for kind := range failures {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return failures[kinds[i]] > failures[kinds[j]] })
+
+	fmt.Println("Errors:")
+	for _, kind := range kinds {
+		fmt.Printf("  %6d  %s\n", failures[kind], kind)
+	}
+}
+
+func formatPercentiles(durations []time.Duration) string {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var total time.Duration
+	for _, duration := range durations {
+		total += duration
+	}
+	mean := total / time.Duration(len(durations))
+
+	return fmt.Sprintf("min %s  mean %s  p50 %s  p90 %s  p99 %s  max %s",
+		round(durations[0]), round(mean), round(percentile(durations, 0.50)),
+		round(percentile(durations, 0.90)), round(percentile(durations, 0.99)), round(durations[len(durations)-1]))
+}
+
+// percentile returns the p-th percentile of sorted durations, using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
+
+func round(duration time.Duration) time.Duration {
+	return duration.Round(10 * time.Microsecond)
+}
@@ -1,15 +1,36 @@
 package cmd
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
+	"github.com/hriqueXimenes/sumo_logic_server/client"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
 	"github.com/hriqueXimenes/sumo_logic_server/server/models"
 	"github.com/spf13/cobra"
 )
 
+const (
+	outputText = "text"
+	outputRaw  = "raw"
+	outputJSON = "json"
+
+	// exitCodeClientError is returned when the request couldn't be executed, like ssh does.
+	exitCodeClientError = 255
+	// exitCodeRequestFailed is set in the results of requests that couldn't be executed, like the server does.
+	exitCodeRequestFailed = -1
+)
+
+// envClientToken is the client token sent when --token isn't set, the same variable configures the server.
+const envClientToken = envPrefix + "AUTH_CLIENT_TOKEN"
+
 var (
 	clientCmd = &cobra.Command{
 		Use:   "client",
@@ -24,6 +45,15 @@ func init() {
 	clientCmd.Flags().StringP("address", "a", "localhost", "Address of the server that we will perform requests")
 	clientCmd.Flags().StringArrayP("script", "s", []string{}, "Command and args of the script to execute")
 	clientCmd.Flags().IntP("timeout", "t", 1000, "Command timeout limit")
+	clientCmd.Flags().String("shell", "", "Script to execute with the shell of the server instead of a script command, when its policy allows this client.")
+	clientCmd.Flags().String("task", "", "Name of a task registered on the server to execute instead of a script.")
+	clientCmd.Flags().StringToString("param", map[string]string{}, "Parameter of the task as name=value. Can be repeated.")
+	clientCmd.Flags().StringP("output", "o", outputText, "How the response is printed: text, raw (the command output, as if it ran locally) or json.")
+	clientCmd.Flags().StringP("batch", "b", "", "JSONL file with a TaskRequest per line to execute, - reads them from stdin. Results are written as JSONL.")
+	clientCmd.Flags().Int("parallel", 4, "Number of batch requests executed at the same time.")
+	clientCmd.Flags().String("codec", common.CodecJSON, "Encoding of the messages exchanged with the server: json, msgpack or cbor.")
+	clientCmd.Flags().String("token", "", "Client token sent to servers that require one. Defaults to "+envClientToken+".")
+	clientCmd.Flags().BoolP("interactive", "i", false, "Open a prompt where every line is executed as a command over a persistent connection.")
 	rootCmd.AddCommand(clientCmd)
 }
 
@@ -46,56 +76,220 @@ func clientCommandExecute(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		fmt.Println("Error getting output:", err)
+		return
+	}
+
 	scriptArgs, err := cmd.Flags().GetStringArray("script")
 	if err != nil {
 		fmt.Println("Error getting script arguments:", err)
 		return
 	}
 
-	if len(scriptArgs) == 0 {
-		fmt.Println("You must provide at least a script command using --script")
+	batch, err := cmd.Flags().GetString("batch")
+	if err != nil {
+		fmt.Println("Error getting batch:", err)
 		return
 	}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%v", address, port))
+	parallel, err := cmd.Flags().GetInt("parallel")
 	if err != nil {
-		fmt.Println("Error connecting to server:", err)
-		os.Exit(1)
+		fmt.Println("Error getting parallel:", err)
+		return
 	}
-	defer conn.Close()
 
-	request := models.TaskRequest{
-		Command: scriptArgs,
-		Timeout: timeout,
+	interactive, err := cmd.Flags().GetBool("interactive")
+	if err != nil {
+		fmt.Println("Error getting interactive:", err)
+		return
 	}
 
-	data, err := json.Marshal(request)
+	task, err := cmd.Flags().GetString("task")
 	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
+		fmt.Println("Error getting task:", err)
 		return
 	}
 
-	_, err = conn.Write(append(data, '\n'))
+	params, err := cmd.Flags().GetStringToString("param")
 	if err != nil {
-		fmt.Println("Error sending request:", err)
+		fmt.Println("Error getting task parameters:", err)
 		return
 	}
 
-	fmt.Println("Request sent:", string(data))
+	shell, err := cmd.Flags().GetString("shell")
+	if err != nil {
+		fmt.Println("Error getting shell:", err)
+		return
+	}
 
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+	codec, err := cmd.Flags().GetString("codec")
 	if err != nil {
-		fmt.Println("Error reading response:", err)
+		fmt.Println("Error getting codec:", err)
 		return
 	}
 
-	var response models.TaskResult
-	err = json.Unmarshal(buffer[:n], &response)
+	token, err := cmd.Flags().GetString("token")
 	if err != nil {
-		fmt.Println("Error decoding response:", err)
+		fmt.Println("Error getting token:", err)
+		return
+	}
+	if token == "" {
+		token = os.Getenv(envClientToken)
+	}
+
+	if len(scriptArgs) == 0 && shell == "" && task == "" && batch == "" && !interactive {
+		fmt.Println("You must provide at least a script command using --script, a shell script using --shell, a task using --task, a batch file using --batch or --interactive")
+		return
+	}
+
+	if output != outputText && output != outputRaw && output != outputJSON {
+		fmt.Printf("Invalid output %q, it must be %s, %s or %s\n", output, outputText, outputRaw, outputJSON)
 		return
 	}
 
-	fmt.Printf("Response received: %+v\n", response)
+	if codec != common.CodecJSON && codec != common.CodecMsgPack && codec != common.CodecCBOR {
+		fmt.Printf("Invalid codec %q, it must be %s, %s or %s\n", codec, common.CodecJSON, common.CodecMsgPack, common.CodecCBOR)
+		return
+	}
+
+	// Requests are pipelined, a connection carries up to the 16 requests in flight the server allows by default
+	taskClient, err := client.NewClient(client.ClientConfig{
+		Addr:     net.JoinHostPort(address, strconv.Itoa(port)),
+		MaxConns: (parallel + 15) / 16,
+		Codec:    codec,
+		Token:    token,
+	})
+	if err != nil {
+		fmt.Println("Error creating client:", err)
+		os.Exit(exitCodeClientError)
+	}
+	defer taskClient.Close()
+
+	if batch != "" {
+		// Task lines use the timeout set on the server unless one is asked for
+		taskTimeout := 0
+		if cmd.Flags().Changed("timeout") {
+			taskTimeout = timeout
+		}
+		os.Exit(executeBatch(taskClient, batch, parallel, timeout, taskTimeout))
+	}
+
+	if interactive {
+		os.Exit(runInteractive(taskClient, timeout))
+	}
+
+	request := models.TaskRequest{
+		Command: scriptArgs,
+		Timeout: timeout,
+	}
+
+	if shell != "" {
+		request = models.TaskRequest{
+			Shell:   shell,
+			Timeout: timeout,
+		}
+	}
+
+	if task != "" {
+		// Tasks use the timeout set on the server unless one is asked for
+		request = models.TaskRequest{Task: task, Params: map[string]interface{}{}}
+		for name, value := range params {
+			request.Params[name] = value
+		}
+		if cmd.Flags().Changed("timeout") {
+			request.Timeout = timeout
+		}
+	}
+
+	if output == outputText {
+		data, err := json.Marshal(request)
+		if err != nil {
+			fmt.Println("Error marshaling JSON:", err)
+			return
+		}
+		fmt.Println("Request sent:", string(data))
+	}
+
+	// Errors are kept out of stdout when it carries the response in a machine readable form
+	errOutput := os.Stdout
+	if output != outputText {
+		errOutput = os.Stderr
+	}
+
+	// Interrupting the client cancels the command on the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	response, err := taskClient.Execute(ctx, request)
+	if err != nil {
+		fmt.Fprintln(errOutput, "Error executing request:", err)
+		taskClient.Close()
+		os.Exit(exitCodeClientError)
+	}
+
+	switch output {
+	case outputRaw:
+		err = writeRawResult(response)
+	case outputJSON:
+		err = writeJSONResult(response)
+	default:
+		fmt.Printf("Response received: %+v\n", response)
+	}
+
+	if err != nil {
+		fmt.Fprintln(errOutput, "Error writing response:", err)
+		taskClient.Close()
+		os.Exit(exitCodeClientError)
+	}
+
+	// Exit with the code of the remote command, like ssh, so the client can be used in shell pipelines
+	taskClient.Close()
+	os.Exit(response.ExitCode)
+}
+
+// writeRawResult writes the output of the command to stdout and its errors to stderr, as if it ran locally.
+func writeRawResult(response models.TaskResult) error {
+	if _, err := os.Stdout.WriteString(decodeOutput(response)); err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		if _, err := fmt.Fprint(os.Stderr, decodeError(response)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeJSONResult(response models.TaskResult) error {
+	data, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(string(data))
+	return err
+}
+
+// decodeOutput returns the output of a result, decoding it when the server sent it as base64.
+func decodeOutput(result models.TaskResult) string {
+	return decodeBase64(result.Output, result.OutputEncoding)
+}
+
+// decodeError returns the error of a result, decoding the stderr the server sent as base64.
+func decodeError(result models.TaskResult) string {
+	return decodeBase64(result.Error, result.ErrorEncoding)
+}
+
+func decodeBase64(data string, encoding string) string {
+	if encoding == models.OutputEncodingBase64 {
+		if decoded, err := base64.StdEncoding.DecodeString(data); err == nil {
+			return string(decoded)
+		}
+	}
+
+	return data
 }
@@ -0,0 +1,529 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hriqueXimenes/sumo_logic_server/server"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables overriding the settings of the server,
+// SUMOLOGIC_<SECTION>_<KEY> (e.g. SUMOLOGIC_LIMITS_MAX_REQUESTS).
+const envPrefix = "SUMOLOGIC_"
+
+// envConfigFile names the config file when --config isn't set.
+const envConfigFile = envPrefix + "CONFIG"
+
+// serverConfig holds every setting of the server. Settings start with the defaults of the flags,
+// then come from the config file, the environment and the flags set on the command line, in that order.
+//
+// Settings tagged with reload:"live" are applied on SIGHUP without a restart.
+type serverConfig struct {
+	Server    serverSettings   `yaml:"server" toml:"server"`
+	Listeners listenerSettings `yaml:"listeners" toml:"listeners"`
+	Limits    limitSettings    `yaml:"limits" toml:"limits"`
+	Auth      authSettings     `yaml:"auth" toml:"auth"`
+	Policies  policySettings   `yaml:"policies" toml:"policies"`
+	Tasks     taskSettings     `yaml:"tasks" toml:"tasks"`
+	Logging   loggingSettings  `yaml:"logging" toml:"logging"`
+	Tracing   tracingSettings  `yaml:"tracing" toml:"tracing"`
+	Metrics   metricsSettings  `yaml:"metrics" toml:"metrics"`
+}
+
+type serverSettings struct {
+	ID string `yaml:"id" toml:"id" flag:"server-id"`
+}
+
+type listenerSettings struct {
+	Address string `yaml:"address" toml:"address" flag:"address"`
+	Port    int    `yaml:"port" toml:"port" flag:"port"`
+	HTTP    string `yaml:"http" toml:"http" flag:"http-address"`
+	GRPC    string `yaml:"grpc" toml:"grpc" flag:"grpc-address"`
+	Metrics string `yaml:"metrics" toml:"metrics" flag:"metrics-address"`
+	Admin   string `yaml:"admin" toml:"admin" flag:"admin-address"`
+}
+
+type limitSettings struct {
+	MaxConnections       int           `yaml:"max_connections" toml:"max_connections" flag:"maxconn"`
+	MaxRequests          int           `yaml:"max_requests" toml:"max_requests" flag:"max-requests" reload:"live"`
+	MaxPipelinedRequests int           `yaml:"max_pipelined_requests" toml:"max_pipelined_requests" flag:"max-pipelined-requests" reload:"live"`
+	MaxRequestSize       int           `yaml:"max_request_size" toml:"max_request_size" flag:"max-request-size" reload:"live"`
+	CompressionThreshold int           `yaml:"compression_threshold" toml:"compression_threshold" flag:"compression-threshold" reload:"live"`
+	IdleTimeout          time.Duration `yaml:"idle_timeout" toml:"idle_timeout" flag:"idle-timeout" reload:"live"`
+	ReadTimeout          time.Duration `yaml:"read_timeout" toml:"read_timeout" flag:"read-timeout" reload:"live"`
+	WriteTimeout         time.Duration `yaml:"write_timeout" toml:"write_timeout" flag:"write-timeout" reload:"live"`
+	DrainTimeout         time.Duration `yaml:"drain_timeout" toml:"drain_timeout" flag:"drain-timeout"`
+}
+
+type authSettings struct {
+	AdminToken  string `yaml:"admin_token" toml:"admin_token" flag:"admin-token" reload:"live"`
+	ClientToken string `yaml:"client_token" toml:"client_token" flag:"client-token" reload:"live"`
+}
+
+type policySettings struct {
+	AllowedCommands  []string `yaml:"allowed_commands" toml:"allowed_commands" reload:"live"`
+	DeniedCommands   []string `yaml:"denied_commands" toml:"denied_commands" reload:"live"`
+	ShellClients     []string `yaml:"shell_clients" toml:"shell_clients" flag:"shell-clients" reload:"live"`
+	ShellInterpreter []string `yaml:"shell_interpreter" toml:"shell_interpreter" flag:"shell-interpreter" reload:"live"`
+	ClientEnv        []string `yaml:"client_env" toml:"client_env" flag:"client-env" reload:"live"`
+	ClientCwd        []string `yaml:"client_cwd" toml:"client_cwd" flag:"client-cwd" reload:"live"`
+}
+
+type taskSettings struct {
+	Only     bool                      `yaml:"only" toml:"only" flag:"tasks-only" reload:"live"`
+	Registry map[string]taskDefinition `yaml:"registry" toml:"registry" reload:"live"`
+}
+
+// taskDefinition is a named task of the registry, see server.Task.
+type taskDefinition struct {
+	Description   string                     `yaml:"description" toml:"description"`
+	Command       []string                   `yaml:"command" toml:"command"`
+	Params        map[string]paramDefinition `yaml:"params" toml:"params"`
+	Env           map[string]string          `yaml:"env" toml:"env"`
+	Cwd           string                     `yaml:"cwd" toml:"cwd"`
+	Timeout       time.Duration              `yaml:"timeout" toml:"timeout"`
+	MaxConcurrent int                        `yaml:"max_concurrent" toml:"max_concurrent"`
+}
+
+// paramDefinition is a parameter of a task, see server.TaskParam.
+type paramDefinition struct {
+	Description string   `yaml:"description" toml:"description"`
+	Type        string   `yaml:"type" toml:"type"`
+	Required    bool     `yaml:"required" toml:"required"`
+	Default     string   `yaml:"default" toml:"default"`
+	Enum        []string `yaml:"enum" toml:"enum"`
+	Pattern     string   `yaml:"pattern" toml:"pattern"`
+	MaxLength   int      `yaml:"max_length" toml:"max_length"`
+	Min         *int     `yaml:"min" toml:"min"`
+	Max         *int     `yaml:"max" toml:"max"`
+}
+
+type loggingSettings struct {
+	Level      string `yaml:"level" toml:"level" flag:"log-level" reload:"live"`
+	Format     string `yaml:"format" toml:"format" flag:"log-format"`
+	Output     string `yaml:"output" toml:"output" flag:"log-output"`
+	MaxSizeMB  int    `yaml:"max_size_mb" toml:"max_size_mb" flag:"log-max-size"`
+	MaxBackups int    `yaml:"max_backups" toml:"max_backups" flag:"log-max-backups"`
+	MaxAgeDays int    `yaml:"max_age_days" toml:"max_age_days" flag:"log-max-age"`
+	Compress   bool   `yaml:"compress" toml:"compress" flag:"log-compress"`
+	// AuditOutput receives the audit records, with the same format and rotation. No audit when empty.
+	AuditOutput string `yaml:"audit_output" toml:"audit_output" flag:"audit-output"`
+
+	RedactFields   []string `yaml:"redact_fields" toml:"redact_fields" flag:"log-redact-fields" reload:"live"`
+	RedactPatterns []string `yaml:"redact_patterns" toml:"redact_patterns" flag:"log-redact-pattern" reload:"live"`
+}
+
+type tracingSettings struct {
+	Exporter     string  `yaml:"exporter" toml:"exporter" flag:"trace-exporter"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint" flag:"otlp-endpoint"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio" flag:"trace-sample-ratio"`
+}
+
+type metricsSettings struct {
+	MaxCommands int `yaml:"max_commands" toml:"max_commands" flag:"metrics-max-commands"`
+}
+
+// setting is a single field of serverConfig.
+type setting struct {
+	// name is the section and key of the setting in the config file, e.g. limits.max_requests.
+	name  string
+	env   string
+	flag  string
+	live  bool
+	value reflect.Value
+}
+
+// settings lists the fields of config.
+func settings(config *serverConfig) []setting {
+	var list []setting
+
+	sections := reflect.ValueOf(config).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("yaml")
+		fields := sections.Field(i)
+
+		for j := 0; j < fields.NumField(); j++ {
+			field := fields.Type().Field(j)
+			key := field.Tag.Get("yaml")
+
+			list = append(list, setting{
+				name:  section + "." + key,
+				env:   envPrefix + strings.ToUpper(section+"_"+key),
+				flag:  field.Tag.Get("flag"),
+				live:  field.Tag.Get("reload") == "live",
+				value: fields.Field(j),
+			})
+		}
+	}
+
+	return list
+}
+
+// loadServerConfig reads the settings of the server from the flags, the config file and the environment.
+func loadServerConfig(flags *pflag.FlagSet) (serverConfig, error) {
+	var config serverConfig
+
+	for _, setting := range settings(&config) {
+		if setting.flag == "" {
+			continue
+		}
+		if err := setting.setFlag(flags.Lookup(setting.flag)); err != nil {
+			return config, fmt.Errorf("flag --%s: %w", setting.flag, err)
+		}
+	}
+
+	path, err := flags.GetString("config")
+	if err != nil {
+		return config, err
+	}
+	if path == "" {
+		path = os.Getenv(envConfigFile)
+	}
+
+	if path != "" {
+		if err := decodeConfigFile(path, &config); err != nil {
+			return config, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	for _, setting := range settings(&config) {
+		value, ok := os.LookupEnv(setting.env)
+		if !ok {
+			continue
+		}
+		if err := setting.set(value); err != nil {
+			return config, fmt.Errorf("environment variable %s: %w", setting.env, err)
+		}
+	}
+
+	for _, setting := range settings(&config) {
+		if setting.flag == "" || !flags.Changed(setting.flag) {
+			continue
+		}
+		if err := setting.setFlag(flags.Lookup(setting.flag)); err != nil {
+			return config, fmt.Errorf("flag --%s: %w", setting.flag, err)
+		}
+	}
+
+	return config, config.validate()
+}
+
+// decodeConfigFile reads a YAML or TOML file, depending on its extension, over config.
+// Settings missing from the file keep their value, unknown settings are an error.
+func decodeConfigFile(path string, config *serverConfig) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		return nil
+	case ".toml":
+		metadata, err := toml.DecodeFile(path, config)
+		if err != nil {
+			return err
+		}
+
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown setting %s", undecoded[0])
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, it must be .yaml, .yml or .toml", filepath.Ext(path))
+	}
+}
+
+// setFlag copies the value of flag into the setting. List flags are copied item by item,
+// so patterns with commas given to repeated flags are kept whole.
+func (s setting) setFlag(flag *pflag.Flag) error {
+	if list, ok := flag.Value.(pflag.SliceValue); ok && s.value.Kind() == reflect.Slice {
+		s.value.Set(reflect.ValueOf(list.GetSlice()))
+		return nil
+	}
+
+	return s.set(flag.Value.String())
+}
+
+// set parses value into the setting. Lists are separated by commas.
+func (s setting) set(value string) error {
+	if s.value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(value)
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(number))
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		s.value.SetFloat(number)
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(enabled)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+
+	return nil
+}
+
+// validate reports every invalid setting, so they can all be fixed at once.
+func (config serverConfig) validate() error {
+	var errs []error
+	invalid := func(name string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+	}
+
+	if config.Listeners.Port < 1 || config.Listeners.Port > 65535 {
+		invalid("listeners.port", "%d is not between 1 and 65535", config.Listeners.Port)
+	}
+
+	listeners := map[string]string{
+		"listeners.http":    config.Listeners.HTTP,
+		"listeners.grpc":    config.Listeners.GRPC,
+		"listeners.metrics": config.Listeners.Metrics,
+		"listeners.admin":   config.Listeners.Admin,
+	}
+	for name, address := range listeners {
+		if address == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			invalid(name, "%q is not a host:port address", address)
+		}
+	}
+
+	limits := config.Limits
+	if limits.MaxConnections < 1 {
+		invalid("limits.max_connections", "must be at least 1")
+	}
+	if limits.MaxRequests < 0 {
+		invalid("limits.max_requests", "can't be negative")
+	}
+	if limits.MaxPipelinedRequests < 1 {
+		invalid("limits.max_pipelined_requests", "must be at least 1")
+	}
+	if limits.MaxRequestSize < 1 {
+		invalid("limits.max_request_size", "must be at least 1")
+	}
+	if limits.CompressionThreshold < 0 {
+		invalid("limits.compression_threshold", "can't be negative")
+	}
+
+	// The idle, read and write timeouts are disabled when negative
+	if limits.DrainTimeout < 0 {
+		invalid("limits.drain_timeout", "can't be negative")
+	}
+
+	for name, commands := range map[string][]string{
+		"policies.allowed_commands": config.Policies.AllowedCommands,
+		"policies.denied_commands":  config.Policies.DeniedCommands,
+	} {
+		if slices.Contains(commands, "") {
+			invalid(name, "commands can't be empty")
+		}
+	}
+	if err := config.toServerConfig().Policy.Validate(); err != nil {
+		invalid("policies", "%v", err)
+	}
+
+	if err := config.tasks().Validate(); err != nil {
+		invalid("tasks.registry", "%v", err)
+	}
+	if config.Tasks.Only && len(config.Tasks.Registry) == 0 {
+		invalid("tasks.only", "no task is registered, every request would be rejected")
+	}
+
+	if _, err := zapcore.ParseLevel(config.Logging.Level); err != nil {
+		invalid("logging.level", "%q is not debug, info, warn or error", config.Logging.Level)
+	}
+	if !slices.Contains([]string{logFormatJSON, logFormatConsole}, config.Logging.Format) {
+		invalid("logging.format", "%q is not json or console", config.Logging.Format)
+	}
+	if config.Logging.Output == "" {
+		invalid("logging.output", "must be stderr, stdout or a file")
+	}
+	if output := config.Logging.AuditOutput; output == config.Logging.Output && output != logOutputStderr && output != logOutputStdout {
+		invalid("logging.audit_output", "can't be the log file, both would rotate it")
+	}
+	for name, value := range map[string]int{
+		"logging.max_size_mb":  config.Logging.MaxSizeMB,
+		"logging.max_backups":  config.Logging.MaxBackups,
+		"logging.max_age_days": config.Logging.MaxAgeDays,
+	} {
+		if value < 0 {
+			invalid(name, "can't be negative")
+		}
+	}
+	if err := (server.Redaction{Fields: config.Logging.RedactFields}).Validate(); err != nil {
+		invalid("logging.redact_fields", "%v", err)
+	}
+	if err := (server.Redaction{Patterns: config.Logging.RedactPatterns}).Validate(); err != nil {
+		invalid("logging.redact_patterns", "%v", err)
+	}
+
+	if !slices.Contains([]string{traceExporterNone, traceExporterOTLP, traceExporterStdout}, config.Tracing.Exporter) {
+		invalid("tracing.exporter", "%q is not none, otlp or stdout", config.Tracing.Exporter)
+	}
+	if config.Tracing.SampleRatio < 0 || config.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "%v is not between 0 and 1", config.Tracing.SampleRatio)
+	}
+
+	if config.Metrics.MaxCommands < 1 {
+		invalid("metrics.max_commands", "must be at least 1")
+	}
+
+	// Map iteration order is random, keep the errors stable
+	slices.SortFunc(errs, func(a, b error) int {
+		return strings.Compare(a.Error(), b.Error())
+	})
+
+	return errors.Join(errs...)
+}
+
+// restartRequired lists the settings changed from current to next that are only applied on restart.
+func restartRequired(current serverConfig, next serverConfig) []string {
+	var names []string
+
+	nextSettings := settings(&next)
+	for i, setting := range settings(&current) {
+		if setting.live {
+			continue
+		}
+		if !reflect.DeepEqual(setting.value.Interface(), nextSettings[i].value.Interface()) {
+			names = append(names, setting.name)
+		}
+	}
+
+	return names
+}
+
+// toServerConfig returns the configuration of the server package.
+func (config serverConfig) toServerConfig() server.ServerConfig {
+	return server.ServerConfig{
+		Port:     config.Listeners.Port,
+		Addr:     config.Listeners.Address,
+		Protocol: "tcp",
+		MaxConn:  config.Limits.MaxConnections,
+		ServerID: config.Server.ID,
+
+		MaxRequests:          config.Limits.MaxRequests,
+		MaxRequestSize:       config.Limits.MaxRequestSize,
+		MaxPipelinedRequests: config.Limits.MaxPipelinedRequests,
+		CompressionThreshold: config.Limits.CompressionThreshold,
+
+		IdleTimeout:  config.Limits.IdleTimeout,
+		ReadTimeout:  config.Limits.ReadTimeout,
+		WriteTimeout: config.Limits.WriteTimeout,
+		DrainTimeout: config.Limits.DrainTimeout,
+
+		HTTPAddr: config.Listeners.HTTP,
+		GRPCAddr: config.Listeners.GRPC,
+
+		MetricsAddr:        config.Listeners.Metrics,
+		MetricsMaxCommands: config.Metrics.MaxCommands,
+
+		AdminAddr:   config.Listeners.Admin,
+		AdminToken:  config.Auth.AdminToken,
+		ClientToken: config.Auth.ClientToken,
+
+		Policy: server.Policy{
+			AllowedCommands: config.Policies.AllowedCommands,
+			DeniedCommands:  config.Policies.DeniedCommands,
+
+			ShellClients:     config.Policies.ShellClients,
+			ShellInterpreter: config.Policies.ShellInterpreter,
+
+			ClientEnv: config.Policies.ClientEnv,
+			ClientCwd: config.Policies.ClientCwd,
+		},
+
+		Redaction: server.Redaction{
+			Fields:   config.Logging.RedactFields,
+			Patterns: config.Logging.RedactPatterns,
+		},
+
+		Tasks:     config.tasks(),
+		TasksOnly: config.Tasks.Only,
+	}
+}
+
+// tasks returns the registry of tasks of the server package.
+func (config serverConfig) tasks() server.Tasks {
+	tasks := server.Tasks{}
+	for name, definition := range config.Tasks.Registry {
+		task := server.Task{
+			Description:   definition.Description,
+			Command:       definition.Command,
+			Params:        map[string]server.TaskParam{},
+			Env:           definition.Env,
+			Cwd:           definition.Cwd,
+			Timeout:       definition.Timeout,
+			MaxConcurrent: definition.MaxConcurrent,
+		}
+
+		for paramName, param := range definition.Params {
+			task.Params[paramName] = server.TaskParam{
+				Description: param.Description,
+				Type:        param.Type,
+				Required:    param.Required,
+				Default:     param.Default,
+				Enum:        param.Enum,
+				Pattern:     param.Pattern,
+				MaxLength:   param.MaxLength,
+				Min:         param.Min,
+				Max:         param.Max,
+			}
+		}
+
+		tasks[name] = task
+	}
+
+	return tasks
+}
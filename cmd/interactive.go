@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/shlex"
+	"github.com/hriqueXimenes/sumo_logic_server/client"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/peterh/liner"
+)
+
+const (
+	interactivePrompt = "sumologic> "
+	historyFile       = ".sumologic_server_history"
+)
+
+const interactiveHelp = `Every line is executed on the server as a command, end it with & to run it in the background.
+Ctrl+C cancels the command running in the foreground.
+
+  :timeout [ms]         show or set the timeout of the commands
+  :env [KEY=VALUE]      show the environment or add a variable to it
+  :unset KEY            remove a variable from the environment
+  :cwd [dir]            show or set the working directory of the commands
+  :jobs                 list the commands running in the background
+  :cancel <job>         cancel a command running in the background
+  :history              show the commands executed in the session
+  :help                 show this help
+  :quit                 leave the session`
+
+// interactiveJob is a command running in the background.
+type interactiveJob struct {
+	id        int
+	command   []string
+	startedAt time.Time
+	cancel    context.CancelFunc
+}
+
+// interactiveSession holds the defaults applied to the requests of a REPL session, and its background jobs.
+type interactiveSession struct {
+	client  *client.Client
+	line    *liner.State
+	timeout int
+	env     map[string]string
+	cwd     string
+	history []string
+
+	mu       sync.Mutex
+	nextJob  int
+	jobs     map[int]*interactiveJob
+	finished []string
+}
+
+// runInteractive reads commands from a prompt until the user quits, sending them over the
+// connections of taskClient. It returns the exit code of the client.
+func runInteractive(taskClient *client.Client, timeout int) int {
+	session := &interactiveSession{
+		client:  taskClient,
+		line:    liner.NewLiner(),
+		timeout: timeout,
+		env:     map[string]string{},
+		jobs:    map[int]*interactiveJob{},
+	}
+	defer session.line.Close()
+
+	session.line.SetCtrlCAborts(true)
+	session.loadHistory()
+	defer session.saveHistory()
+
+	fmt.Println("Type :help for help.")
+
+	for {
+		session.printFinished()
+
+		input, err := session.line.Prompt(interactivePrompt)
+		if err == liner.ErrPromptAborted {
+			continue
+		}
+		if err == io.EOF {
+			fmt.Println()
+			break
+		}
+		if err != nil {
+			fmt.Println("Error reading command:", err)
+			return exitCodeClientError
+		}
+
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+
+		session.line.AppendHistory(input)
+		session.history = append(session.history, input)
+
+		if input == ":quit" || input == ":exit" {
+			break
+		}
+
+		if strings.HasPrefix(input, ":") {
+			session.meta(input)
+			continue
+		}
+
+		session.execute(input)
+	}
+
+	session.cancelJobs()
+	return 0
+}
+
+// execute sends a command line to the server, in the foreground or, when it ends with &, in the background.
+func (session *interactiveSession) execute(input string) {
+	background := strings.HasSuffix(input, "&")
+	input = strings.TrimSpace(strings.TrimSuffix(input, "&"))
+
+	command, err := shlex.Split(input)
+	if err != nil {
+		fmt.Println("Invalid command:", err)
+		return
+	}
+
+	if len(command) == 0 {
+		return
+	}
+
+	request := session.request(command)
+
+	if background {
+		session.startJob(request)
+		return
+	}
+
+	// Ctrl+C cancels the command on the server instead of leaving the session
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := session.client.Execute(ctx, request)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Command cancelled.")
+			return
+		}
+		fmt.Println("Error executing request:", err)
+		return
+	}
+
+	printInteractiveResult(result)
+}
+
+// request applies the session defaults to a command.
+func (session *interactiveSession) request(command []string) models.TaskRequest {
+	env := make(map[string]string, len(session.env))
+	for key, value := range session.env {
+		env[key] = value
+	}
+
+	return models.TaskRequest{
+		Command: command,
+		Timeout: session.timeout,
+		Env:     env,
+		Cwd:     session.cwd,
+	}
+}
+
+func (session *interactiveSession) startJob(request models.TaskRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	session.mu.Lock()
+	session.nextJob++
+	job := &interactiveJob{
+		id:        session.nextJob,
+		command:   request.Command,
+		startedAt: time.Now(),
+		cancel:    cancel,
+	}
+	session.jobs[job.id] = job
+	session.mu.Unlock()
+
+	fmt.Printf("[%d] started\n", job.id)
+
+	go func() {
+		defer cancel()
+
+		result, err := session.client.Execute(ctx, request)
+
+		var report strings.Builder
+		switch {
+		case errors.Is(err, context.Canceled):
+			fmt.Fprintf(&report, "[%d] cancelled: %s\n", job.id, strings.Join(job.command, " "))
+		case err != nil:
+			fmt.Fprintf(&report, "[%d] failed: %s: %v\n", job.id, strings.Join(job.command, " "), err)
+		default:
+			fmt.Fprintf(&report, "[%d] done (exit %d): %s\n", job.id, result.ExitCode, strings.Join(job.command, " "))
+			report.WriteString(decodeOutput(result))
+			report.WriteString(decodeError(result))
+		}
+
+		session.mu.Lock()
+		defer session.mu.Unlock()
+
+		delete(session.jobs, job.id)
+		session.finished = append(session.finished, report.String())
+	}()
+}
+
+// printFinished reports the background jobs that finished since the last prompt, like shells do.
+func (session *interactiveSession) printFinished() {
+	session.mu.Lock()
+	finished := session.finished
+	session.finished = nil
+	session.mu.Unlock()
+
+	for _, report := range finished {
+		fmt.Print(report)
+		if !strings.HasSuffix(report, "\n") {
+			fmt.Println()
+		}
+	}
+}
+
+func (session *interactiveSession) cancelJobs() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	for _, job := range session.jobs {
+		job.cancel()
+	}
+}
+
+// meta runs a command of the session itself, like :timeout or :jobs.
+func (session *interactiveSession) meta(input string) {
+	fields := strings.Fields(input)
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case ":help":
+		fmt.Println(interactiveHelp)
+
+	case ":timeout":
+		if len(args) == 0 {
+			fmt.Printf("timeout: %d ms\n", session.timeout)
+			return
+		}
+
+		timeout, err := strconv.Atoi(args[0])
+		if err != nil || timeout < 0 {
+			fmt.Println("The timeout must be a number of milliseconds.")
+			return
+		}
+		session.timeout = timeout
+
+	case ":env":
+		if len(args) == 0 {
+			keys := make([]string, 0, len(session.env))
+			for key := range session.env {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				fmt.Printf("%s=%s\n", key, session.env[key])
+			}
+			return
+		}
+
+		key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(input, ":env")), "=")
+		if !ok || key == "" {
+			fmt.Println("Usage: :env KEY=VALUE")
+			return
+		}
+		session.env[key] = value
+
+	case ":unset":
+		if len(args) != 1 {
+			fmt.Println("Usage: :unset KEY")
+			return
+		}
+		delete(session.env, args[0])
+
+	case ":cwd":
+		if len(args) == 0 {
+			if session.cwd == "" {
+				fmt.Println("cwd: the server's working directory")
+				return
+			}
+			fmt.Println("cwd:", session.cwd)
+			return
+		}
+		session.cwd = strings.TrimSpace(strings.TrimPrefix(input, ":cwd"))
+
+	case ":jobs":
+		session.mu.Lock()
+		jobs := make([]*interactiveJob, 0, len(session.jobs))
+		for _, job := range session.jobs {
+			jobs = append(jobs, job)
+		}
+		session.mu.Unlock()
+
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].id < jobs[j].id })
+		for _, job := range jobs {
+			fmt.Printf("[%d] running for %s: %s\n", job.id, time.Since(job.startedAt).Round(time.Millisecond), strings.Join(job.command, " "))
+		}
+
+	case ":cancel":
+		if len(args) != 1 {
+			fmt.Println("Usage: :cancel <job>")
+			return
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(args[0], "%"))
+		if err != nil {
+			fmt.Println("Usage: :cancel <job>")
+			return
+		}
+
+		session.mu.Lock()
+		job, ok := session.jobs[id]
+		session.mu.Unlock()
+
+		if !ok {
+			fmt.Printf("No job %d is running.\n", id)
+			return
+		}
+		job.cancel()
+
+	case ":history":
+		for i, input := range session.history {
+			fmt.Printf("%5d  %s\n", i+1, input)
+		}
+
+	default:
+		fmt.Printf("Unknown command %s, type :help for help.\n", name)
+	}
+}
+
+func (session *interactiveSession) loadHistory() {
+	path, err := historyPath()
+	if err != nil {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	session.line.ReadHistory(file)
+}
+
+func (session *interactiveSession) saveHistory() {
+	path, err := historyPath()
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	session.line.WriteHistory(file)
+}
+
+func historyPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, historyFile), nil
+}
+
+func printInteractiveResult(result models.TaskResult) {
+	output := decodeOutput(result)
+	fmt.Print(output)
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		fmt.Println()
+	}
+
+	if errorOutput := decodeError(result); errorOutput != "" {
+		fmt.Fprint(os.Stderr, errorOutput)
+		if !strings.HasSuffix(errorOutput, "\n") {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+
+	if result.ExitCode != 0 {
+		fmt.Printf("[exit %d]\n", result.ExitCode)
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+
+	// Logs are written to a rotated file for any other output.
+	logOutputStderr = "stderr"
+	logOutputStdout = "stdout"
+)
+
+// newLogger builds a logger writing to output with the format and rotation of settings, its level can
+// be changed while the server runs. Sampled loggers drop repeated logs like zap.NewProduction. The
+// returned function closes the log file, it must be called on exit.
+func newLogger(settings loggingSettings, output string, level zap.AtomicLevel, sampled bool) (*zap.Logger, func() error) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+
+	var encoder zapcore.Encoder
+	switch settings.Format {
+	case logFormatConsole:
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	var writer zapcore.WriteSyncer
+	closeOutput := func() error { return nil }
+
+	switch output {
+	case logOutputStderr, "":
+		writer = zapcore.Lock(os.Stderr)
+	case logOutputStdout:
+		writer = zapcore.Lock(os.Stdout)
+	default:
+		// lumberjack creates the directory of the file and rotates it once it reaches MaxSizeMB
+		file := &lumberjack.Logger{
+			Filename:   output,
+			MaxSize:    settings.MaxSizeMB,
+			MaxBackups: settings.MaxBackups,
+			MaxAge:     settings.MaxAgeDays,
+			Compress:   settings.Compress,
+		}
+		writer = zapcore.AddSync(file)
+		closeOutput = file.Close
+	}
+
+	core := zapcore.NewCore(encoder, writer, level)
+	if sampled {
+		// Like zap.NewProduction, so a flood of identical logs doesn't slow the server down
+		core = zapcore.NewSamplerWithOptions(core, time.Second, 100, 100)
+	}
+
+	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(zapcore.Lock(os.Stderr))), closeOutput
+}
@@ -3,7 +3,7 @@ package cmd
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -27,41 +27,77 @@ var (
 	}
 )
 
+// outputWaitDelay is how long a finished or killed command can keep its output open
+const outputWaitDelay = 250 * time.Millisecond
+
 func init() {
+	serverCmd.Flags().StringP("config", "c", "", "YAML or TOML config file, reloaded on SIGHUP. Defaults to SUMOLOGIC_CONFIG.")
 	serverCmd.Flags().IntP("port", "p", 3000, "Port on which the server will listen.")
 	serverCmd.Flags().StringP("address", "a", "localhost", "Address on which the server will listen.")
 	serverCmd.Flags().IntP("maxconn", "m", 5, "Maximum number of parallel requests that the server can handle at the same time.")
+	serverCmd.Flags().String("server-id", "", "Identifier of this server returned in every result. Defaults to the hostname.")
+	serverCmd.Flags().Int("max-requests", 0, "Maximum number of requests executed at the same time across all connections. Defaults to maxconn.")
+	serverCmd.Flags().Int("max-pipelined-requests", 16, "Maximum number of requests with an id a single connection can have in flight.")
+	serverCmd.Flags().Int("max-request-size", 1<<20, "Maximum size in bytes of a single request. Bigger requests are rejected and the connection is closed.")
+	serverCmd.Flags().Int("compression-threshold", 1024, "Minimum size in bytes of a response compressed on connections that negotiated compression.")
+	serverCmd.Flags().Duration("idle-timeout", 2*time.Minute, "Close connections that don't send a new request within this time. Disabled when negative.")
+	serverCmd.Flags().Duration("read-timeout", 30*time.Second, "Maximum time a client can take to send a request once it started. Disabled when negative.")
+	serverCmd.Flags().Duration("write-timeout", 30*time.Second, "Maximum time a client can take to receive a response. Disabled when negative.")
+	serverCmd.Flags().Duration("drain-timeout", 30*time.Second, "Maximum time to wait for open connections to finish when the server stops.")
+	serverCmd.Flags().String("http-address", "", "Address (host:port) of the HTTP gateway. The gateway is disabled when empty.")
+	serverCmd.Flags().String("grpc-address", "", "Address (host:port) of the gRPC TaskService. The gRPC server is disabled when empty.")
+	serverCmd.Flags().String("metrics-address", "", "Address (host:port) serving Prometheus metrics on /metrics. Metrics are disabled when empty.")
+	serverCmd.Flags().String("admin-address", "", "Address (host:port) of the admin API listing connections, processes and the queue. The admin API is disabled when empty.")
+	serverCmd.Flags().String("admin-token", "", "Bearer token required by the admin API. The admin API is open to anyone reaching its address when empty.")
+	serverCmd.Flags().String("client-token", "", "Token required from clients, in the hello of TCP connections or as a bearer token on HTTP and gRPC. Clients aren't authenticated when empty.")
+	serverCmd.Flags().StringSlice("shell-clients", nil, "IP addresses or CIDR ranges of the clients allowed to send shell scripts. Shell mode is disabled when empty.")
+	serverCmd.Flags().StringSlice("shell-interpreter", nil, "Interpreter running the shell scripts, given as its last argument. Defaults to /bin/sh,-c.")
+	serverCmd.Flags().StringSlice("client-env", nil, "Names of the environment variables clients can set on their commands. Clients can't set any when empty.")
+	serverCmd.Flags().StringSlice("client-cwd", nil, "Absolute directories, and their subdirectories, clients can run their commands in. Clients can't choose the working directory when empty.")
+	serverCmd.Flags().Bool("tasks-only", false, "Only run the tasks registered in the config file, requests sending a command are rejected.")
+	serverCmd.Flags().String("log-level", "info", "Minimum level of the logs: debug, info, warn or error.")
+	serverCmd.Flags().String("log-format", "json", "Format of the logs: json or console.")
+	serverCmd.Flags().String("log-output", "stderr", "Destination of the logs: stderr, stdout or the path of a file rotated by size.")
+	serverCmd.Flags().Int("log-max-size", 100, "Size in megabytes of the log file before it is rotated.")
+	serverCmd.Flags().Int("log-max-backups", 5, "Number of rotated log files kept, 0 keeps them all.")
+	serverCmd.Flags().Int("log-max-age", 30, "Days rotated log files are kept, 0 keeps them forever.")
+	serverCmd.Flags().Bool("log-compress", false, "Compress the rotated log files with gzip.")
+	serverCmd.Flags().String("audit-output", "", "Destination of the audit records of every request, with its command or shell script: stderr, stdout or a file. No audit when empty.")
+	serverCmd.Flags().StringSlice("log-redact-fields", []string{"env", "output"}, "Fields replaced by [REDACTED] in the logs: command, env, env.<NAME>, cwd, output or error.")
+	serverCmd.Flags().StringArray("log-redact-pattern", nil, "Regular expression replaced by [REDACTED] in the commands, env values, cwd, output and errors logged. Can be repeated.")
+	serverCmd.Flags().String("trace-exporter", "none", "Exporter of the OpenTelemetry traces: none, otlp or stdout.")
+	serverCmd.Flags().String("otlp-endpoint", "", "URL of the OTLP/HTTP collector receiving the traces (e.g. http://localhost:4318). Defaults to OTEL_EXPORTER_OTLP_ENDPOINT.")
+	serverCmd.Flags().Float64("trace-sample-ratio", 1, "Ratio of the requests traced when the client didn't send a sampled trace context.")
+	serverCmd.Flags().Int("metrics-max-commands", 100, "Maximum number of distinct command names used as metric labels, other commands are labelled as \"other\".")
 	rootCmd.AddCommand(serverCmd)
 }
 
 func serverCommandExecute(cmd *cobra.Command, args []string) {
-	// Get Flags
-	port, err := cmd.Flags().GetInt("port")
+	// Load the configuration from the flags, the config file and the environment
+	config, err := loadServerConfig(cmd.Flags())
 	if err != nil {
-		fmt.Println("Error getting port:", err)
+		fmt.Println("Invalid configuration:", err)
 		return
 	}
 
-	maxConn, err := cmd.Flags().GetInt("maxconn")
-	if err != nil {
-		fmt.Println("Error getting max connection count:", err)
-		return
-	}
-
-	address, err := cmd.Flags().GetString("address")
-	if err != nil {
-		fmt.Println("Error getting address:", err)
-		return
-	}
+	// Initialize Logger, its level can be changed on reload
+	logLevel := zap.NewAtomicLevel()
+	logLevel.UnmarshalText([]byte(config.Logging.Level))
 
-	// Initialize Logger
-	logger, err := zap.NewProduction()
-	if err != nil {
-		panic(err)
-	}
+	logger, closeLogs := newLogger(config.Logging, config.Logging.Output, logLevel, true)
+	defer closeLogs()
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
+	// Initialize the audit trail, apart from the logs so it is kept whatever the log level, and never sampled
+	var auditLogger *zap.SugaredLogger
+	if config.Logging.AuditOutput != "" {
+		audit, closeAudit := newLogger(config.Logging, config.Logging.AuditOutput, zap.NewAtomicLevelAt(zap.InfoLevel), false)
+		defer closeAudit()
+		defer audit.Sync()
+		auditLogger = audit.Sugar()
+	}
+
 	// Initialize Context
 	const loggerCtxKey = "logger"
 	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), loggerCtxKey, sugar))
@@ -74,23 +110,75 @@ func serverCommandExecute(cmd *cobra.Command, args []string) {
 		cancel()
 	}()
 
+	// Initialize Tracing, flushing the spans left when the server stops
+	shutdownTracing, err := setupTracing(context.Background(), config.Tracing.Exporter, config.Tracing.OTLPEndpoint, config.Tracing.SampleRatio)
+	if err != nil {
+		sugar.Errorw("Error initializing tracing", "Error", err)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			sugar.Warnw("Error flushing traces", "Error", err)
+		}
+	}()
+
 	// Create a new server instance
-	newServer, err := server.NewServer(server.ServerConfig{
-		Port:     port,
-		Addr:     address,
-		Protocol: "tcp",
-		MaxConn:  maxConn,
-	})
+	serverConfig := config.toServerConfig()
+	serverConfig.AuditLogger = auditLogger
+	newServer, err := server.NewServer(serverConfig)
 
 	if err != nil {
 		sugar.Errorw("Error initializing server", "Error", err)
 		return
 	}
 
+	// Reload the configuration on SIGHUP, keeping the connections open
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	defer signal.Stop(reloadChan)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reloadChan:
+				config = reloadServerConfig(cmd, config, newServer, logLevel, sugar)
+			}
+		}
+	}()
+
 	// Start the TCP server
 	newServer.Start(ctx, OnReceiveSignal)
 }
 
+// reloadServerConfig applies the settings that can change live and returns the configuration
+// in use. An invalid configuration is logged and the current one is kept.
+func reloadServerConfig(cmd *cobra.Command, current serverConfig, newServer *server.Server, logLevel zap.AtomicLevel, logger *zap.SugaredLogger) serverConfig {
+	next, err := loadServerConfig(cmd.Flags())
+	if err != nil {
+		logger.Errorw("Invalid configuration, keeping the current one", "Error", err)
+		return current
+	}
+
+	if changed := restartRequired(current, next); len(changed) > 0 {
+		logger.Warnw("Settings changed that are only applied on restart", "Settings", changed)
+	}
+
+	if err := newServer.Reload(next.toServerConfig()); err != nil {
+		logger.Errorw("Invalid configuration, keeping the current one", "Error", err)
+		return current
+	}
+	logLevel.UnmarshalText([]byte(next.Logging.Level))
+
+	logger.Infow("Configuration reloaded")
+
+	return next
+}
+
 func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 	// Start logger instance
 	logger, ok := ctx.Value("logger").(*zap.SugaredLogger)
@@ -103,15 +191,18 @@ func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 	result := models.TaskResult{}
 	const exitCodeErrorGeneral = -1
 
-	// Unmarshal the incoming request into a TaskRequest struct
+	// Unmarshal the incoming request into a TaskRequest struct, using the codec negotiated by the client
 	var request models.TaskRequest
-	err := json.Unmarshal(req, &request)
+	err := server.CodecFromContext(ctx).Unmarshal(req, &request)
 	if err != nil {
 		result.ExitCode = exitCodeErrorGeneral
 		result.Error = fmt.Sprintf("Invalid request body: %v", err)
 		return result
 	}
 
+	// Tag the result so pipelined requests can be matched by the client
+	result.ID = request.ID
+
 	// Validate that a command is provided in the request
 	if request.Command == nil || len(request.Command) == 0 {
 		result.ExitCode = exitCodeErrorGeneral
@@ -119,6 +210,13 @@ func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 		return result
 	}
 
+	// Validate the requested output encoding
+	if !models.ValidOutputEncoding(request.OutputEncoding) {
+		result.ExitCode = exitCodeErrorGeneral
+		result.Error = fmt.Sprintf("Invalid output_encoding %q, it must be utf8 or base64.", request.OutputEncoding)
+		return result
+	}
+
 	// Set the command to be executed
 	result.Command = request.Command
 
@@ -131,22 +229,26 @@ func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 
 	// Execute the command with the given arguments and capture the output
 	cmd := exec.CommandContext(subProcessCtx, request.Command[0], request.Command[1:]...)
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		logger.Errorw("Error to create stdoutPipe", "Request", request)
-		result.ExitCode = exitCodeErrorGeneral
-		result.Error = "Unexpected Error"
-		return result
+	cmd.Dir = request.Cwd
+	if len(request.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range request.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		logger.Errorw("Error to create stderrPipe", "Request", request)
-		result.ExitCode = exitCodeErrorGeneral
-		result.Error = "Unexpected Error"
-		return result
+	// Save stdout and stderr in a buffer, and stream them to the client when it asked for it
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdoutBuf, &stderrBuf
+	if writeOutput, ok := server.OutputWriterFromContext(ctx); ok {
+		cmd.Stdout = io.MultiWriter(&stdoutBuf, server.NewOutputStream(writeOutput, request.ID, models.StreamStdout, request.OutputEncoding))
+		cmd.Stderr = io.MultiWriter(&stderrBuf, server.NewOutputStream(writeOutput, request.ID, models.StreamStderr, request.OutputEncoding))
 	}
 
+	// Processes started by the command can keep its output open after it exits or is killed,
+	// Wait stops copying their output after this delay so the timeout is still enforced
+	cmd.WaitDelay = outputWaitDelay
+
 	if err := cmd.Start(); err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitError.ExitCode()
@@ -159,29 +261,30 @@ func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 		return result
 	}
 
-	// Save stdout and stderr in a buffer
-	var stdoutBuf, stderrBuf bytes.Buffer
-	go io.Copy(&stdoutBuf, stdoutPipe)
-	go io.Copy(&stderrBuf, stderrPipe)
+	// List the process on the admin API while it runs
+	server.ProcessStarted(ctx, cmd.Process.Pid)
 
 	//TODO: Check buffer limit
 
-	// Wait for the command to finish
+	// Wait copies the output of the command until it finishes
 	result.ExitCode = 0
-	if err := cmd.Wait(); err != nil {
+	if err := cmd.Wait(); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
 				if status.Signaled() {
-					result.Error = "timeout exceeded"
+					result.Error = models.ErrorTimeoutExceeded
+					if errors.Is(subProcessCtx.Err(), context.Canceled) {
+						result.Error = models.ErrorCommandCancelled
+					}
 					result.ExitCode = exitCodeErrorGeneral
 				} else {
-					result.Error = stderrBuf.String()
+					result.Error, result.ErrorEncoding = encodeStderr(stderrBuf.Bytes(), request.OutputEncoding)
 					result.ExitCode = exitError.ExitCode()
 				}
 			}
 		} else {
 			result.ExitCode = exitCodeErrorGeneral
-			result.Error = stderrBuf.String()
+			result.Error, result.ErrorEncoding = encodeStderr(stderrBuf.Bytes(), request.OutputEncoding)
 		}
 
 		logger.Errorw("Command finished with an error", "Error", err)
@@ -196,9 +299,26 @@ func OnReceiveSignal(ctx context.Context, req []byte) interface{} {
 
 	// Capture the output of the command execution
 	if result.ExitCode == 0 {
-		output := stdoutBuf.String() + stderrBuf.String()
-		result.Output = string(output)
+		output := append(stdoutBuf.Bytes(), stderrBuf.Bytes()...)
+
+		// Binary output is sent as base64, flagged in the result
+		var encoding string
+		result.Output, encoding = models.EncodeOutput(output, request.OutputEncoding)
+		if encoding == models.OutputEncodingBase64 {
+			result.OutputEncoding = encoding
+		}
 	}
 
 	return result
 }
+
+// encodeStderr renders the stderr of a failed command like its output, binary stderr is sent as base64.
+// The encoding is only returned when it is base64.
+func encodeStderr(stderr []byte, requestedEncoding string) (string, string) {
+	data, encoding := models.EncodeOutput(stderr, requestedEncoding)
+	if encoding != models.OutputEncodingBase64 {
+		return data, ""
+	}
+
+	return data, encoding
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnReceiveSignal_SUCCESS_Command(t *testing.T) {
+	result := OnReceiveSignal(context.Background(), []byte(`{"id":"1","command":["sh","-c","echo hello"]}`)).(models.TaskResult)
+
+	assert.Equal(t, 0, result.ExitCode, "the command should succeed")
+	assert.Equal(t, "hello\n", result.Output, "the output of the command should be returned")
+}
+
+func TestOnReceiveSignal_ERROR_Timeout_With_Grandchild_Holding_Output(t *testing.T) {
+	startTime := time.Now()
+	result := OnReceiveSignal(context.Background(), []byte(`{"command":["sh","-c","sleep 4 | cat"],"timeout":500}`)).(models.TaskResult)
+
+	assert.Less(t, time.Since(startTime), 2*time.Second, "the timeout should be enforced while a grandchild keeps the output open")
+	assert.Equal(t, models.ErrorTimeoutExceeded, result.Error, "the command should time out")
+	assert.Equal(t, -1, result.ExitCode, "a timed out command should return the general error exit code")
+}
+
+func TestOnReceiveSignal_SUCCESS_Grandchild_Outlives_Command(t *testing.T) {
+	startTime := time.Now()
+	result := OnReceiveSignal(context.Background(), []byte(`{"command":["sh","-c","echo done; sleep 4 &"]}`)).(models.TaskResult)
+
+	assert.Less(t, time.Since(startTime), 2*time.Second, "a background grandchild shouldn't hold the result")
+	assert.Equal(t, 0, result.ExitCode, "the command should succeed")
+	assert.Equal(t, "done\n", result.Output, "the output written before the command exited should be returned")
+}
+
+func TestOnReceiveSignal_ERROR_Stderr_Encoding(t *testing.T) {
+	tests := map[string]struct {
+		request  string
+		error    string
+		encoding string
+	}{
+		"utf8":            {request: `{"command":["sh","-c","printf 'failed' >&2; exit 3"]}`, error: "failed"},
+		"invalid utf8":    {request: `{"command":["sh","-c","printf '\\377\\000' >&2; exit 3"]}`, error: "/wA=", encoding: models.OutputEncodingBase64},
+		"base64 required": {request: `{"command":["sh","-c","printf 'failed' >&2; exit 3"],"output_encoding":"base64"}`, error: "ZmFpbGVk", encoding: models.OutputEncodingBase64},
+	}
+
+	for name, test := range tests {
+		result := OnReceiveSignal(context.Background(), []byte(test.request)).(models.TaskResult)
+
+		assert.Equal(t, 3, result.ExitCode, "the exit code of the command should be returned: "+name)
+		assert.Equal(t, test.error, result.Error, "stderr should be encoded like the output: "+name)
+		assert.Equal(t, test.encoding, result.ErrorEncoding, "base64 stderr should be flagged: "+name)
+	}
+}
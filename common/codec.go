@@ -0,0 +1,123 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	CodecJSON    = "json"
+	CodecMsgPack = "msgpack"
+	CodecCBOR    = "cbor"
+)
+
+// Codec encodes the messages exchanged on a connection. Every codec uses the json
+// struct tags, so TaskRequest and TaskResult have the same schema in all of them.
+type Codec interface {
+	Name() string
+	// Binary reports whether encoded messages may contain any byte, which requires length-prefixed framing.
+	Binary() bool
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+func NewJSONCodec() Codec {
+	return &jsonCodec{}
+}
+
+func NewMsgPackCodec() Codec {
+	return &msgpackCodec{}
+}
+
+func NewCBORCodec() Codec {
+	return &cborCodec{}
+}
+
+func newCodec(name string) (Codec, error) {
+	switch name {
+	case CodecJSON:
+		return NewJSONCodec(), nil
+	case CodecMsgPack:
+		return NewMsgPackCodec(), nil
+	case CodecCBOR:
+		return NewCBORCodec(), nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+}
+
+type jsonCodec struct{}
+
+func (codec *jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (codec *jsonCodec) Binary() bool {
+	return false
+}
+
+func (codec *jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec *jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (codec *msgpackCodec) Name() string {
+	return CodecMsgPack
+}
+
+func (codec *msgpackCodec) Binary() bool {
+	return true
+}
+
+func (codec *msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (codec *msgpackCodec) Unmarshal(data []byte, v any) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+
+	return decoder.Decode(v)
+}
+
+// cborDecMode decodes maps into map[string]interface{}, like the other codecs, instead of map[interface{}]interface{}.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+type cborCodec struct{}
+
+func (codec *cborCodec) Name() string {
+	return CodecCBOR
+}
+
+func (codec *cborCodec) Binary() bool {
+	return true
+}
+
+// Marshal relies on cbor falling back to the json struct tags when there are no cbor tags.
+func (codec *cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (codec *cborCodec) Unmarshal(data []byte, v any) error {
+	return cborDecMode.Unmarshal(data, v)
+}
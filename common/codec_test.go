@@ -0,0 +1,79 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec_SUCCESS_Round_Trip(t *testing.T) {
+	request := models.TaskRequest{
+		ID:             "a",
+		Command:        []string{"journalctl", "-n", "10"},
+		Timeout:        2000,
+		OutputEncoding: models.OutputEncodingBase64,
+		Env:            map[string]string{"LANG": "C"},
+		Cwd:            "/var/log",
+		CorrelationID:  "cid",
+		Task:           "logs",
+		Params:         map[string]interface{}{"unit": "nginx"},
+		Shell:          "ls | wc -l",
+	}
+	result := models.TaskResult{
+		ID:             "a",
+		Command:        []string{"journalctl", "-n", "10"},
+		ExecutedAt:     1700000000000,
+		DurationMs:     12.5,
+		ExitCode:       1,
+		Output:         "binary\x00output\n",
+		Error:          "failed",
+		OutputEncoding: models.OutputEncodingBase64,
+		ServerID:       "server-1",
+		StartedAtNs:    1700000000000000000,
+	}
+
+	for _, name := range []string{CodecJSON, CodecMsgPack, CodecCBOR} {
+		codec, err := newCodec(name)
+		assert.Nil(t, err, "Creating the codec should not return error: "+name)
+		assert.Equal(t, name, codec.Name(), "The codec should report its name: "+name)
+		assert.Equal(t, name != CodecJSON, codec.Binary(), "Only the JSON codec should be text: "+name)
+
+		data, err := codec.Marshal(request)
+		assert.Nil(t, err, "Marshalling a TaskRequest should not return error: "+name)
+
+		var decodedRequest models.TaskRequest
+		err = codec.Unmarshal(data, &decodedRequest)
+		assert.Nil(t, err, "Unmarshalling a TaskRequest should not return error: "+name)
+		assert.Equal(t, request, decodedRequest, "The TaskRequest should survive a round-trip: "+name)
+
+		data, err = codec.Marshal(result)
+		assert.Nil(t, err, "Marshalling a TaskResult should not return error: "+name)
+
+		var decodedResult models.TaskResult
+		err = codec.Unmarshal(data, &decodedResult)
+		assert.Nil(t, err, "Unmarshalling a TaskResult should not return error: "+name)
+		assert.Equal(t, result, decodedResult, "The TaskResult should survive a round-trip: "+name)
+	}
+}
+
+func TestCodec_SUCCESS_JSON_Field_Names(t *testing.T) {
+	for _, name := range []string{CodecMsgPack, CodecCBOR} {
+		codec, _ := newCodec(name)
+
+		data, err := codec.Marshal(models.TaskRequest{ID: "a", Command: []string{"echo"}, OutputEncoding: models.OutputEncodingBase64})
+		assert.Nil(t, err, "Marshalling a TaskRequest should not return error: "+name)
+
+		var message map[string]interface{}
+		err = codec.Unmarshal(data, &message)
+		assert.Nil(t, err, "Messages should decode into string keyed maps: "+name)
+		assert.Equal(t, "a", message["id"], "The binary codecs should use the json field names: "+name)
+		assert.Equal(t, models.OutputEncodingBase64, message["output_encoding"], "The binary codecs should use the json field names: "+name)
+		assert.NotContains(t, message, "cwd", "The binary codecs should omit the empty fields like json: "+name)
+	}
+}
+
+func TestCodec_ERROR_Unknown(t *testing.T) {
+	_, err := newCodec("xml")
+	assert.NotNil(t, err, "Unknown codecs should be rejected")
+}
@@ -1,7 +1,6 @@
 package common
 
 import (
-	"bytes"
 	"encoding/json"
 	"net"
 )
@@ -9,11 +8,13 @@ import (
 type Common interface {
 	NewDecoder(conn net.Conn) *json.Decoder
 	Decode(decoder *json.Decoder) (interface{}, error)
-	ReadUntilNewline(conn net.Conn) ([]byte, error)
-
-	Write(conn net.Conn, req []byte) error
+	NewFramer(conn net.Conn, maxFrameSize int) (Framer, error)
+	SwitchFramer(framer Framer, mode string) (Framer, error)
+	NewCodec(name string) (Codec, error)
+	NewCompressor(name string) (Compressor, error)
 
 	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
 }
 
 type commonImpl struct{}
@@ -37,31 +38,27 @@ func (common *commonImpl) Marshal(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
 
-func (common *commonImpl) Write(conn net.Conn, req []byte) error {
-	if _, err := conn.Write(append(req, '\n')); err != nil {
-		return err
-	}
+func (common *commonImpl) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
 
-	return nil
+// NewFramer selects the framer for a new connection. Clients opt into length-prefixed
+// framing by sending LengthPrefixedPreamble; everything else is newline-delimited JSON.
+func (common *commonImpl) NewFramer(conn net.Conn, maxFrameSize int) (Framer, error) {
+	return negotiateFramer(conn, maxFrameSize)
 }
 
-func (common *commonImpl) ReadUntilNewline(conn net.Conn) ([]byte, error) {
-	var buf bytes.Buffer
-	for {
-		b := make([]byte, 1)
-		n, err := conn.Read(b)
-		if err != nil {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
+// SwitchFramer changes the framing of a connection after it was negotiated in a hello exchange.
+func (common *commonImpl) SwitchFramer(framer Framer, mode string) (Framer, error) {
+	return switchFramer(framer, mode)
+}
 
-		buf.Write(b)
-		if b[0] == '\n' {
-			break
-		}
-	}
+// NewCodec returns the codec negotiated for a connection.
+func (common *commonImpl) NewCodec(name string) (Codec, error) {
+	return newCodec(name)
+}
 
-	return buf.Bytes(), nil
+// NewCompressor returns the compressor negotiated for a connection.
+func (common *commonImpl) NewCompressor(name string) (Compressor, error) {
+	return newCompressor(name)
 }
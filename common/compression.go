@@ -0,0 +1,182 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// DefaultCompressionThreshold is the smallest frame worth compressing, smaller frames are sent as is.
+	DefaultCompressionThreshold = 1024
+
+	frameUncompressed byte = 0x00
+	frameCompressed   byte = 0x01
+)
+
+// Compressor compresses the frames exchanged on a connection.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails when the decompressed data is bigger than maxSize, so small frames can't expand without bounds.
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+func NewGzipCompressor() Compressor {
+	return &gzipCompressor{}
+}
+
+func NewZstdCompressor() Compressor {
+	return &zstdCompressor{}
+}
+
+func newCompressor(name string) (Compressor, error) {
+	switch name {
+	case CompressionGzip:
+		return NewGzipCompressor(), nil
+	case CompressionZstd:
+		return NewZstdCompressor(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+}
+
+// NewCompressedFramer wraps a framer so every frame starts with a flag byte telling whether the
+// rest of the frame is compressed. Frames smaller than threshold are sent uncompressed, and
+// compressed frames received are rejected when they decompress to more than maxFrameSize.
+func NewCompressedFramer(framer Framer, compressor Compressor, threshold int, maxFrameSize int) Framer {
+	return &compressedFramer{
+		framer:       framer,
+		compressor:   compressor,
+		threshold:    threshold,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+type compressedFramer struct {
+	framer       Framer
+	compressor   Compressor
+	threshold    int
+	maxFrameSize int
+}
+
+func (framer *compressedFramer) Mode() string {
+	return framer.framer.Mode()
+}
+
+func (framer *compressedFramer) WaitFrame() error {
+	return framer.framer.WaitFrame()
+}
+
+func (framer *compressedFramer) ReadFrame() ([]byte, error) {
+	frame, err := framer.framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frame) == 0 {
+		return nil, errors.New("missing compression flag")
+	}
+
+	switch frame[0] {
+	case frameUncompressed:
+		return frame[1:], nil
+	case frameCompressed:
+		return framer.compressor.Decompress(frame[1:], framer.maxFrameSize)
+	default:
+		return nil, fmt.Errorf("invalid compression flag 0x%02x", frame[0])
+	}
+}
+
+func (framer *compressedFramer) WriteFrame(data []byte) error {
+	if len(data) < framer.threshold {
+		return framer.framer.WriteFrame(append([]byte{frameUncompressed}, data...))
+	}
+
+	compressed, err := framer.compressor.Compress(data)
+	if err != nil {
+		return err
+	}
+
+	// Data that doesn't compress, like base64 of random bytes, is cheaper to send as is
+	if len(compressed) >= len(data) {
+		return framer.framer.WriteFrame(append([]byte{frameUncompressed}, data...))
+	}
+
+	return framer.framer.WriteFrame(append([]byte{frameCompressed}, compressed...))
+}
+
+type gzipCompressor struct{}
+
+func (compressor *gzipCompressor) Name() string {
+	return CompressionGzip
+}
+
+func (compressor *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (compressor *gzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return readLimited(reader, maxSize)
+}
+
+// zstdEncoder is shared by all connections, EncodeAll can be called concurrently.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+type zstdCompressor struct{}
+
+func (compressor *zstdCompressor) Name() string {
+	return CompressionZstd
+}
+
+func (compressor *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (compressor *zstdCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	reader, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return readLimited(reader, maxSize)
+}
+
+// readLimited reads a decompressed stream, failing with ErrFrameTooLarge when it exceeds maxSize.
+func readLimited(reader io.Reader, maxSize int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%w: decompressed frame exceeds the limit of %d bytes", ErrFrameTooLarge, maxSize)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedFramer_SUCCESS_Threshold(t *testing.T) {
+	compressible := []byte(strings.Repeat("journalctl output line\n", 100))
+
+	tests := map[string]struct {
+		data []byte
+		flag byte
+	}{
+		"empty":               {data: []byte{}, flag: frameUncompressed},
+		"below the threshold": {data: compressible[:1023], flag: frameUncompressed},
+		"at the threshold":    {data: compressible[:1024], flag: frameCompressed},
+		"above the threshold": {data: compressible, flag: frameCompressed},
+		"incompressible":      {data: incompressible(4096), flag: frameUncompressed},
+	}
+
+	for _, compressor := range []Compressor{NewGzipCompressor(), NewZstdCompressor()} {
+		for name, test := range tests {
+			name := compressor.Name() + " " + name
+
+			var stream bytes.Buffer
+			framer := NewCompressedFramer(NewLengthPrefixedFramer(&stream, 1<<20), compressor, 1024, 1<<20)
+
+			err := framer.WriteFrame(test.data)
+			assert.Nil(t, err, "Writing a frame should not return error: "+name)
+			assert.Equal(t, test.flag, stream.Bytes()[lengthPrefixSize], "The frame should be flagged as compressed or not: "+name)
+
+			frame, err := framer.ReadFrame()
+			assert.Nil(t, err, "Reading a frame should not return error: "+name)
+			assert.Equal(t, test.data, frame, "The frame read should be the frame written: "+name)
+		}
+	}
+}
+
+func TestCompressedFramer_ERROR_Decompression_Bomb(t *testing.T) {
+	for _, compressor := range []Compressor{NewGzipCompressor(), NewZstdCompressor()} {
+		// A frame far below the frame limit expanding to a single byte over it
+		bomb, err := compressor.Compress(make([]byte, 1<<16+1))
+		assert.Nil(t, err, "Compressing should not return error: "+compressor.Name())
+		assert.Less(t, len(bomb), 1<<16, "The compressed frame should be under the limit: "+compressor.Name())
+
+		var stream bytes.Buffer
+		NewLengthPrefixedFramer(&stream, 1<<16).WriteFrame(append([]byte{frameCompressed}, bomb...))
+		framer := NewCompressedFramer(NewLengthPrefixedFramer(&stream, 1<<16), compressor, 1024, 1<<16)
+
+		_, err = framer.ReadFrame()
+		assert.ErrorIs(t, err, ErrFrameTooLarge, "Frames decompressing over the limit should be rejected: "+compressor.Name())
+	}
+}
+
+func TestReadLimited_ERROR_Limit(t *testing.T) {
+	tests := map[string]struct {
+		size int
+		err  error
+	}{
+		"under the limit":         {size: 99},
+		"at the limit":            {size: 100},
+		"one byte over the limit": {size: 101, err: ErrFrameTooLarge},
+		"far over the limit":      {size: 1 << 20, err: ErrFrameTooLarge},
+	}
+
+	for name, test := range tests {
+		data, err := readLimited(bytes.NewReader(make([]byte, test.size)), 100)
+		if test.err != nil {
+			assert.ErrorIs(t, err, test.err, "Streams over the limit should be rejected: "+name)
+			continue
+		}
+
+		assert.Nil(t, err, "Streams within the limit should be read: "+name)
+		assert.Len(t, data, test.size, "Streams within the limit should be read whole: "+name)
+	}
+}
+
+func TestCompressedFramer_ERROR_Invalid_Flags(t *testing.T) {
+	tests := map[string][]byte{
+		"missing flag":    {},
+		"unknown flag":    {0x02, '{', '}'},
+		"corrupted frame": {frameCompressed, 'n', 'o', 't'},
+	}
+
+	for name, frame := range tests {
+		var stream bytes.Buffer
+		NewLengthPrefixedFramer(&stream, 1024).WriteFrame(frame)
+		framer := NewCompressedFramer(NewLengthPrefixedFramer(&stream, 1024), NewGzipCompressor(), 1024, 1024)
+
+		_, err := framer.ReadFrame()
+		assert.NotNil(t, err, "Invalid frames should be rejected: "+name)
+	}
+}
+
+// incompressible returns size bytes that don't compress, like base64 of random bytes does.
+func incompressible(size int) []byte {
+	data := make([]byte, size)
+	state := uint32(1)
+	for i := range data {
+		state = state*1664525 + 1013904223
+		data[i] = byte(state >> 24)
+	}
+
+	return data
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	FramingNewline        = "newline"
+	FramingLengthPrefixed = "length-prefixed"
+
+	// DefaultMaxFrameSize is the largest frame accepted when no other limit is configured.
+	DefaultMaxFrameSize = 16 << 20
+
+	lengthPrefixSize = 4
+)
+
+// ErrFrameTooLarge is returned by ReadFrame when the client sends a frame bigger than the
+// configured limit. The stream can't be resynchronized afterwards, so the connection must be closed.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// LengthPrefixedPreamble must be sent by the client before its first frame to select
+// length-prefixed framing. Connections that don't start with it use newline-delimited JSON.
+var LengthPrefixedPreamble = []byte{0x00, 'L', 'P', 'F'}
+
+// Framer splits a connection stream into request frames and writes response frames back.
+type Framer interface {
+	Mode() string
+	// WaitFrame blocks until the first byte of the next frame is available, without consuming it.
+	WaitFrame() error
+	ReadFrame() ([]byte, error)
+	WriteFrame(data []byte) error
+}
+
+// NewNewlineFramer creates a framer where every frame is terminated by '\n'.
+// Frames bigger than maxFrameSize are rejected.
+func NewNewlineFramer(rw io.ReadWriter, maxFrameSize int) Framer {
+	return &newlineFramer{
+		reader:       bufio.NewReader(rw),
+		writer:       rw,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// NewLengthPrefixedFramer creates a framer where every frame is preceded by its size
+// as a 4-byte big-endian unsigned integer. Frames bigger than maxFrameSize are rejected.
+func NewLengthPrefixedFramer(rw io.ReadWriter, maxFrameSize int) Framer {
+	return &lengthPrefixedFramer{
+		reader:       bufio.NewReader(rw),
+		writer:       rw,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+type newlineFramer struct {
+	reader       *bufio.Reader
+	writer       io.Writer
+	maxFrameSize int
+}
+
+func (framer *newlineFramer) Mode() string {
+	return FramingNewline
+}
+
+func (framer *newlineFramer) WaitFrame() error {
+	_, err := framer.reader.Peek(1)
+	return err
+}
+
+func (framer *newlineFramer) ReadFrame() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := framer.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > framer.maxFrameSize+len("\r\n") {
+			return nil, fmt.Errorf("%w: exceeds the limit of %d bytes", ErrFrameTooLarge, framer.maxFrameSize)
+		}
+
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		break
+	}
+
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) > framer.maxFrameSize {
+		return nil, fmt.Errorf("%w: exceeds the limit of %d bytes", ErrFrameTooLarge, framer.maxFrameSize)
+	}
+
+	return line, nil
+}
+
+func (framer *newlineFramer) WriteFrame(data []byte) error {
+	frame := make([]byte, 0, len(data)+1)
+	frame = append(frame, data...)
+	frame = append(frame, '\n')
+
+	_, err := framer.writer.Write(frame)
+	return err
+}
+
+type lengthPrefixedFramer struct {
+	reader       *bufio.Reader
+	writer       io.Writer
+	maxFrameSize int
+}
+
+func (framer *lengthPrefixedFramer) Mode() string {
+	return FramingLengthPrefixed
+}
+
+func (framer *lengthPrefixedFramer) WaitFrame() error {
+	_, err := framer.reader.Peek(1)
+	return err
+}
+
+func (framer *lengthPrefixedFramer) ReadFrame() ([]byte, error) {
+	var prefix [lengthPrefixSize]byte
+	if _, err := io.ReadFull(framer.reader, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix[:])
+	if uint64(size) > uint64(framer.maxFrameSize) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrFrameTooLarge, size, framer.maxFrameSize)
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(framer.reader, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func (framer *lengthPrefixedFramer) WriteFrame(data []byte) error {
+	frame := make([]byte, lengthPrefixSize, lengthPrefixSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+
+	_, err := framer.writer.Write(frame)
+	return err
+}
+
+// switchFramer returns a framer in the requested mode reading from the same buffered stream,
+// so bytes the client already sent after the switch are not lost.
+func switchFramer(framer Framer, mode string) (Framer, error) {
+	var reader *bufio.Reader
+	var writer io.Writer
+	var maxFrameSize int
+
+	switch current := framer.(type) {
+	case *newlineFramer:
+		reader, writer, maxFrameSize = current.reader, current.writer, current.maxFrameSize
+	case *lengthPrefixedFramer:
+		reader, writer, maxFrameSize = current.reader, current.writer, current.maxFrameSize
+	default:
+		return nil, fmt.Errorf("framer %T can't be switched", framer)
+	}
+
+	switch mode {
+	case FramingNewline:
+		return &newlineFramer{reader: reader, writer: writer, maxFrameSize: maxFrameSize}, nil
+	case FramingLengthPrefixed:
+		return &lengthPrefixedFramer{reader: reader, writer: writer, maxFrameSize: maxFrameSize}, nil
+	default:
+		return nil, fmt.Errorf("unknown framing %q", mode)
+	}
+}
+
+// negotiateFramer inspects the first bytes sent by the client and returns the framer it asked for.
+func negotiateFramer(rw io.ReadWriter, maxFrameSize int) (Framer, error) {
+	reader := bufio.NewReader(rw)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] != LengthPrefixedPreamble[0] {
+		return &newlineFramer{reader: reader, writer: rw, maxFrameSize: maxFrameSize}, nil
+	}
+
+	preamble := make([]byte, len(LengthPrefixedPreamble))
+	if _, err := io.ReadFull(reader, preamble); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(preamble, LengthPrefixedPreamble) {
+		return nil, fmt.Errorf("invalid framing preamble %q", preamble)
+	}
+
+	return &lengthPrefixedFramer{reader: reader, writer: rw, maxFrameSize: maxFrameSize}, nil
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lengthPrefixed returns data as a length-prefixed frame.
+func lengthPrefixed(data []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...)
+}
+
+func TestLengthPrefixedFramer_SUCCESS_Round_Trip(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":        {},
+		"json":         []byte(`{"command":["echo","hello"]}`),
+		"binary":       {0x00, '\n', 0xff, '\r', '\n', 0x01},
+		"maximum size": bytes.Repeat([]byte("a"), 64),
+	}
+
+	for name, data := range tests {
+		var stream bytes.Buffer
+		framer := NewLengthPrefixedFramer(&stream, 64)
+
+		err := framer.WriteFrame(data)
+		assert.Nil(t, err, "Writing a frame should not return error: "+name)
+		assert.Equal(t, lengthPrefixed(data), stream.Bytes(), "The frame should be prefixed by its size: "+name)
+
+		frame, err := framer.ReadFrame()
+		assert.Nil(t, err, "Reading a frame should not return error: "+name)
+		assert.Equal(t, data, frame, "The frame read should be the frame written: "+name)
+	}
+}
+
+func TestLengthPrefixedFramer_ERROR_Frames(t *testing.T) {
+	tests := map[string]struct {
+		stream []byte
+		err    error
+	}{
+		"one byte over the limit": {stream: lengthPrefixed(bytes.Repeat([]byte("a"), 65)), err: ErrFrameTooLarge},
+		"huge size":               {stream: []byte{0xff, 0xff, 0xff, 0xff}, err: ErrFrameTooLarge},
+		"truncated prefix":        {stream: []byte{0x00, 0x00}, err: io.ErrUnexpectedEOF},
+		"truncated frame":         {stream: lengthPrefixed([]byte("hello"))[:6], err: io.ErrUnexpectedEOF},
+		"no frame":                {stream: nil, err: io.EOF},
+	}
+
+	for name, test := range tests {
+		framer := NewLengthPrefixedFramer(bytes.NewBuffer(test.stream), 64)
+
+		_, err := framer.ReadFrame()
+		assert.ErrorIs(t, err, test.err, "Reading the frame should fail: "+name)
+	}
+}
+
+func TestNewlineFramer_SUCCESS_Lines(t *testing.T) {
+	tests := map[string]struct {
+		stream       string
+		maxFrameSize int
+		frame        string
+	}{
+		"line":                        {stream: "{}\n", maxFrameSize: 64, frame: "{}"},
+		"crlf":                        {stream: "{}\r\n", maxFrameSize: 64, frame: "{}"},
+		"maximum size":                {stream: strings.Repeat("a", 64) + "\n", maxFrameSize: 64, frame: strings.Repeat("a", 64)},
+		"maximum size with crlf":      {stream: strings.Repeat("a", 64) + "\r\n", maxFrameSize: 64, frame: strings.Repeat("a", 64)},
+		"longer than the read buffer": {stream: strings.Repeat("a", 10000) + "\n", maxFrameSize: 10000, frame: strings.Repeat("a", 10000)},
+	}
+
+	for name, test := range tests {
+		framer := NewNewlineFramer(bytes.NewBufferString(test.stream), test.maxFrameSize)
+
+		frame, err := framer.ReadFrame()
+		assert.Nil(t, err, "Reading the line should not return error: "+name)
+		assert.Equal(t, test.frame, string(frame), "The line should be read without its terminator: "+name)
+	}
+}
+
+func TestNewlineFramer_ERROR_Line_Too_Long(t *testing.T) {
+	tests := map[string]struct {
+		stream       string
+		maxFrameSize int
+	}{
+		"one byte over the limit":      {stream: strings.Repeat("a", 65) + "\n", maxFrameSize: 64},
+		"one byte over with crlf":      {stream: strings.Repeat("a", 65) + "\r\n", maxFrameSize: 64},
+		"without newline":              {stream: strings.Repeat("a", 100), maxFrameSize: 64},
+		"longer than the read buffer":  {stream: strings.Repeat("a", 10001) + "\n", maxFrameSize: 10000},
+		"never ending line over limit": {stream: strings.Repeat("a", 1<<20), maxFrameSize: 10000},
+	}
+
+	for name, test := range tests {
+		framer := NewNewlineFramer(bytes.NewBufferString(test.stream), test.maxFrameSize)
+
+		_, err := framer.ReadFrame()
+		assert.ErrorIs(t, err, ErrFrameTooLarge, "Lines over the limit should be rejected: "+name)
+	}
+}
+
+func TestNewlineFramer_SUCCESS_Write(t *testing.T) {
+	var stream bytes.Buffer
+	framer := NewNewlineFramer(&stream, 64)
+
+	err := framer.WriteFrame([]byte("{}"))
+	assert.Nil(t, err, "Writing a frame should not return error")
+	assert.Equal(t, "{}\n", stream.String(), "Frames should be terminated by a newline")
+}
+
+func TestFramer_SUCCESS_Wait_Frame_Does_Not_Consume(t *testing.T) {
+	tests := map[string]struct {
+		framer Framer
+		frame  string
+	}{
+		"newline":         {framer: NewNewlineFramer(bytes.NewBufferString("first\nsecond\n"), 64), frame: "first"},
+		"length-prefixed": {framer: NewLengthPrefixedFramer(bytes.NewBuffer(lengthPrefixed([]byte("first"))), 64), frame: "first"},
+	}
+
+	for name, test := range tests {
+		assert.Nil(t, test.framer.WaitFrame(), "Waiting for a frame already sent should not return error: "+name)
+		assert.Nil(t, test.framer.WaitFrame(), "Waiting twice should not consume the frame: "+name)
+
+		frame, err := test.framer.ReadFrame()
+		assert.Nil(t, err, "Reading the frame waited for should not return error: "+name)
+		assert.Equal(t, test.frame, string(frame), "The frame waited for should be read whole: "+name)
+	}
+
+	framer := NewNewlineFramer(&bytes.Buffer{}, 64)
+	assert.ErrorIs(t, framer.WaitFrame(), io.EOF, "Waiting on a closed stream should return EOF")
+}
+
+func TestNegotiateFramer_SUCCESS_Modes(t *testing.T) {
+	tests := map[string]struct {
+		stream []byte
+		mode   string
+	}{
+		"newline":         {stream: []byte("{}\n"), mode: FramingNewline},
+		"length-prefixed": {stream: append(append([]byte{}, LengthPrefixedPreamble...), lengthPrefixed([]byte("{}"))...), mode: FramingLengthPrefixed},
+	}
+
+	for name, test := range tests {
+		framer, err := negotiateFramer(bytes.NewBuffer(test.stream), 64)
+		assert.Nil(t, err, "Negotiating the framing should not return error: "+name)
+		assert.Equal(t, test.mode, framer.Mode(), "The framing asked by the client should be used: "+name)
+
+		frame, err := framer.ReadFrame()
+		assert.Nil(t, err, "The first frame should be read after the negotiation: "+name)
+		assert.Equal(t, "{}", string(frame), "The first frame should be read whole: "+name)
+	}
+
+	_, err := negotiateFramer(bytes.NewBuffer([]byte{0x00, 'X', 'Y', 'Z'}), 64)
+	assert.NotNil(t, err, "An invalid preamble should be rejected")
+}
+
+func TestSwitchFramer_SUCCESS_Keeps_Buffered_Frames(t *testing.T) {
+	stream := bytes.NewBufferString("{\"type\":\"hello\"}\n")
+	stream.Write(lengthPrefixed([]byte("{}")))
+
+	framer := NewNewlineFramer(stream, 64)
+	_, err := framer.ReadFrame()
+	assert.Nil(t, err, "Reading the hello should not return error")
+
+	switched, err := switchFramer(framer, FramingLengthPrefixed)
+	assert.Nil(t, err, "Switching the framing should not return error")
+
+	frame, err := switched.ReadFrame()
+	assert.Nil(t, err, "The frame already buffered should be read with the new framing")
+	assert.Equal(t, "{}", string(frame), "The frame already buffered should be read whole")
+
+	_, err = switchFramer(framer, "unknown")
+	assert.NotNil(t, err, "Unknown framings should be rejected")
+}
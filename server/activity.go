@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+const requestInfoCtxKey = "requestInfo"
+
+var (
+	errConnectionNotFound = errors.New("connection not found")
+	errRequestNotFound    = errors.New("request not found")
+)
+
+// activity tracks the open connections and the requests queued or running on every transport,
+// so operators can see what the server is doing and kill connections or requests.
+// A nil activity tracks nothing, but still records the timing of the requests.
+type activity struct {
+	mu          sync.Mutex
+	connections map[string]*activeConnection
+	requests    map[string]*requestInfo
+	// redactor hides the secrets of the listed commands, as in the logs.
+	redactor *redactor
+}
+
+type activeConnection struct {
+	id          string
+	transport   string
+	remoteAddr  string
+	connectedAt time.Time
+	// close ends the connection, aborting its requests.
+	close func()
+}
+
+func newActivity() *activity {
+	return &activity{
+		connections: map[string]*activeConnection{},
+		requests:    map[string]*requestInfo{},
+	}
+}
+
+func (a *activity) connect(id string, transport string, remoteAddr string, close func()) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.connections[id] = &activeConnection{
+		id:          id,
+		transport:   transport,
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now(),
+		close:       close,
+	}
+}
+
+func (a *activity) disconnect(id string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.connections, id)
+}
+
+// queue records a request waiting for a slot in the scheduler. The returned context is
+// cancelled when the request is killed, every queue must be followed by a finish.
+func (a *activity) queue(ctx context.Context, info *requestInfo) context.Context {
+	ctx, info.cancel = context.WithCancel(context.WithValue(ctx, requestInfoCtxKey, info))
+	info.id = uuid.New().String()
+
+	if a == nil {
+		info.queuedAt = time.Now()
+		return ctx
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info.queuedAt = time.Now()
+	a.requests[info.id] = info
+
+	return ctx
+}
+
+// start records that a queued request got a slot and is running.
+func (a *activity) start(info *requestInfo) {
+	if a == nil {
+		info.startedAt = time.Now()
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info.startedAt = time.Now()
+}
+
+// finish records that a request is done, whether it ran or not.
+func (a *activity) finish(info *requestInfo) {
+	defer info.cancel()
+
+	if a == nil {
+		if !info.startedAt.IsZero() {
+			info.finishedAt = time.Now()
+		}
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !info.startedAt.IsZero() {
+		info.finishedAt = time.Now()
+	}
+	delete(a.requests, info.id)
+}
+
+// killConnection closes a connection, the requests it has queued or running are cancelled.
+func (a *activity) killConnection(id string) error {
+	a.mu.Lock()
+	conn, ok := a.connections[id]
+	a.mu.Unlock()
+
+	if !ok {
+		return errConnectionNotFound
+	}
+
+	conn.close()
+
+	return nil
+}
+
+// killRequest cancels a request, stopping its command when it is running.
+func (a *activity) killRequest(id string) error {
+	a.mu.Lock()
+	info, ok := a.requests[id]
+	a.mu.Unlock()
+
+	if !ok {
+		return errRequestNotFound
+	}
+
+	info.killed.Store(true)
+	info.cancel()
+
+	return nil
+}
+
+// listConnections returns the open connections, oldest first.
+func (a *activity) listConnections() []models.AdminConnection {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	requests := map[string]int{}
+	for _, info := range a.requests {
+		if info.connectionID != "" {
+			requests[info.connectionID]++
+		}
+	}
+
+	connections := make([]models.AdminConnection, 0, len(a.connections))
+	for _, conn := range a.connections {
+		connections = append(connections, models.AdminConnection{
+			ID:          conn.id,
+			Transport:   conn.transport,
+			RemoteAddr:  conn.remoteAddr,
+			ConnectedAt: conn.connectedAt.UnixMilli(),
+			Requests:    requests[conn.id],
+		})
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ConnectedAt < connections[j].ConnectedAt
+	})
+
+	return connections
+}
+
+// listRequests returns the requests in the given state, in the order they were queued,
+// which is the order queued requests are admitted in.
+func (a *activity) listRequests(state string) []models.AdminRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+
+	var infos []*requestInfo
+	for _, info := range a.requests {
+		if requestState(info) == state {
+			infos = append(infos, info)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].queuedAt.Before(infos[j].queuedAt)
+	})
+
+	requests := make([]models.AdminRequest, 0, len(infos))
+	for _, info := range infos {
+		redacted := a.redactor.request(models.TaskRequest{Command: info.command, Shell: info.shell})
+		request := models.AdminRequest{
+			ID:            info.id,
+			CorrelationID: info.correlationID,
+			RequestID:     info.requestID,
+			ConnectionID:  info.connectionID,
+			Transport:     info.transport,
+			Command:       redacted.Command,
+			Task:          info.task,
+			Shell:         redacted.Shell,
+			RemoteAddr:    info.remoteAddr,
+			State:         state,
+			PID:           int(info.pid.Load()),
+			QueuedAt:      info.queuedAt.UnixMilli(),
+			ElapsedMs:     float64(now.Sub(info.queuedAt)) / float64(time.Millisecond),
+		}
+
+		if !info.startedAt.IsZero() {
+			request.StartedAt = info.startedAt.UnixMilli()
+			request.ElapsedMs = float64(now.Sub(info.startedAt)) / float64(time.Millisecond)
+		}
+
+		requests = append(requests, request)
+	}
+
+	return requests
+}
+
+// requestState must be called with a.mu held.
+func requestState(info *requestInfo) string {
+	if info.startedAt.IsZero() {
+		return models.RequestStateQueued
+	}
+
+	return models.RequestStateRunning
+}
+
+// ProcessStarted reports the PID of the command run for the request of ctx, so it is listed
+// on the admin endpoint.
+func ProcessStarted(ctx context.Context, pid int) {
+	if info, ok := ctx.Value(requestInfoCtxKey).(*requestInfo); ok {
+		info.pid.Store(int64(pid))
+	}
+}
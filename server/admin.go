@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.uber.org/zap"
+)
+
+// admin serves the introspection API of the server on its own address, so it can be kept
+// away from clients: it lists the connections, running processes and queue, and kills
+// connections or requests.
+type admin struct {
+	common   common.Common
+	activity *activity
+	// token is required as a bearer token on every request when not empty.
+	token  atomic.Pointer[string]
+	logger *zap.SugaredLogger
+}
+
+func newAdmin(activity *activity, token string, logger *zap.SugaredLogger) *admin {
+	admin := &admin{
+		common:   common.NewCommonLib(),
+		activity: activity,
+		logger:   logger,
+	}
+	admin.setToken(token)
+
+	return admin
+}
+
+// setToken replaces the token required by the next requests, an empty token disables authentication.
+func (a *admin) setToken(token string) {
+	a.token.Store(&token)
+}
+
+func (a *admin) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /connections", a.handleConnections)
+	mux.HandleFunc("DELETE /connections/{id}", a.handleKillConnection)
+	mux.HandleFunc("GET /processes", a.handleProcesses)
+	mux.HandleFunc("GET /queue", a.handleQueue)
+	mux.HandleFunc("DELETE /requests/{id}", a.handleKillRequest)
+
+	return a.authenticate(mux)
+}
+
+// authenticate rejects requests without the admin token, when one is configured.
+func (a *admin) authenticate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := *a.token.Load()
+		if expected == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			a.writeJSON(w, http.StatusUnauthorized, models.AdminError{Error: "invalid admin token"})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (a *admin) handleConnections(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.activity.listConnections())
+}
+
+// handleProcesses lists the requests running a command, with their PID once it started.
+func (a *admin) handleProcesses(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.activity.listRequests(models.RequestStateRunning))
+}
+
+// handleQueue lists the requests waiting for a slot, in the order they will be admitted.
+func (a *admin) handleQueue(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.activity.listRequests(models.RequestStateQueued))
+}
+
+func (a *admin) handleKillConnection(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := a.activity.killConnection(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.logger.Infow("Connection killed by an operator", "CID", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) handleKillRequest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := a.activity.killRequest(id); err != nil {
+		a.writeError(w, err)
+		return
+	}
+
+	a.logger.Infow("Request killed by an operator", "ID", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *admin) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errConnectionNotFound) || errors.Is(err, errRequestNotFound) {
+		status = http.StatusNotFound
+	}
+
+	a.writeJSON(w, status, models.AdminError{Error: err.Error()})
+}
+
+func (a *admin) writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := a.common.Marshal(v)
+	if err != nil {
+		a.logger.Errorw("Error on Marshall Response", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
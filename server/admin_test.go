@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// adminGet decodes the answer of a GET on the admin API into v.
+func adminGet(t *testing.T, handler http.Handler, path string, v any) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "The admin API should answer "+path)
+	err := json.Unmarshal(recorder.Body.Bytes(), v)
+	assert.Nil(t, err, "The admin API should answer with JSON")
+}
+
+func adminDelete(handler http.Handler, path string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, path, nil))
+
+	return recorder.Code
+}
+
+func TestAdmin_SUCCESS_List_And_Kill(t *testing.T) {
+	activity := newActivity()
+	newNetwork := &networkImpl{
+		common:    common.NewCommonLib(),
+		scheduler: newScheduler(1),
+		activity:  activity,
+	}
+	handler := newAdmin(activity, "", zap.NewNop().Sugar()).routes()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		ProcessStarted(ctx, 4242)
+		<-ctx.Done()
+		return models.TaskResult{ExitCode: -1, Error: "command cancelled"}
+	}
+
+	runningServer, runningClient := net.Pipe()
+	defer runningClient.Close()
+	queuedServer, queuedClient := net.Pipe()
+	defer queuedClient.Close()
+
+	go newNetwork.HandleConnection(context.Background(), runningServer, callback)
+	go newNetwork.HandleConnection(context.Background(), queuedServer, callback)
+
+	runningClient.Write([]byte(`{"command":["sleep","60"]}` + "\n"))
+	time.Sleep(100 * time.Millisecond)
+	queuedClient.Write([]byte(`{"command":["echo","hi"]}` + "\n"))
+	time.Sleep(100 * time.Millisecond)
+
+	var connections []models.AdminConnection
+	adminGet(t, handler, "/connections", &connections)
+	assert.Len(t, connections, 2, "Every open connection should be listed")
+	for _, connection := range connections {
+		assert.Equal(t, transportTCP, connection.Transport, "The transport of the connection should be listed")
+		assert.Equal(t, 1, connection.Requests, "The requests of the connection should be counted")
+	}
+
+	var processes []models.AdminRequest
+	adminGet(t, handler, "/processes", &processes)
+	assert.Len(t, processes, 1, "The running request should be listed")
+	assert.Equal(t, 4242, processes[0].PID, "The PID reported by the callback should be listed")
+	assert.Equal(t, []string{"sleep", "60"}, processes[0].Command, "The command should be listed")
+	assert.Equal(t, models.RequestStateRunning, processes[0].State, "The request should be running")
+	assert.Greater(t, processes[0].ElapsedMs, 0.0, "The time the process has been running should be listed")
+
+	var queue []models.AdminRequest
+	adminGet(t, handler, "/queue", &queue)
+	assert.Len(t, queue, 1, "The request waiting for the scheduler should be listed")
+	assert.Equal(t, []string{"echo", "hi"}, queue[0].Command, "The command should be listed")
+	assert.Zero(t, queue[0].PID, "A queued request should have no PID")
+
+	queuedClient.SetReadDeadline(time.Now().Add(3 * time.Second))
+	queuedDecoder := json.NewDecoder(bufio.NewReader(queuedClient))
+	var result models.TaskResult
+
+	assert.Equal(t, http.StatusNoContent, adminDelete(handler, "/requests/"+queue[0].ID), "Killing a queued request should succeed")
+	err := queuedDecoder.Decode(&result)
+	assert.Nil(t, err, "A killed request should be answered")
+	assert.Equal(t, "command cancelled before it started", result.Error, "A killed queued request should be cancelled")
+
+	runningClient.SetReadDeadline(time.Now().Add(3 * time.Second))
+	runningDecoder := json.NewDecoder(bufio.NewReader(runningClient))
+
+	assert.Equal(t, http.StatusNoContent, adminDelete(handler, "/requests/"+processes[0].ID), "Killing a running request should succeed")
+	err = runningDecoder.Decode(&result)
+	assert.Nil(t, err, "A killed request should be answered")
+	assert.Equal(t, "command cancelled", result.Error, "A killed running request should be cancelled through its context")
+
+	assert.Equal(t, http.StatusNoContent, adminDelete(handler, "/connections/"+result.CorrelationID), "Killing a connection should succeed")
+	_, err = runningClient.Read(make([]byte, 1))
+	assert.NotNil(t, err, "A killed connection should be closed")
+
+	time.Sleep(100 * time.Millisecond)
+	adminGet(t, handler, "/connections", &connections)
+	assert.Len(t, connections, 1, "A killed connection should not be listed anymore")
+}
+
+func TestAdmin_ERROR_Not_Found(t *testing.T) {
+	handler := newAdmin(newActivity(), "", zap.NewNop().Sugar()).routes()
+
+	assert.Equal(t, http.StatusNotFound, adminDelete(handler, "/requests/unknown"), "Killing an unknown request should return 404")
+	assert.Equal(t, http.StatusNotFound, adminDelete(handler, "/connections/unknown"), "Killing an unknown connection should return 404")
+}
+
+func TestAdmin_ERROR_Unauthorized(t *testing.T) {
+	handler := newAdmin(newActivity(), "secret", zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Requests without the token should be rejected")
+
+	request := httptest.NewRequest(http.MethodGet, "/connections", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Requests with a wrong token should be rejected")
+
+	request = httptest.NewRequest(http.MethodGet, "/connections", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Requests with the token should be answered")
+}
+
+func TestAdmin_SUCCESS_Redacted_Requests(t *testing.T) {
+	activity := newActivity()
+	activity.redactor, _ = newRedactor(Redaction{Patterns: []string{`password=\S+`}})
+	handler := newAdmin(activity, "", zap.NewNop().Sugar()).routes()
+
+	command := newRequestInfo("cid", "").describe(transportTCP, "", "", models.Envelope{Command: []string{"login", "password=hunter2"}})
+	ctx := activity.queue(context.Background(), command)
+	defer activity.finish(command)
+
+	script := newRequestInfo("cid", "").describe(transportTCP, "", "", models.Envelope{Shell: "login password=hunter2 | cat"})
+	activity.queue(ctx, script)
+	defer activity.finish(script)
+
+	var queue []models.AdminRequest
+	adminGet(t, handler, "/queue", &queue)
+
+	assert.Len(t, queue, 2, "Both requests should be listed")
+	assert.Equal(t, []string{"login", "[REDACTED]"}, queue[0].Command, "Secrets of the commands should be hidden as in the logs")
+	assert.Equal(t, "login [REDACTED] | cat", queue[1].Shell, "Secrets of the shell scripts should be hidden as in the logs")
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const errInvalidClientToken = "invalid client token"
+
+// clientAuth holds the token clients must present: TCP clients send it in their hello, HTTP and
+// gRPC clients as a bearer token. Every client is accepted while the token is empty.
+type clientAuth struct {
+	token atomic.Pointer[string]
+}
+
+func newClientAuth(token string) *clientAuth {
+	auth := &clientAuth{}
+	auth.set(token)
+
+	return auth
+}
+
+// set replaces the token required from the next clients, an empty token disables authentication.
+func (a *clientAuth) set(token string) {
+	a.token.Store(&token)
+}
+
+// required reports whether clients must present a token.
+func (a *clientAuth) required() bool {
+	return a != nil && *a.token.Load() != ""
+}
+
+// valid reports whether a client presenting token is accepted.
+func (a *clientAuth) valid(token string) bool {
+	if !a.required() {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(*a.token.Load())) == 1
+}
+
+// bearer returns the token of an Authorization header, or an empty token when it isn't a bearer token.
+func bearer(authorization string) string {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	return token
+}
+
+// grpcToken returns the bearer token sent in the authorization metadata of a gRPC call.
+func grpcToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+
+	return bearer(values[0])
+}
+
+// unaryInterceptor rejects the unary gRPC calls without the client token.
+func (a *clientAuth) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !a.valid(grpcToken(ctx)) {
+		return nil, status.Error(codes.Unauthenticated, errInvalidClientToken)
+	}
+
+	return handler(ctx, req)
+}
+
+// streamInterceptor rejects the streaming gRPC calls without the client token.
+func (a *clientAuth) streamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !a.valid(grpcToken(stream.Context())) {
+		return status.Error(codes.Unauthenticated, errInvalidClientToken)
+	}
+
+	return handler(srv, stream)
+}
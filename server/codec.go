@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+)
+
+const codecCtxKey = "codec"
+
+// WithCodec returns a context carrying the codec the request was encoded with.
+func WithCodec(ctx context.Context, codec common.Codec) context.Context {
+	return context.WithValue(ctx, codecCtxKey, codec)
+}
+
+// CodecFromContext returns the codec a request was encoded with, JSON when none was negotiated.
+func CodecFromContext(ctx context.Context) common.Codec {
+	codec, ok := ctx.Value(codecCtxKey).(common.Codec)
+	if !ok || codec == nil {
+		return common.NewJSONCodec()
+	}
+
+	return codec
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.uber.org/zap"
+)
+
+// connection holds the state shared by the requests in flight on a single client connection.
+type connection struct {
+	conn   net.Conn
+	framer common.Framer
+	codec  common.Codec
+	common common.Common
+	config networkConfig
+	logger *zap.SugaredLogger
+	// redactor hides secrets of the results from the logs.
+	redactor *redactor
+
+	// protocol is agreed in the optional hello exchange, clients that skip it get version 1 defaults.
+	protocol models.HelloResult
+	messages int
+	// authenticated is set once the hello presented the client token.
+	authenticated bool
+
+	// writeMu serializes responses of pipelined requests, which finish in any order.
+	writeMu sync.Mutex
+
+	// pipeline bounds how many pipelined requests can be in flight, reading stops when it is full.
+	pipeline chan struct{}
+	inFlight sync.WaitGroup
+
+	// requests holds the cancel functions of the pipelined requests in flight, by id.
+	requestsMu sync.Mutex
+	requests   map[string]context.CancelFunc
+}
+
+func newConnection(conn net.Conn, framer common.Framer, lib common.Common, config networkConfig, logger *zap.SugaredLogger) *connection {
+	maxPipelined := config.maxPipelinedRequests
+	if maxPipelined <= 0 {
+		maxPipelined = 1
+	}
+
+	codec, err := lib.NewCodec(common.CodecJSON)
+	if err != nil {
+		codec = common.NewJSONCodec()
+	}
+
+	return &connection{
+		conn:     conn,
+		framer:   framer,
+		codec:    codec,
+		common:   lib,
+		config:   config,
+		logger:   logger,
+		pipeline: make(chan struct{}, maxPipelined),
+		requests: map[string]context.CancelFunc{},
+
+		protocol: models.HelloResult{
+			Type:        models.MessageTypeHello,
+			Version:     models.ProtocolVersion,
+			Framing:     framer.Mode(),
+			Codec:       common.CodecJSON,
+			Compression: common.CompressionNone,
+		},
+	}
+}
+
+// busy reports whether pipelined requests are still being executed.
+func (c *connection) busy() bool {
+	return len(c.pipeline) > 0
+}
+
+// drain waits for the pipelined requests in flight to finish once the server stops, and aborts
+// them when the drain timeout is exceeded. It waits without limit when the timeout is <= 0.
+func (c *connection) drain(abort context.CancelFunc) {
+	finished := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(finished)
+	}()
+
+	var timeout <-chan time.Time
+	if c.config.drainTimeout > 0 {
+		timer := time.NewTimer(c.config.drainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-finished:
+	case <-timeout:
+		c.logger.Warnw("Drain timeout exceeded, aborting the requests in flight", "DrainTimeout", c.config.drainTimeout)
+		abort()
+	}
+}
+
+// track registers a pipelined request so it can be cancelled. It returns false when a
+// request with the same id is already in flight.
+func (c *connection) track(id string, cancel context.CancelFunc) bool {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	if _, ok := c.requests[id]; ok {
+		return false
+	}
+
+	c.requests[id] = cancel
+	return true
+}
+
+func (c *connection) untrack(id string) {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	delete(c.requests, id)
+}
+
+// cancel stops a pipelined request in flight. It returns false when there is no such request.
+func (c *connection) cancel(id string) bool {
+	c.requestsMu.Lock()
+	defer c.requestsMu.Unlock()
+
+	cancel, ok := c.requests[id]
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+// streaming reports whether the client negotiated to receive output while requests run.
+func (c *connection) streaming() bool {
+	return slices.Contains(c.protocol.Features, models.FeatureStreaming)
+}
+
+// writeResult encodes a result with the connection codec and sends it as a single frame.
+func (c *connection) writeResult(result interface{}) error {
+	c.logger.Infow("Return Result", "Result", c.redactor.result(result))
+
+	return c.writeMessage(result)
+}
+
+// writeMessage encodes a message with the connection codec and sends it as a single frame.
+func (c *connection) writeMessage(message interface{}) error {
+	responseData, err := c.codec.Marshal(message)
+	if err != nil {
+		c.logger.Errorw("Error on Marshall Response", "Error", err)
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(deadline(c.config.writeTimeout))
+	if err := c.framer.WriteFrame(responseData); err != nil {
+		if isTimeout(err) {
+			c.logger.Infow("Closing connection", "Reason", "write timeout exceeded", "WriteTimeout", c.config.writeTimeout)
+			return err
+		}
+
+		c.logger.Errorw("Error on Sending Response", "Error", err)
+		return err
+	}
+
+	return nil
+}
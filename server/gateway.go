@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// gateway exposes the task execution API over HTTP. Requests share the scheduler,
+// and therefore the concurrency limits, with the TCP endpoint.
+type gateway struct {
+	common    common.Common
+	config    gatewayConfig
+	scheduler *scheduler
+	callback  func(ctx context.Context, req []byte) interface{}
+	logger    *zap.SugaredLogger
+	// activity lists the requests on the admin endpoint, when not nil.
+	activity *activity
+	// redactor hides secrets of the requests from the logs.
+	redactor *redactor
+	// auth rejects the requests without the client token, when one is configured.
+	auth *clientAuth
+}
+
+// headerRequestID carries the correlation ID chosen by HTTP clients, it is echoed in the response.
+const headerRequestID = "X-Request-ID"
+
+type gatewayConfig struct {
+	serverID string
+
+	maxRequestSize       int
+	maxPipelinedRequests int
+}
+
+func newGateway(config gatewayConfig, scheduler *scheduler, callback func(ctx context.Context, req []byte) interface{}, logger *zap.SugaredLogger) *gateway {
+	return &gateway{
+		common:    common.NewCommonLib(),
+		config:    config,
+		scheduler: scheduler,
+		callback:  callback,
+		logger:    logger,
+	}
+}
+
+func (g *gateway) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tasks", g.handleTask)
+	mux.HandleFunc("GET /ws", g.handleWebSocket)
+
+	return g.authenticate(mux)
+}
+
+// authenticate rejects requests without the client token as a bearer token, when one is configured.
+func (g *gateway) authenticate(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.auth.valid(bearer(r.Header.Get("Authorization"))) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			g.writeJSON(w, http.StatusUnauthorized, models.TaskResult{
+				ExitCode: exitCodeErrorGeneral,
+				Error:    errInvalidClientToken,
+			})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// handleTask executes a TaskRequest and answers with its TaskResult. Clients accepting
+// text/event-stream receive the command output as "output" events while it runs,
+// followed by a "result" event.
+func (g *gateway) handleTask(w http.ResponseWriter, r *http.Request) {
+	correlationID := requestCorrelationID(r.Header.Get(headerRequestID), uuid.New().String())
+	logger := g.logger.With(zap.String("CID", correlationID))
+	w.Header().Set(headerRequestID, correlationID)
+
+	request, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(g.config.maxRequestSize)))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			g.writeJSON(w, http.StatusRequestEntityTooLarge, models.TaskResult{
+				ExitCode: exitCodeErrorGeneral,
+				Error:    fmt.Sprintf("request too large: limit is %d bytes", g.config.maxRequestSize),
+			})
+			return
+		}
+
+		logger.Errorw("Error reading HTTP request", "Error", err)
+		g.writeJSON(w, http.StatusBadRequest, models.TaskResult{
+			ExitCode: exitCodeErrorGeneral,
+			Error:    fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	logger.Infow("Received HTTP Request", "Request", g.redactor.payload(common.NewJSONCodec(), request))
+
+	// The trace context of the request body wins over the one of the HTTP headers
+	var envelope models.Envelope
+	g.common.Unmarshal(request, &envelope)
+
+	// Likewise for the correlation ID
+	if models.ValidCorrelationID(envelope.CorrelationID) && envelope.CorrelationID != correlationID {
+		correlationID = envelope.CorrelationID
+		logger = g.logger.With(zap.String("CID", correlationID))
+		w.Header().Set(headerRequestID, correlationID)
+	}
+	info := newRequestInfo(correlationID, g.config.serverID).describe(transportHTTP, "", r.RemoteAddr, envelope)
+
+	ctx := propagation.TraceContext{}.Extract(context.WithValue(r.Context(), "logger", logger), propagation.HeaderCarrier(r.Header))
+	ctx, span := startRequestSpan(ctx, transportHTTP, envelope, trace.WithAttributes(attributeCorrelationID.String(correlationID)))
+	defer span.End()
+
+	var events *eventStream
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		events, err = newEventStream(w, g.common)
+		if err != nil {
+			g.writeJSON(w, http.StatusNotAcceptable, models.TaskResult{
+				ExitCode: exitCodeErrorGeneral,
+				Error:    err.Error(),
+			})
+			return
+		}
+
+		ctx = WithOutputWriter(ctx, func(chunk models.OutputChunk) {
+			events.send("output", chunk)
+		})
+	}
+
+	// The request context is cancelled when the client goes away, giving up its place in the queue
+	ctx = g.activity.queue(ctx, info)
+	if err := traceAcquire(ctx, g.scheduler); err != nil {
+		g.activity.finish(info)
+		if !info.killed.Load() {
+			logger.Infow("HTTP client left before the request was scheduled", "Error", err)
+			return
+		}
+
+		result := info.annotate(cancelledBeforeStart(envelope.ID))
+		if events != nil {
+			events.send("result", result)
+			return
+		}
+
+		g.writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	g.activity.start(info)
+	result := traceExecution(ctx, g.callback, request)
+	g.activity.finish(info)
+	g.scheduler.release()
+
+	result = info.annotate(result)
+
+	if events != nil {
+		events.send("result", result)
+		return
+	}
+
+	g.writeJSON(w, http.StatusOK, result)
+}
+
+func (g *gateway) writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := g.common.Marshal(v)
+	if err != nil {
+		g.logger.Errorw("Error on Marshall Response", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// eventStream writes Server-Sent Events, flushing every event to the client as soon as it is sent.
+type eventStream struct {
+	mu      sync.Mutex
+	writer  http.ResponseWriter
+	flusher http.Flusher
+	common  common.Common
+}
+
+func newEventStream(w http.ResponseWriter, lib common.Common) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported by this connection")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &eventStream{
+		writer:  w,
+		flusher: flusher,
+		common:  lib,
+	}, nil
+}
+
+func (s *eventStream) send(event string, v any) error {
+	data, err := s.common.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.writer, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
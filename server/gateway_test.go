@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestGateway_SUCCESS(t *testing.T) {
+	var receivedRequest string
+	callback := func(ctx context.Context, req []byte) interface{} {
+		receivedRequest = string(req)
+		return models.TaskResult{Command: []string{"echo"}, Output: "hello"}
+	}
+
+	handler := newGateway(gatewayConfig{maxRequestSize: 1024}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo"]}`))
+	handler.ServeHTTP(recorder, request)
+
+	var result models.TaskResult
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "A valid request should return status 200")
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "The result should be returned as JSON")
+	assert.Nil(t, err, "The response body should be a valid TaskResult")
+	assert.Equal(t, "hello", result.Output, "The response should be the callback result")
+	assert.Equal(t, `{"command":["echo"]}`, receivedRequest, "The callback should receive the request body")
+}
+
+func TestGateway_ERROR_Request_Too_Large(t *testing.T) {
+	callbackWasCalled := false
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled = true
+		return nil
+	}
+
+	handler := newGateway(gatewayConfig{maxRequestSize: 8}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo","hello"]}`))
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code, "A request too large should return status 413")
+	assert.Contains(t, recorder.Body.String(), "request too large", "The error should explain the request was too large")
+	assert.Equal(t, false, callbackWasCalled, "Expected callback to be called 0 times")
+}
+
+func TestGateway_ERROR_Method_Not_Allowed(t *testing.T) {
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return nil
+	}
+
+	handler := newGateway(gatewayConfig{maxRequestSize: 1024}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/tasks", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code, "Only POST should be accepted on /tasks")
+}
+
+func TestGateway_ERROR_Client_Token(t *testing.T) {
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return models.TaskResult{Output: "hello"}
+	}
+
+	gateway := newGateway(gatewayConfig{maxRequestSize: 1024}, newScheduler(1), callback, zap.NewNop().Sugar())
+	gateway.auth = newClientAuth("secret")
+	handler := gateway.routes()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo"]}`)))
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Requests without the client token should return status 401")
+	assert.Contains(t, recorder.Body.String(), errInvalidClientToken, "The error should explain the token is invalid")
+	assert.False(t, callbackWasCalled.Load(), "Requests without the client token should not reach the callback")
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo"]}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "Requests with the client token should be executed")
+	assert.True(t, callbackWasCalled.Load(), "Requests with the client token should reach the callback")
+}
+
+func TestGateway_SUCCESS_Server_Sent_Events(t *testing.T) {
+	callback := func(ctx context.Context, req []byte) interface{} {
+		writeOutput, ok := OutputWriterFromContext(ctx)
+		assert.True(t, ok, "Streaming requests should receive an OutputWriter")
+
+		writeOutput(models.OutputChunk{Stream: models.StreamStdout, Data: "partial"})
+		return models.TaskResult{Output: "partial"}
+	}
+
+	handler := newGateway(gatewayConfig{maxRequestSize: 1024}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo"]}`))
+	request.Header.Set("Accept", "text/event-stream")
+	handler.ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"), "The response should be an event stream")
+	assert.Contains(t, body, "event: output\ndata: {\"stream\":\"stdout\",\"data\":\"partial\"}\n\n", "The output should be streamed as it is produced")
+	assert.Contains(t, body, "event: result\ndata: {", "The result should be sent as the last event")
+	assert.Less(t, strings.Index(body, "event: output"), strings.Index(body, "event: result"), "The output should be sent before the result")
+}
+
+func TestGateway_SUCCESS_WebSocket(t *testing.T) {
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+
+		writeOutput, ok := OutputWriterFromContext(ctx)
+		assert.True(t, ok, "WebSocket requests should receive an OutputWriter")
+		writeOutput(models.OutputChunk{Type: models.MessageTypeOutput, ID: request.ID, Stream: models.StreamStdout, Data: "live"})
+
+		return models.TaskResult{ID: request.ID, Output: "live"}
+	}
+
+	handler := newGateway(gatewayConfig{maxRequestSize: 1024, maxPipelinedRequests: 2}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws", nil)
+	assert.Nil(t, err, "Opening a WebSocket connection should not return error")
+	defer ws.Close()
+
+	err = ws.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","command":["echo"]}`))
+	assert.Nil(t, err, "Sending a request should not return error")
+
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	var chunk models.OutputChunk
+	err = ws.ReadJSON(&chunk)
+	assert.Nil(t, err, "The output should be sent while the command runs")
+	assert.Equal(t, models.MessageTypeOutput, chunk.Type, "Output messages should be tagged with the output type")
+	assert.Equal(t, "1", chunk.ID, "Output messages should be tagged with the request id")
+	assert.Equal(t, "live", chunk.Data, "Output messages should carry the command output")
+
+	var result models.TaskResult
+	err = ws.ReadJSON(&result)
+	assert.Nil(t, err, "The result should be sent when the command finishes")
+	assert.Equal(t, "1", result.ID, "The result should be tagged with the request id")
+}
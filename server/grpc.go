@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/hriqueXimenes/sumo_logic_server/server/taskpb"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService implements taskpb.TaskServiceServer on top of the same callback and scheduler
+// used by the TCP endpoint.
+type grpcService struct {
+	taskpb.UnimplementedTaskServiceServer
+
+	common    common.Common
+	scheduler *scheduler
+	jobs      *jobRegistry
+	serverID  string
+	callback  func(ctx context.Context, req []byte) interface{}
+	logger    *zap.SugaredLogger
+	// activity lists the requests on the admin endpoint, when not nil.
+	activity *activity
+	// redactor hides secrets of the requests from the logs.
+	redactor *redactor
+}
+
+func newGRPCService(scheduler *scheduler, jobs *jobRegistry, serverID string, callback func(ctx context.Context, req []byte) interface{}, logger *zap.SugaredLogger) *grpcService {
+	return &grpcService{
+		common:    common.NewCommonLib(),
+		scheduler: scheduler,
+		jobs:      jobs,
+		serverID:  serverID,
+		callback:  callback,
+		logger:    logger,
+	}
+}
+
+func (s *grpcService) Execute(ctx context.Context, req *taskpb.TaskRequest) (*taskpb.TaskResult, error) {
+	result, err := s.run(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return toProtoResult(result), nil
+}
+
+func (s *grpcService) ExecuteStream(req *taskpb.TaskRequest, stream taskpb.TaskService_ExecuteStreamServer) error {
+	// Output arrives concurrently from stdout and stderr, but a stream can only be written by one at a time
+	var sendMu sync.Mutex
+	writeOutput := func(chunk models.OutputChunk) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+
+		stream.Send(&taskpb.ExecuteStreamResponse{
+			Event: &taskpb.ExecuteStreamResponse_Output{
+				Output: &taskpb.OutputChunk{
+					Id:     chunk.ID,
+					Stream: chunk.Stream,
+					Data:   chunkData(chunk),
+				},
+			},
+		})
+	}
+
+	result, err := s.run(stream.Context(), req, writeOutput)
+	if err != nil {
+		return err
+	}
+
+	sendMu.Lock()
+	defer sendMu.Unlock()
+
+	return stream.Send(&taskpb.ExecuteStreamResponse{
+		Event: &taskpb.ExecuteStreamResponse_Result{
+			Result: toProtoResult(result),
+		},
+	})
+}
+
+func (s *grpcService) Cancel(ctx context.Context, req *taskpb.CancelRequest) (*taskpb.CancelResponse, error) {
+	cancelled, err := s.jobs.cancel(jobOwner(ctx), req.GetId())
+	if errors.Is(err, errJobNotFound) {
+		return nil, status.Errorf(codes.NotFound, "job %q not found", req.GetId())
+	}
+
+	return &taskpb.CancelResponse{Cancelled: cancelled}, nil
+}
+
+func (s *grpcService) GetJob(ctx context.Context, req *taskpb.GetJobRequest) (*taskpb.Job, error) {
+	job, ok := s.jobs.get(jobOwner(ctx), req.GetId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %q not found", req.GetId())
+	}
+
+	protoJob := &taskpb.Job{
+		Id:        job.id,
+		Command:   job.command,
+		State:     toProtoJobState(job.state),
+		CreatedAt: job.createdAt.UnixMilli(),
+	}
+
+	if job.result != nil {
+		protoJob.Result = toProtoResult(*job.result)
+	}
+
+	return protoJob, nil
+}
+
+// run registers a job, waits for a free slot in the scheduler and executes it through the callback.
+func (s *grpcService) run(ctx context.Context, req *taskpb.TaskRequest, writeOutput OutputWriter) (models.TaskResult, error) {
+	id := req.GetId()
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	correlationID := requestCorrelationID(req.GetCorrelationId(), uuid.New().String())
+	envelope := models.Envelope{
+		ID:          id,
+		Traceparent: req.GetTraceparent(),
+		Tracestate:  req.GetTracestate(),
+		Command:     req.GetCommand(),
+		Task:        req.GetTask(),
+		Shell:       req.GetShell(),
+	}
+
+	var remoteAddr string
+	if client, ok := peer.FromContext(ctx); ok {
+		remoteAddr = client.Addr.String()
+	}
+	owner := jobOwner(ctx)
+
+	info := newRequestInfo(correlationID, s.serverID).describe(transportGRPC, "", remoteAddr, envelope)
+	logger := s.logger.With(zap.String("CID", correlationID), zap.String("RequestID", id))
+
+	ctx, span := startRequestSpan(ctx, transportGRPC, envelope, trace.WithAttributes(attributeCorrelationID.String(correlationID)))
+	defer span.End()
+
+	jobCtx, cancel := context.WithCancel(context.WithValue(ctx, "logger", logger))
+	defer cancel()
+
+	if writeOutput != nil {
+		jobCtx = WithOutputWriter(jobCtx, writeOutput)
+	}
+
+	if err := s.jobs.register(owner, id, req.GetCommand(), cancel); err != nil {
+		return models.TaskResult{}, status.Errorf(codes.AlreadyExists, "job %q is already running", id)
+	}
+
+	taskRequest := models.TaskRequest{
+		ID:             id,
+		Command:        req.GetCommand(),
+		Timeout:        int(req.GetTimeout()),
+		OutputEncoding: req.GetOutputEncoding(),
+		Env:            req.GetEnv(),
+		Cwd:            req.GetCwd(),
+		Traceparent:    req.GetTraceparent(),
+		Tracestate:     req.GetTracestate(),
+		CorrelationID:  req.GetCorrelationId(),
+		Task:           req.GetTask(),
+		Shell:          req.GetShell(),
+	}
+	for name, value := range req.GetParams() {
+		if taskRequest.Params == nil {
+			taskRequest.Params = map[string]interface{}{}
+		}
+		taskRequest.Params[name] = value
+	}
+	request, err := s.common.Marshal(taskRequest)
+	if err != nil {
+		return models.TaskResult{}, status.Errorf(codes.Internal, "Error on Marshall Request: %v", err)
+	}
+
+	logger.Infow("Received gRPC Request", "Request", s.redactor.request(taskRequest))
+
+	jobCtx = s.activity.queue(jobCtx, info)
+	if err := traceAcquire(jobCtx, s.scheduler); err != nil {
+		s.activity.finish(info)
+
+		result := cancelledBeforeStart(id)
+		result.Command = req.GetCommand()
+		result = info.annotate(result).(models.TaskResult)
+		s.jobs.finish(owner, id, result)
+
+		return result, nil
+	}
+
+	s.jobs.start(owner, id)
+	s.activity.start(info)
+	response := traceExecution(jobCtx, s.callback, request)
+	s.activity.finish(info)
+	s.scheduler.release()
+
+	result, err := s.toTaskResult(response)
+	if err != nil {
+		logger.Errorw("Error converting result", "Error", err)
+		return models.TaskResult{}, status.Errorf(codes.Internal, "invalid result: %v", err)
+	}
+
+	result.ID = id
+	result = info.annotate(result).(models.TaskResult)
+	s.jobs.finish(owner, id, result)
+
+	return result, nil
+}
+
+// jobOwner identifies the caller of a gRPC call by the host of its address. Jobs can only be
+// queried and cancelled by the host that submitted them.
+func jobOwner(ctx context.Context) string {
+	client, ok := peer.FromContext(ctx)
+	if !ok || client.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(client.Addr.String())
+	if err != nil {
+		return client.Addr.String()
+	}
+
+	return host
+}
+
+// toTaskResult converts a callback response into a TaskResult.
+func (s *grpcService) toTaskResult(response interface{}) (models.TaskResult, error) {
+	if result, ok := response.(models.TaskResult); ok {
+		return result, nil
+	}
+
+	var result models.TaskResult
+	data, err := s.common.Marshal(response)
+	if err != nil {
+		return result, err
+	}
+
+	err = s.common.Unmarshal(data, &result)
+	return result, err
+}
+
+func toProtoResult(result models.TaskResult) *taskpb.TaskResult {
+	return &taskpb.TaskResult{
+		Id:             result.ID,
+		Command:        result.Command,
+		ExecutedAt:     result.ExecutedAt,
+		DurationMs:     result.DurationMs,
+		ExitCode:       int32(result.ExitCode),
+		Output:         result.Output,
+		Error:          result.Error,
+		Task:           result.Task,
+		OutputEncoding: result.OutputEncoding,
+		ErrorEncoding:  result.ErrorEncoding,
+		CorrelationId:  result.CorrelationID,
+		ServerId:       result.ServerID,
+		QueueWaitMs:    result.QueueWaitMs,
+		StartedAtNs:    result.StartedAtNs,
+		FinishedAtNs:   result.FinishedAtNs,
+	}
+}
+
+// chunkData returns the raw bytes of an output chunk, the data field of the gRPC chunk is binary safe.
+func chunkData(chunk models.OutputChunk) []byte {
+	if chunk.Encoding == models.OutputEncodingBase64 {
+		if data, err := base64.StdEncoding.DecodeString(chunk.Data); err == nil {
+			return data
+		}
+	}
+
+	return []byte(chunk.Data)
+}
+
+func toProtoJobState(state string) taskpb.JobState {
+	switch state {
+	case jobStateQueued:
+		return taskpb.JobState_JOB_STATE_QUEUED
+	case jobStateRunning:
+		return taskpb.JobState_JOB_STATE_RUNNING
+	case jobStateFinished:
+		return taskpb.JobState_JOB_STATE_FINISHED
+	case jobStateCancelled:
+		return taskpb.JobState_JOB_STATE_CANCELLED
+	default:
+		return taskpb.JobState_JOB_STATE_UNSPECIFIED
+	}
+}
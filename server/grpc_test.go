@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/hriqueXimenes/sumo_logic_server/server/taskpb"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, callback func(ctx context.Context, req []byte) interface{}) taskpb.TaskServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(grpcServer, newGRPCService(newScheduler(2), newJobRegistry(), "test-server", callback, zap.NewNop().Sugar()))
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err, "Creating a gRPC client should not return error")
+	t.Cleanup(func() { conn.Close() })
+
+	return taskpb.NewTaskServiceClient(conn)
+}
+
+func TestGRPC_SUCCESS_Execute(t *testing.T) {
+	var receivedRequest string
+	client := newTestGRPCClient(t, func(ctx context.Context, req []byte) interface{} {
+		receivedRequest = string(req)
+		return models.TaskResult{Command: []string{"echo", "hello"}, Output: "hello"}
+	})
+
+	result, err := client.Execute(context.Background(), &taskpb.TaskRequest{Id: "job-1", Command: []string{"echo", "hello"}, Timeout: 100})
+
+	assert.Nil(t, err, "Execute should not return error")
+	assert.Equal(t, "job-1", result.GetId(), "The result should carry the job id")
+	assert.Equal(t, "hello", result.GetOutput(), "The result should be the callback result")
+	assert.Equal(t, "test-server", result.GetServerId(), "The result should carry the server id")
+	assert.NotEmpty(t, result.GetCorrelationId(), "The result should carry the correlation id")
+	assert.NotZero(t, result.GetStartedAtNs(), "The result should report when the command started")
+	assert.JSONEq(t, `{"id":"job-1","command":["echo","hello"],"timeout":100}`, receivedRequest, "The callback should receive the request as a TaskRequest")
+
+	job, err := client.GetJob(context.Background(), &taskpb.GetJobRequest{Id: "job-1"})
+	assert.Nil(t, err, "GetJob should find a finished job")
+	assert.Equal(t, taskpb.JobState_JOB_STATE_FINISHED, job.GetState(), "The job should be finished")
+	assert.Equal(t, "hello", job.GetResult().GetOutput(), "The job should keep its result")
+}
+
+func TestGRPC_SUCCESS_Execute_Task(t *testing.T) {
+	resolver, err := newTaskResolver(testTasks, true)
+	assert.Nil(t, err, "Valid tasks should be accepted")
+
+	client := newTestGRPCClient(t, resolver.resolve(func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		CodecFromContext(ctx).Unmarshal(req, &request)
+		return models.TaskResult{Command: request.Command}
+	}))
+
+	result, err := client.Execute(context.Background(), &taskpb.TaskRequest{Task: "restart", Params: map[string]string{"service": "nginx"}})
+
+	assert.Nil(t, err, "Execute should not return error")
+	assert.Equal(t, []string{"systemctl", "restart", "nginx"}, result.GetCommand(), "The task should run with the parameters of the request")
+	assert.Equal(t, "restart", result.GetTask(), "The result should name the task")
+}
+
+func TestGRPC_SUCCESS_Execute_Stream(t *testing.T) {
+	client := newTestGRPCClient(t, func(ctx context.Context, req []byte) interface{} {
+		writeOutput, _ := OutputWriterFromContext(ctx)
+		writeOutput(models.OutputChunk{Stream: models.StreamStdout, Data: "partial"})
+		return models.TaskResult{Output: "partial"}
+	})
+
+	stream, err := client.ExecuteStream(context.Background(), &taskpb.TaskRequest{Command: []string{"echo"}})
+	assert.Nil(t, err, "ExecuteStream should not return error")
+
+	var events []*taskpb.ExecuteStreamResponse
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err, "Receiving events should not return error")
+		events = append(events, event)
+	}
+
+	assert.Equal(t, 2, len(events), "The stream should carry the output and the result")
+	assert.Equal(t, []byte("partial"), events[0].GetOutput().GetData(), "The output should be streamed before the result")
+	assert.Equal(t, "partial", events[1].GetResult().GetOutput(), "The result should be the last event")
+	assert.NotEmpty(t, events[1].GetResult().GetId(), "The server should generate an id when the client sends none")
+}
+
+func TestGRPC_SUCCESS_Cancel(t *testing.T) {
+	started := make(chan struct{})
+	client := newTestGRPCClient(t, func(ctx context.Context, req []byte) interface{} {
+		close(started)
+		<-ctx.Done()
+		return models.TaskResult{ExitCode: -1, Error: "command cancelled"}
+	})
+
+	done := make(chan *taskpb.TaskResult)
+	go func() {
+		result, _ := client.Execute(context.Background(), &taskpb.TaskRequest{Id: "job-1", Command: []string{"sleep", "10"}})
+		done <- result
+	}()
+
+	<-started
+	response, err := client.Cancel(context.Background(), &taskpb.CancelRequest{Id: "job-1"})
+	assert.Nil(t, err, "Cancel should not return error")
+	assert.True(t, response.GetCancelled(), "A running job should be cancelled")
+
+	select {
+	case result := <-done:
+		assert.Equal(t, "command cancelled", result.GetError(), "The cancelled job should return its result")
+	case <-time.After(3 * time.Second):
+		t.Fatal("The cancelled job should've finished")
+	}
+
+	job, err := client.GetJob(context.Background(), &taskpb.GetJobRequest{Id: "job-1"})
+	assert.Nil(t, err, "GetJob should find a cancelled job")
+	assert.Equal(t, taskpb.JobState_JOB_STATE_CANCELLED, job.GetState(), "The job should be cancelled")
+}
+
+func TestGRPC_ERROR_Job_Not_Found(t *testing.T) {
+	client := newTestGRPCClient(t, func(ctx context.Context, req []byte) interface{} {
+		return nil
+	})
+
+	_, err := client.GetJob(context.Background(), &taskpb.GetJobRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "GetJob should return NotFound for unknown jobs")
+
+	_, err = client.Cancel(context.Background(), &taskpb.CancelRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "Cancel should return NotFound for unknown jobs")
+}
+
+func TestGRPC_ERROR_Jobs_Of_Other_Callers(t *testing.T) {
+	started := make(chan struct{})
+	service := newGRPCService(newScheduler(1), newJobRegistry(), "test-server", func(ctx context.Context, req []byte) interface{} {
+		close(started)
+		<-ctx.Done()
+		return models.TaskResult{ExitCode: -1, Error: "command cancelled"}
+	}, zap.NewNop().Sugar())
+
+	owner := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	sameHost := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6000}})
+	other := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}})
+
+	go service.Execute(owner, &taskpb.TaskRequest{Id: "job-1", Command: []string{"sleep", "10"}})
+	<-started
+
+	_, err := service.GetJob(other, &taskpb.GetJobRequest{Id: "job-1"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "GetJob should return NotFound for the jobs of other callers")
+
+	_, err = service.Cancel(other, &taskpb.CancelRequest{Id: "job-1"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "Cancel should return NotFound for the jobs of other callers")
+
+	job, err := service.GetJob(sameHost, &taskpb.GetJobRequest{Id: "job-1"})
+	assert.Nil(t, err, "GetJob should find the jobs submitted from the same host")
+	assert.Equal(t, taskpb.JobState_JOB_STATE_RUNNING, job.GetState(), "The job should still be running")
+
+	response, err := service.Cancel(sameHost, &taskpb.CancelRequest{Id: "job-1"})
+	assert.Nil(t, err, "Cancel should not return error")
+	assert.True(t, response.GetCancelled(), "The jobs submitted from the same host should be cancelled")
+}
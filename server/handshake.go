@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+var (
+	supportedProtocolVersions = []int{models.ProtocolVersion}
+	supportedFraming          = []string{common.FramingNewline, common.FramingLengthPrefixed}
+	supportedCodecs           = []string{common.CodecJSON, common.CodecMsgPack, common.CodecCBOR}
+	supportedCompression      = []string{common.CompressionZstd, common.CompressionGzip, common.CompressionNone}
+	supportedFeatures         = []string{models.FeaturePipelining, models.FeatureStreaming, models.FeatureCancel}
+)
+
+// negotiate agrees on the protocol a client asked for in its hello. An error means the client
+// is incompatible with this server and must be disconnected.
+func negotiate(hello models.Hello, currentFraming string) (models.HelloResult, error) {
+	result := models.HelloResult{
+		Type: models.MessageTypeHello,
+	}
+
+	for _, version := range supportedProtocolVersions {
+		if slices.Contains(hello.Versions, version) && version > result.Version {
+			result.Version = version
+		}
+	}
+
+	if result.Version == 0 {
+		return result, fmt.Errorf("unsupported protocol versions %v, server supports %v", hello.Versions, supportedProtocolVersions)
+	}
+
+	result.Framing = currentFraming
+	if len(hello.Framing) > 0 {
+		framing, ok := firstSupported(hello.Framing, supportedFraming)
+		if !ok {
+			return result, fmt.Errorf("unsupported framing %v, server supports %v", hello.Framing, supportedFraming)
+		}
+		result.Framing = framing
+	}
+
+	result.Codec = common.CodecJSON
+	if len(hello.Codecs) > 0 {
+		codec, ok := firstSupported(hello.Codecs, availableCodecs(result.Framing))
+		if !ok {
+			return result, fmt.Errorf("unsupported codecs %v with %s framing, server supports %v", hello.Codecs, result.Framing, availableCodecs(result.Framing))
+		}
+		result.Codec = codec
+	}
+
+	result.Compression = common.CompressionNone
+	if len(hello.Compression) > 0 {
+		compression, ok := firstSupported(hello.Compression, availableCompression(result.Framing))
+		if !ok {
+			return result, fmt.Errorf("unsupported compression %v with %s framing, server supports %v", hello.Compression, result.Framing, availableCompression(result.Framing))
+		}
+		result.Compression = compression
+	}
+
+	result.Features = []string{}
+	for _, feature := range hello.Features {
+		if slices.Contains(supportedFeatures, feature) {
+			result.Features = append(result.Features, feature)
+		}
+	}
+
+	return result, nil
+}
+
+// availableCodecs returns the codecs that can be used with a framing. Binary codecs can
+// produce newlines, so they require length-prefixed framing.
+func availableCodecs(framing string) []string {
+	if framing == common.FramingLengthPrefixed {
+		return supportedCodecs
+	}
+
+	return []string{common.CodecJSON}
+}
+
+// availableCompression returns the compression that can be used with a framing. Compressed
+// frames are binary, so they require length-prefixed framing.
+func availableCompression(framing string) []string {
+	if framing == common.FramingLengthPrefixed {
+		return supportedCompression
+	}
+
+	return []string{common.CompressionNone}
+}
+
+// firstSupported returns the first of the client preferences that the server supports.
+func firstSupported(preferences []string, supported []string) (string, bool) {
+	for _, preference := range preferences {
+		if slices.Contains(supported, preference) {
+			return preference, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate_SUCCESS(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:        models.MessageTypeHello,
+		Versions:    []int{1, 2},
+		Framing:     []string{"unknown", common.FramingLengthPrefixed},
+		Compression: []string{"none"},
+		Features:    []string{"pipelining", "unknown"},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, models.ProtocolVersion, result.Version, "The highest common version should be selected")
+	assert.Equal(t, common.FramingLengthPrefixed, result.Framing, "The first supported framing should be selected")
+	assert.Equal(t, "none", result.Compression, "The first supported compression should be selected")
+	assert.Equal(t, []string{"pipelining"}, result.Features, "Only features supported by the server should be returned")
+}
+
+func TestNegotiate_SUCCESS_Defaults(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{1},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, common.FramingNewline, result.Framing, "The current framing should be kept when the client has no preference")
+	assert.Equal(t, "none", result.Compression, "Compression should be disabled when the client has no preference")
+	assert.Empty(t, result.Features, "No features should be enabled when the client asks for none")
+}
+
+func TestNegotiate_ERROR_Unsupported_Version(t *testing.T) {
+	_, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{99},
+	}, common.FramingNewline)
+
+	assert.NotNil(t, err, "A client without a common protocol version should be rejected")
+	assert.Contains(t, err.Error(), "unsupported protocol versions", "The error should explain the incompatibility")
+}
+
+func TestNegotiate_ERROR_Unsupported_Framing(t *testing.T) {
+	_, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{1},
+		Framing:  []string{"unknown"},
+	}, common.FramingNewline)
+
+	assert.NotNil(t, err, "A client without a common framing should be rejected")
+}
+
+func TestNegotiate_SUCCESS_Binary_Codec(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{1},
+		Framing:  []string{common.FramingLengthPrefixed},
+		Codecs:   []string{common.CodecCBOR, common.CodecJSON},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, common.CodecCBOR, result.Codec, "The first supported codec should be selected")
+}
+
+func TestNegotiate_SUCCESS_Binary_Codec_Falls_Back_With_Newline_Framing(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{1},
+		Codecs:   []string{common.CodecMsgPack, common.CodecJSON},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, common.CodecJSON, result.Codec, "Binary codecs can't be used with newline framing")
+}
+
+func TestNegotiate_ERROR_Unsupported_Codec(t *testing.T) {
+	_, err := negotiate(models.Hello{
+		Type:     models.MessageTypeHello,
+		Versions: []int{1},
+		Codecs:   []string{common.CodecMsgPack},
+	}, common.FramingNewline)
+
+	assert.NotNil(t, err, "A client without a usable codec should be rejected")
+}
+
+func TestNegotiate_SUCCESS_Compression(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:        models.MessageTypeHello,
+		Versions:    []int{1},
+		Framing:     []string{common.FramingLengthPrefixed},
+		Compression: []string{"brotli", common.CompressionZstd, common.CompressionGzip},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, common.CompressionZstd, result.Compression, "The first supported compression should be selected")
+}
+
+func TestNegotiate_SUCCESS_Compression_Falls_Back_With_Newline_Framing(t *testing.T) {
+	result, err := negotiate(models.Hello{
+		Type:        models.MessageTypeHello,
+		Versions:    []int{1},
+		Compression: []string{common.CompressionGzip, common.CompressionNone},
+	}, common.FramingNewline)
+
+	assert.Nil(t, err, "A compatible hello should not return error")
+	assert.Equal(t, common.CompressionNone, result.Compression, "Compressed frames can't be used with newline framing")
+}
+
+func TestNegotiate_ERROR_Unsupported_Compression(t *testing.T) {
+	_, err := negotiate(models.Hello{
+		Type:        models.MessageTypeHello,
+		Versions:    []int{1},
+		Compression: []string{common.CompressionGzip},
+	}, common.FramingNewline)
+
+	assert.NotNil(t, err, "A client without a usable compression should be rejected")
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.uber.org/zap"
+)
+
+// health answers liveness and readiness probes, like Kubernetes', which can't tell from the
+// TCP port alone whether the server is saturated or shutting down.
+type health struct {
+	common    common.Common
+	scheduler *scheduler
+	// connections is the semaphore limiting the TCP connections served at the same time.
+	connections chan int
+	draining    atomic.Bool
+	logger      *zap.SugaredLogger
+}
+
+func newHealth(scheduler *scheduler, connections chan int, logger *zap.SugaredLogger) *health {
+	return &health{
+		common:      common.NewCommonLib(),
+		scheduler:   scheduler,
+		connections: connections,
+		logger:      logger,
+	}
+}
+
+// routes serves the probes on /healthz and /readyz, and every other route with handler.
+func (h *health) routes(handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", h.handleHealthz)
+	mux.HandleFunc("GET /readyz", h.handleReadyz)
+	mux.Handle("/", handler)
+
+	return mux
+}
+
+// handleHealthz reports the server is alive, including while it drains.
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+	status.Status = models.HealthStatusOK
+
+	h.writeJSON(w, http.StatusOK, status)
+}
+
+// handleReadyz answers 503 while the server drains or has no free slot, so new work goes elsewhere.
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+	if status.Status != models.HealthStatusOK {
+		h.writeJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, status)
+}
+
+func (h *health) status() models.Health {
+	status := models.Health{
+		Status:   models.HealthStatusOK,
+		Draining: h.draining.Load(),
+		Connections: models.Capacity{
+			Active: len(h.connections),
+			Limit:  cap(h.connections),
+		},
+	}
+
+	if h.scheduler != nil {
+		status.Requests.Active, status.Requests.Limit, status.Requests.Waiting = h.scheduler.usage()
+	}
+
+	switch {
+	case status.Draining:
+		status.Status = models.HealthStatusDraining
+	case status.Connections.Active >= status.Connections.Limit:
+		status.Status = models.HealthStatusBusy
+	case h.scheduler != nil && status.Requests.Active >= status.Requests.Limit:
+		status.Status = models.HealthStatusBusy
+	}
+
+	return status
+}
+
+func (h *health) writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := h.common.Marshal(v)
+	if err != nil {
+		h.logger.Errorw("Error on Marshall Response", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
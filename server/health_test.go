@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func probe(t *testing.T, handler http.Handler, path string) (int, models.Health) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var health models.Health
+	err := json.Unmarshal(recorder.Body.Bytes(), &health)
+	assert.Nil(t, err, "The probe should answer with a Health")
+
+	return recorder.Code, health
+}
+
+func TestHealth_SUCCESS_Ready(t *testing.T) {
+	health := newHealth(newScheduler(2), make(chan int, 2), zap.NewNop().Sugar())
+	handler := health.routes(http.NotFoundHandler())
+
+	code, status := probe(t, handler, "/readyz")
+	assert.Equal(t, http.StatusOK, code, "A server with free slots should be ready")
+	assert.Equal(t, models.HealthStatusOK, status.Status, "A server with free slots should report ok")
+	assert.Equal(t, 2, status.Requests.Limit, "The request limit should be reported")
+	assert.Equal(t, 2, status.Connections.Limit, "The connection limit should be reported")
+}
+
+func TestHealth_SUCCESS_Busy(t *testing.T) {
+	scheduler := newScheduler(1)
+	scheduler.acquire(context.Background())
+	health := newHealth(scheduler, make(chan int, 2), zap.NewNop().Sugar())
+	handler := health.routes(http.NotFoundHandler())
+
+	code, status := probe(t, handler, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "A server without free request slots should not be ready")
+	assert.Equal(t, models.HealthStatusBusy, status.Status, "A server without free request slots should report busy")
+	assert.Equal(t, 1, status.Requests.Active, "The requests running should be reported")
+
+	code, _ = probe(t, handler, "/healthz")
+	assert.Equal(t, http.StatusOK, code, "A busy server should still be alive")
+}
+
+func TestHealth_SUCCESS_Connections_Full(t *testing.T) {
+	connections := make(chan int, 1)
+	connections <- 1
+	health := newHealth(newScheduler(1), connections, zap.NewNop().Sugar())
+
+	code, status := probe(t, health.routes(http.NotFoundHandler()), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "A server without free connections should not be ready")
+	assert.Equal(t, 1, status.Connections.Active, "The connections served should be reported")
+}
+
+func TestHealth_SUCCESS_Draining(t *testing.T) {
+	health := newHealth(newScheduler(1), make(chan int, 1), zap.NewNop().Sugar())
+	health.draining.Store(true)
+	handler := health.routes(http.NotFoundHandler())
+
+	code, status := probe(t, handler, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "A draining server should not be ready")
+	assert.Equal(t, models.HealthStatusDraining, status.Status, "A draining server should report draining")
+
+	code, status = probe(t, handler, "/healthz")
+	assert.Equal(t, http.StatusOK, code, "A draining server should still be alive")
+	assert.True(t, status.Draining, "Liveness should report the server is draining")
+}
+
+func TestHealth_SUCCESS_Other_Routes(t *testing.T) {
+	health := newHealth(newScheduler(1), make(chan int, 1), zap.NewNop().Sugar())
+	handler := health.routes(http.NotFoundHandler())
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Other routes should be served by the wrapped handler")
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+const (
+	jobStateQueued    = "queued"
+	jobStateRunning   = "running"
+	jobStateFinished  = "finished"
+	jobStateCancelled = "cancelled"
+
+	// maxFinishedJobs is how many finished jobs are kept so their result can still be queried.
+	maxFinishedJobs = 1000
+)
+
+var (
+	errJobExists   = errors.New("job already exists")
+	errJobNotFound = errors.New("job not found")
+)
+
+type job struct {
+	id        string
+	owner     string
+	command   []string
+	state     string
+	createdAt time.Time
+	result    *models.TaskResult
+
+	cancel    context.CancelFunc
+	cancelled bool
+
+	// finished is the element of the job in the list of finished jobs, once it finished.
+	finished *list.Element
+}
+
+// jobKey identifies a job among the jobs of its owner, the caller that submitted it.
+type jobKey struct {
+	owner string
+	id    string
+}
+
+// jobRegistry tracks the jobs submitted by id so they can be queried and cancelled
+// from other requests of the same owner. Owners can't see each other's jobs, and can
+// use the same ids.
+type jobRegistry struct {
+	mu       sync.Mutex
+	jobs     map[jobKey]*job
+	finished *list.List
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		jobs:     map[jobKey]*job{},
+		finished: list.New(),
+	}
+}
+
+// register adds a queued job. Ids of jobs that are still queued or running can't be reused.
+func (r *jobRegistry) register(owner string, id string, command []string, cancel context.CancelFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := jobKey{owner: owner, id: id}
+	existing, ok := r.jobs[key]
+	if ok && existing.result == nil {
+		return errJobExists
+	}
+
+	// The finished job replaced must not evict the new one later
+	if ok && existing.finished != nil {
+		r.finished.Remove(existing.finished)
+	}
+
+	r.jobs[key] = &job{
+		id:        id,
+		owner:     owner,
+		command:   command,
+		state:     jobStateQueued,
+		createdAt: time.Now(),
+		cancel:    cancel,
+	}
+
+	return nil
+}
+
+func (r *jobRegistry) start(owner string, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[jobKey{owner: owner, id: id}]; ok && !job.cancelled {
+		job.state = jobStateRunning
+	}
+}
+
+// finish records the result of a job, keeping only the most recent finished jobs.
+func (r *jobRegistry) finish(owner string, id string, result models.TaskResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := jobKey{owner: owner, id: id}
+	job, ok := r.jobs[key]
+	if !ok {
+		return
+	}
+
+	job.result = &result
+	job.state = jobStateFinished
+	if job.cancelled {
+		job.state = jobStateCancelled
+	}
+
+	if job.finished != nil {
+		r.finished.Remove(job.finished)
+	}
+	job.finished = r.finished.PushBack(key)
+	for r.finished.Len() > maxFinishedJobs {
+		oldest := r.finished.Remove(r.finished.Front()).(jobKey)
+		if old, ok := r.jobs[oldest]; ok && old.result != nil {
+			delete(r.jobs, oldest)
+		}
+	}
+}
+
+// cancel stops a queued or running job. It returns false when the job had already finished.
+func (r *jobRegistry) cancel(owner string, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[jobKey{owner: owner, id: id}]
+	if !ok {
+		return false, errJobNotFound
+	}
+
+	if job.result != nil {
+		return false, nil
+	}
+
+	job.cancelled = true
+	job.state = jobStateCancelled
+	job.cancel()
+
+	return true, nil
+}
+
+// get returns a copy of a job.
+func (r *jobRegistry) get(owner string, id string) (job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found, ok := r.jobs[jobKey{owner: owner, id: id}]
+	if !ok {
+		return job{}, false
+	}
+
+	return *found, true
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobRegistry_SUCCESS_Lifecycle(t *testing.T) {
+	jobs := newJobRegistry()
+
+	err := jobs.register("client", "job-1", []string{"echo"}, func() {})
+	assert.Nil(t, err, "Registering a new job should not return error")
+
+	job, ok := jobs.get("client", "job-1")
+	assert.True(t, ok, "A registered job should be found")
+	assert.Equal(t, jobStateQueued, job.state, "A registered job should be queued")
+
+	jobs.start("client", "job-1")
+	job, _ = jobs.get("client", "job-1")
+	assert.Equal(t, jobStateRunning, job.state, "A started job should be running")
+
+	jobs.finish("client", "job-1", models.TaskResult{Output: "done"})
+	job, _ = jobs.get("client", "job-1")
+	assert.Equal(t, jobStateFinished, job.state, "A finished job should be finished")
+	assert.Equal(t, "done", job.result.Output, "A finished job should keep its result")
+
+	err = jobs.register("client", "job-1", []string{"echo"}, func() {})
+	assert.Nil(t, err, "The id of a finished job can be reused")
+}
+
+func TestJobRegistry_ERROR_Duplicated_Running_Job(t *testing.T) {
+	jobs := newJobRegistry()
+
+	jobs.register("client", "job-1", []string{"echo"}, func() {})
+	err := jobs.register("client", "job-1", []string{"echo"}, func() {})
+
+	assert.ErrorIs(t, err, errJobExists, "The id of a running job can't be reused")
+}
+
+func TestJobRegistry_SUCCESS_Cancel(t *testing.T) {
+	jobs := newJobRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	jobs.register("client", "job-1", []string{"sleep"}, cancel)
+	jobs.start("client", "job-1")
+
+	cancelled, err := jobs.cancel("client", "job-1")
+	assert.Nil(t, err, "Cancelling a running job should not return error")
+	assert.True(t, cancelled, "A running job should be cancelled")
+	assert.NotNil(t, ctx.Err(), "Cancelling a job should cancel its context")
+
+	jobs.finish("client", "job-1", models.TaskResult{})
+	job, _ := jobs.get("client", "job-1")
+	assert.Equal(t, jobStateCancelled, job.state, "A cancelled job should stay cancelled after it finishes")
+
+	cancelled, err = jobs.cancel("client", "job-1")
+	assert.Nil(t, err, "Cancelling a finished job should not return error")
+	assert.False(t, cancelled, "A finished job can't be cancelled")
+
+	_, err = jobs.cancel("client", "unknown")
+	assert.ErrorIs(t, err, errJobNotFound, "Cancelling an unknown job should return not found")
+}
+
+func TestJobRegistry_SUCCESS_Finished_Jobs_Are_Bounded(t *testing.T) {
+	jobs := newJobRegistry()
+
+	for i := 0; i < maxFinishedJobs+10; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		jobs.register("client", id, nil, func() {})
+		jobs.finish("client", id, models.TaskResult{})
+	}
+
+	assert.Equal(t, maxFinishedJobs, len(jobs.jobs), "Only the most recent finished jobs should be kept")
+}
+
+func TestJobRegistry_SUCCESS_Reused_Id_Is_Kept(t *testing.T) {
+	jobs := newJobRegistry()
+
+	jobs.register("client", "reused", nil, func() {})
+	jobs.finish("client", "reused", models.TaskResult{})
+
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		jobs.register("client", id, nil, func() {})
+		jobs.finish("client", id, models.TaskResult{})
+	}
+
+	jobs.register("client", "reused", nil, func() {})
+	jobs.finish("client", "reused", models.TaskResult{Output: "second run"})
+	assert.Equal(t, 11, jobs.finished.Len(), "A reused id should be listed once among the finished jobs")
+
+	for i := 10; i < maxFinishedJobs; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		jobs.register("client", id, nil, func() {})
+		jobs.finish("client", id, models.TaskResult{})
+	}
+
+	reused, ok := jobs.get("client", "reused")
+	assert.True(t, ok, "A reused id should be kept until enough newer jobs finished")
+	assert.Equal(t, "second run", reused.result.Output, "The result of the last job with the id should be kept")
+}
+
+func TestJobRegistry_SUCCESS_Owners_Are_Isolated(t *testing.T) {
+	jobs := newJobRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	jobs.register("client", "job-1", []string{"sleep"}, cancel)
+
+	_, ok := jobs.get("other", "job-1")
+	assert.False(t, ok, "The jobs of other owners should not be found")
+
+	_, err := jobs.cancel("other", "job-1")
+	assert.ErrorIs(t, err, errJobNotFound, "The jobs of other owners should not be cancelled")
+	assert.Nil(t, ctx.Err(), "The job should keep running")
+
+	err = jobs.register("other", "job-1", []string{"echo"}, func() {})
+	assert.Nil(t, err, "Owners can use the same ids")
+}
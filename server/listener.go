@@ -7,6 +7,7 @@ import (
 
 type Listener interface {
 	Accept() (net.Conn, error)
+	Close() error
 }
 
 type listenerImpl struct {
@@ -27,3 +28,7 @@ func newListener(port int, addr, protocol string) (Listener, error) {
 func (l *listenerImpl) Accept() (net.Conn, error) {
 	return l.listener.Accept()
 }
+
+func (l *listenerImpl) Close() error {
+	return l.listener.Close()
+}
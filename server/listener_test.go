@@ -3,18 +3,27 @@ package server
 import (
 	"fmt"
 	"net"
+	"sync/atomic"
 )
 
 type mockListener struct {
 	shouldReturnErrorOnAccept bool
 	shouldReturnErrorOnWrite  bool
 
-	onAcceptCount int
-	onWriteCount  int
+	onAcceptCalls atomic.Int32
+	onWriteCalls  atomic.Int32
+}
+
+func (m *mockListener) onAcceptCount() int {
+	return int(m.onAcceptCalls.Load())
+}
+
+func (m *mockListener) onWriteCount() int {
+	return int(m.onWriteCalls.Load())
 }
 
 func (m *mockListener) Accept() (net.Conn, error) {
-	m.onAcceptCount++
+	m.onAcceptCalls.Add(1)
 
 	if m.shouldReturnErrorOnAccept {
 		return nil, fmt.Errorf("mock error")
@@ -23,8 +32,12 @@ func (m *mockListener) Accept() (net.Conn, error) {
 	return nil, nil
 }
 
+func (m *mockListener) Close() error {
+	return nil
+}
+
 func (m *mockListener) Write(conn net.Conn, req []byte) error {
-	m.onWriteCount++
+	m.onWriteCalls.Add(1)
 
 	if m.shouldReturnErrorOnWrite {
 		return fmt.Errorf("mock error")
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "sumologic_server"
+
+// Semaphores whose wait time is measured.
+const (
+	semaphoreConnections = "connections"
+	semaphoreRequests    = "requests"
+)
+
+// Reasons why the server closes a connection instead of serving it.
+const (
+	rejectedProtocol        = "protocol"
+	rejectedRequestTooLarge = "request_too_large"
+	rejectedAuth            = "auth"
+)
+
+// Outcomes of a request.
+const (
+	outcomeSuccess   = "success"
+	outcomeFailure   = "failure"
+	outcomeTimeout   = "timeout"
+	outcomeCancelled = "cancelled"
+	outcomeError     = "error"
+)
+
+// Command labels used when the basename of the command can't be used.
+const (
+	commandLabelNone  = "none"
+	commandLabelOther = "other"
+)
+
+// metrics holds the Prometheus collectors of a server. Its methods do nothing on a nil
+// *metrics, so components created without metrics don't have to check for them.
+type metrics struct {
+	registry *prometheus.Registry
+
+	connectionsAccepted prometheus.Counter
+	connectionsActive   prometheus.Gauge
+	connectionsRejected *prometheus.CounterVec
+	semaphoreWait       *prometheus.HistogramVec
+
+	requests        *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+	exitCodes       *prometheus.CounterVec
+	outputBytes     *prometheus.HistogramVec
+	timeouts        *prometheus.CounterVec
+
+	// commands are the basenames used as command label, at most maxCommands of them.
+	// Other commands are labelled as "other" so clients can't create unbounded series.
+	commandsMu  sync.Mutex
+	commands    map[string]struct{}
+	maxCommands int
+}
+
+func newMetrics(maxCommands int) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		connectionsAccepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_accepted_total",
+			Help:      "TCP connections accepted.",
+		}),
+		connectionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_active",
+			Help:      "TCP connections being served.",
+		}),
+		connectionsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections_rejected_total",
+			Help:      "TCP connections closed by the server because of the client, by reason.",
+		}, []string{"reason"}),
+		semaphoreWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "semaphore_wait_seconds",
+			Help:      "Time waited for a free connection or request slot.",
+			Buckets:   []float64{.0001, .001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60},
+		}, []string{"semaphore"}),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Requests executed, by command and outcome.",
+		}, []string{"command", "outcome"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_duration_seconds",
+			Help:      "Time taken to execute a request, by command.",
+			Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+		}, []string{"command"}),
+		exitCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_exit_codes_total",
+			Help:      "Exit codes of the commands, by command.",
+		}, []string{"command", "exit_code"}),
+		outputBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_output_bytes",
+			Help:      "Size of the output sent back to the client, by command.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{"command"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_timeouts_total",
+			Help:      "Commands killed for exceeding their timeout, by command.",
+		}, []string{"command"}),
+
+		commands:    map[string]struct{}{},
+		maxCommands: maxCommands,
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connectionsAccepted,
+		m.connectionsActive,
+		m.connectionsRejected,
+		m.semaphoreWait,
+		m.requests,
+		m.commandDuration,
+		m.exitCodes,
+		m.outputBytes,
+		m.timeouts,
+	)
+
+	return m
+}
+
+func (m *metrics) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	return mux
+}
+
+func (m *metrics) connectionAccepted() {
+	if m == nil {
+		return
+	}
+
+	m.connectionsAccepted.Inc()
+}
+
+func (m *metrics) connectionOpened() {
+	if m == nil {
+		return
+	}
+
+	m.connectionsActive.Inc()
+}
+
+func (m *metrics) connectionClosed() {
+	if m == nil {
+		return
+	}
+
+	m.connectionsActive.Dec()
+}
+
+func (m *metrics) connectionRejected(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.connectionsRejected.WithLabelValues(reason).Inc()
+}
+
+func (m *metrics) observeWait(semaphore string, wait time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.semaphoreWait.WithLabelValues(semaphore).Observe(wait.Seconds())
+}
+
+// instrument wraps a callback to record the outcome of every request it executes.
+func (m *metrics) instrument(callback func(ctx context.Context, req []byte) interface{}) func(ctx context.Context, req []byte) interface{} {
+	if m == nil {
+		return callback
+	}
+
+	return func(ctx context.Context, req []byte) interface{} {
+		startTime := time.Now()
+		result := callback(ctx, req)
+		m.observeResult(result, time.Since(startTime))
+
+		return result
+	}
+}
+
+// observeResult records a request executed by the callback. Results that aren't a TaskResult
+// can't be inspected and are ignored.
+func (m *metrics) observeResult(result interface{}, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	var taskResult models.TaskResult
+	switch value := result.(type) {
+	case models.TaskResult:
+		taskResult = value
+	case *models.TaskResult:
+		if value == nil {
+			return
+		}
+		taskResult = *value
+	default:
+		return
+	}
+
+	command := m.commandLabel(taskResult.Command)
+
+	m.requests.WithLabelValues(command, outcome(taskResult)).Inc()
+	m.commandDuration.WithLabelValues(command).Observe(duration.Seconds())
+	m.exitCodes.WithLabelValues(command, strconv.Itoa(taskResult.ExitCode)).Inc()
+	m.outputBytes.WithLabelValues(command).Observe(float64(len(taskResult.Output)))
+
+	if taskResult.Error == models.ErrorTimeoutExceeded {
+		m.timeouts.WithLabelValues(command).Inc()
+	}
+}
+
+// commandLabel returns the basename of a command, or "other" once maxCommands distinct
+// commands have been seen.
+func (m *metrics) commandLabel(command []string) string {
+	if len(command) == 0 || command[0] == "" {
+		return commandLabelNone
+	}
+
+	name := strings.ToValidUTF8(filepath.Base(command[0]), "?")
+
+	m.commandsMu.Lock()
+	defer m.commandsMu.Unlock()
+
+	if _, ok := m.commands[name]; ok {
+		return name
+	}
+
+	if len(m.commands) >= m.maxCommands {
+		return commandLabelOther
+	}
+
+	m.commands[name] = struct{}{}
+	return name
+}
+
+func outcome(result models.TaskResult) string {
+	switch {
+	case result.Error == models.ErrorTimeoutExceeded:
+		return outcomeTimeout
+	case result.Error == models.ErrorCommandCancelled:
+		return outcomeCancelled
+	case result.ExitCode == exitCodeErrorGeneral:
+		return outcomeError
+	case result.ExitCode != 0:
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
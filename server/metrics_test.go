@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument_SUCCESS_Outcomes(t *testing.T) {
+	metrics := newMetrics(10)
+
+	results := []models.TaskResult{
+		{Command: []string{"/bin/echo", "hello"}, Output: "hello"},
+		{Command: []string{"false"}, ExitCode: 1},
+		{Command: []string{"sleep", "10"}, ExitCode: exitCodeErrorGeneral, Error: models.ErrorTimeoutExceeded},
+		{Command: []string{"sleep", "10"}, ExitCode: exitCodeErrorGeneral, Error: models.ErrorCommandCancelled},
+		{ExitCode: exitCodeErrorGeneral, Error: "Command is mandatory."},
+	}
+
+	for _, result := range results {
+		callback := metrics.instrument(func(ctx context.Context, req []byte) interface{} {
+			return result
+		})
+		assert.Equal(t, result, callback(context.Background(), nil), "The instrumented callback should return the result of the callback")
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("echo", outcomeSuccess)), "Successful commands should be counted by basename")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("false", outcomeFailure)), "Commands with a non-zero exit code should be counted as failures")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("sleep", outcomeTimeout)), "Commands that timed out should be counted as timeouts")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("sleep", outcomeCancelled)), "Cancelled commands should be counted as cancelled")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.requests.WithLabelValues(commandLabelNone, outcomeError)), "Invalid requests should be counted as errors")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.timeouts.WithLabelValues("sleep")), "Timeouts should be counted by command")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.exitCodes.WithLabelValues("false", "1")), "Exit codes should be counted by command")
+	assert.Equal(t, 4, testutil.CollectAndCount(metrics.commandDuration), "The duration should be observed in a series per command")
+}
+
+func TestCommandLabel_SUCCESS_Cardinality_Limit(t *testing.T) {
+	metrics := newMetrics(2)
+
+	assert.Equal(t, "echo", metrics.commandLabel([]string{"/usr/bin/echo"}), "The label should be the basename of the command")
+	assert.Equal(t, "ls", metrics.commandLabel([]string{"ls", "-la"}), "The label should be the basename of the command")
+	assert.Equal(t, commandLabelOther, metrics.commandLabel([]string{"cat"}), "Commands over the limit should be labelled as other")
+	assert.Equal(t, "echo", metrics.commandLabel([]string{"echo"}), "Commands seen before the limit should keep their label")
+	assert.Equal(t, commandLabelNone, metrics.commandLabel(nil), "Requests without a command should be labelled as none")
+}
+
+func TestMetrics_SUCCESS_Nil(t *testing.T) {
+	var metrics *metrics
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{}
+	}
+
+	assert.NotPanics(t, func() {
+		metrics.connectionAccepted()
+		metrics.connectionRejected(rejectedProtocol)
+		metrics.observeWait(semaphoreRequests, 0)
+		metrics.instrument(callback)(context.Background(), nil)
+	}, "A nil metrics should record nothing")
+}
@@ -6,28 +6,53 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
 )
 
 type mockConn struct {
+	// The connection is read and written by its handler while the test inspects it
+	mu          sync.Mutex
 	readBuffer  *bytes.Buffer
 	writeBuffer *bytes.Buffer
 	closed      bool
 }
 
 func (m *mockConn) Read(b []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.readBuffer.Read(b)
 }
 
 func (m *mockConn) Write(b []byte) (n int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.writeBuffer.Write(b)
 }
 
 func (m *mockConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.closed = true
 	return nil
 }
 
+// written returns a copy of everything written to the connection.
+func (m *mockConn) written() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return bytes.Clone(m.writeBuffer.Bytes())
+}
+
+func (m *mockConn) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
 func (m *mockConn) LocalAddr() net.Addr {
 	return nil
 }
@@ -49,26 +74,52 @@ func (m *mockConn) SetWriteDeadline(t time.Time) error {
 }
 
 type mockCommon struct {
-	shouldReturnErrorOnDecode           bool
-	shouldReturnErrorOnMarshal          bool
-	shouldReturnErrorOnWrite            bool
-	shouldReturnErrorOnReadUntilNewLine bool
+	shouldReturnErrorOnDecode    bool
+	shouldReturnErrorOnMarshal   bool
+	shouldReturnErrorOnWrite     bool
+	shouldReturnErrorOnReadFrame bool
+
+	// Connections call the mock from their own goroutines, so the calls are counted atomically
+	onNewDecoderCalls atomic.Int32
+	onDecodeCalls     atomic.Int32
+	onMarshalCalls    atomic.Int32
+	onNewFramerCalls  atomic.Int32
+	onWriteCalls      atomic.Int32
+	onReadFrameCalls  atomic.Int32
+}
 
-	OnNewDecoderCalledCount int
-	OnDecodeCalledCount     int
-	OnMarshalCalledCount    int
-	onWriteCalledCount      int
-	onReadUntilNewline      int
+func (m *mockCommon) OnNewDecoderCalledCount() int {
+	return int(m.onNewDecoderCalls.Load())
+}
+
+func (m *mockCommon) OnDecodeCalledCount() int {
+	return int(m.onDecodeCalls.Load())
+}
+
+func (m *mockCommon) OnMarshalCalledCount() int {
+	return int(m.onMarshalCalls.Load())
+}
+
+func (m *mockCommon) onNewFramerCalledCount() int {
+	return int(m.onNewFramerCalls.Load())
+}
+
+func (m *mockCommon) onWriteCalledCount() int {
+	return int(m.onWriteCalls.Load())
+}
+
+func (m *mockCommon) onReadFrameCalledCount() int {
+	return int(m.onReadFrameCalls.Load())
 }
 
 func (m *mockCommon) NewDecoder(conn net.Conn) *json.Decoder {
-	m.OnNewDecoderCalledCount++
+	m.onNewDecoderCalls.Add(1)
 
 	return json.NewDecoder(conn)
 }
 
 func (m *mockCommon) Decode(decoder *json.Decoder) (interface{}, error) {
-	m.OnDecodeCalledCount++
+	m.onDecodeCalls.Add(1)
 
 	if m.shouldReturnErrorOnDecode {
 		return nil, io.EOF
@@ -80,35 +131,43 @@ func (m *mockCommon) Decode(decoder *json.Decoder) (interface{}, error) {
 	return request, err
 }
 
-func (m *mockCommon) ReadUntilNewline(conn net.Conn) ([]byte, error) {
-	m.onReadUntilNewline++
+func (m *mockCommon) NewFramer(conn net.Conn, maxFrameSize int) (common.Framer, error) {
+	m.onNewFramerCalls.Add(1)
 
-	if m.shouldReturnErrorOnReadUntilNewLine {
-		return nil, io.EOF
+	return &mockFramer{
+		mock:   m,
+		framer: common.NewNewlineFramer(conn, maxFrameSize),
+	}, nil
+}
+
+func (m *mockCommon) SwitchFramer(framer common.Framer, mode string) (common.Framer, error) {
+	current := framer.(*mockFramer)
+
+	switched, err := common.NewCommonLib().SwitchFramer(current.framer, mode)
+	if err != nil {
+		return nil, err
 	}
 
-	var buf bytes.Buffer
-	for {
-		b := make([]byte, 1)
-		n, err := conn.Read(b)
-		if err != nil {
-			return nil, err
-		}
-		if n == 0 {
-			break
-		}
-
-		buf.Write(b)
-		if b[0] == '\n' {
-			break
-		}
+	return &mockFramer{
+		mock:   m,
+		framer: switched,
+	}, nil
+}
+
+func (m *mockCommon) NewCodec(name string) (common.Codec, error) {
+	if name != common.CodecJSON {
+		return common.NewCommonLib().NewCodec(name)
 	}
 
-	return buf.Bytes(), nil
+	return &mockCodec{mock: m}, nil
+}
+
+func (m *mockCommon) NewCompressor(name string) (common.Compressor, error) {
+	return common.NewCommonLib().NewCompressor(name)
 }
 
 func (m *mockCommon) Marshal(v any) ([]byte, error) {
-	m.OnMarshalCalledCount++
+	m.onMarshalCalls.Add(1)
 
 	if m.shouldReturnErrorOnMarshal {
 		return nil, fmt.Errorf("mock error")
@@ -117,16 +176,59 @@ func (m *mockCommon) Marshal(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
 
-func (m *mockCommon) Write(conn net.Conn, req []byte) error {
-	m.onWriteCalledCount++
+func (m *mockCommon) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
 
-	if m.shouldReturnErrorOnWrite {
-		return fmt.Errorf("mock error")
+type mockFramer struct {
+	mock   *mockCommon
+	framer common.Framer
+}
+
+func (m *mockFramer) Mode() string {
+	return m.framer.Mode()
+}
+
+func (m *mockFramer) WaitFrame() error {
+	return m.framer.WaitFrame()
+}
+
+func (m *mockFramer) ReadFrame() ([]byte, error) {
+	m.mock.onReadFrameCalls.Add(1)
+
+	if m.mock.shouldReturnErrorOnReadFrame {
+		return nil, io.EOF
 	}
 
-	if _, err := conn.Write(append(req, '\n')); err != nil {
-		return err
+	return m.framer.ReadFrame()
+}
+
+func (m *mockFramer) WriteFrame(data []byte) error {
+	m.mock.onWriteCalls.Add(1)
+
+	if m.mock.shouldReturnErrorOnWrite {
+		return fmt.Errorf("mock error")
 	}
 
-	return nil
+	return m.framer.WriteFrame(data)
+}
+
+type mockCodec struct {
+	mock *mockCommon
+}
+
+func (m *mockCodec) Name() string {
+	return common.CodecJSON
+}
+
+func (m *mockCodec) Binary() bool {
+	return false
+}
+
+func (m *mockCodec) Marshal(v any) ([]byte, error) {
+	return m.mock.Marshal(v)
+}
+
+func (m *mockCodec) Unmarshal(data []byte, v any) error {
+	return m.mock.Unmarshal(data, v)
 }
@@ -0,0 +1,45 @@
+package models
+
+const (
+	RequestStateQueued  = "queued"
+	RequestStateRunning = "running"
+)
+
+// AdminConnection is a client connection listed by the admin endpoint.
+type AdminConnection struct {
+	// ID is the correlation ID of the connection, as logged in the CID field.
+	ID         string `json:"id"`
+	Transport  string `json:"transport"`
+	RemoteAddr string `json:"remote_addr"`
+	// ConnectedAt is when the connection was accepted, in milliseconds since the Unix epoch.
+	ConnectedAt int64 `json:"connected_at"`
+	// Requests is the number of requests of the connection queued or running.
+	Requests int `json:"requests"`
+}
+
+// AdminRequest is a request queued or running, listed by the admin endpoint.
+type AdminRequest struct {
+	// ID identifies the request on the admin endpoint, to kill it.
+	ID            string   `json:"id"`
+	CorrelationID string   `json:"correlation_id"`
+	RequestID     string   `json:"request_id,omitempty"`
+	ConnectionID  string   `json:"connection_id,omitempty"`
+	Transport     string   `json:"transport"`
+	Command       []string `json:"command"`
+	Task          string   `json:"task,omitempty"`
+	Shell         string   `json:"shell,omitempty"`
+	RemoteAddr    string   `json:"remote_addr,omitempty"`
+	State         string   `json:"state"`
+	// PID is the process of the command, once it started.
+	PID int `json:"pid,omitempty"`
+	// QueuedAt and StartedAt are in milliseconds since the Unix epoch.
+	QueuedAt  int64 `json:"queued_at"`
+	StartedAt int64 `json:"started_at,omitempty"`
+	// ElapsedMs is how long the request has been running, or waiting while it is queued.
+	ElapsedMs float64 `json:"elapsed_ms"`
+}
+
+// AdminError is the body of the admin endpoint errors.
+type AdminError struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,29 @@
+package models
+
+const (
+	// MessageTypeTask is the default type of a message, a TaskRequest.
+	MessageTypeTask   = "task"
+	MessageTypeHello  = "hello"
+	MessageTypeOutput = "output"
+	// MessageTypeCancel stops a pipelined request still in flight on the same connection.
+	MessageTypeCancel = "cancel"
+)
+
+// Envelope holds the fields shared by every message sent by a client,
+// used to route a message before it is fully decoded.
+type Envelope struct {
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+	// Traceparent and Tracestate carry the W3C trace context of the client, the server
+	// traces the message as part of that trace.
+	Traceparent string `json:"traceparent,omitempty"`
+	Tracestate  string `json:"tracestate,omitempty"`
+	// CorrelationID replaces the correlation ID of the server for this message.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Command of a task, listed on the admin endpoint while it runs.
+	Command []string `json:"command,omitempty"`
+	// Task named instead of the command, listed on the admin endpoint while it runs.
+	Task string `json:"task,omitempty"`
+	// Shell script sent instead of the command, listed on the admin endpoint while it runs.
+	Shell string `json:"shell,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+const (
+	HealthStatusOK = "ok"
+	// HealthStatusBusy means every connection or request slot is taken, new work has to wait.
+	HealthStatusBusy = "busy"
+	// HealthStatusDraining means the server is shutting down and only finishes the requests it has.
+	HealthStatusDraining = "draining"
+)
+
+// Health is the answer of the /healthz and /readyz endpoints.
+type Health struct {
+	Status      string   `json:"status"`
+	Draining    bool     `json:"draining"`
+	Connections Capacity `json:"connections"`
+	Requests    Capacity `json:"requests"`
+}
+
+// Capacity reports the slots in use out of the limit, and how many are waiting for one.
+type Capacity struct {
+	Active  int `json:"active"`
+	Limit   int `json:"limit"`
+	Waiting int `json:"waiting"`
+}
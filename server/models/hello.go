@@ -0,0 +1,39 @@
+package models
+
+// ProtocolVersion is the version of the wire protocol spoken by this server.
+// Clients that don't send a Hello are assumed to speak version 1.
+const ProtocolVersion = 1
+
+const (
+	FeaturePipelining = "pipelining"
+	// FeatureStreaming sends the output of a request as "output" messages while it runs.
+	FeatureStreaming = "streaming"
+	FeatureCancel    = "cancel"
+)
+
+// Hello is the optional first message of a connection, used by the client to agree on the
+// protocol with the server. Framing, Codecs and Compression are listed in order of preference.
+type Hello struct {
+	Type        string   `json:"type"`
+	Versions    []int    `json:"versions"`
+	Framing     []string `json:"framing,omitempty"`
+	Codecs      []string `json:"codecs,omitempty"`
+	Compression []string `json:"compression,omitempty"`
+	Features    []string `json:"features,omitempty"`
+	// Token authenticates the client on servers configured with a client token.
+	Token string `json:"token,omitempty"`
+}
+
+// HelloResult is the server answer to a Hello. When Error is set the client is incompatible
+// and the connection is closed.
+type HelloResult struct {
+	Type        string   `json:"type"`
+	Version     int      `json:"version,omitempty"`
+	Framing     string   `json:"framing,omitempty"`
+	Codec       string   `json:"codec,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Features    []string `json:"features,omitempty"`
+	// MaxPipelined is the number of pipelined requests the server reads ahead on the connection.
+	MaxPipelined int    `json:"max_pipelined,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
@@ -0,0 +1,16 @@
+package models
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputChunk carries part of the output of a command while it is still running.
+type OutputChunk struct {
+	Type   string `json:"type,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+	// Encoding is set to base64 when Data is base64 encoded.
+	Encoding string `json:"encoding,omitempty"`
+}
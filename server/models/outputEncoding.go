@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+const (
+	OutputEncodingUTF8   = "utf8"
+	OutputEncodingBase64 = "base64"
+)
+
+// ValidOutputEncoding reports whether encoding can be requested by a client. Empty means utf8.
+func ValidOutputEncoding(encoding string) bool {
+	return encoding == "" || encoding == OutputEncodingUTF8 || encoding == OutputEncodingBase64
+}
+
+// EncodeOutput renders command output with the requested encoding and returns the encoding
+// that was used. Output that isn't valid UTF-8 is always encoded as base64, so binary output
+// survives the trip to the client.
+func EncodeOutput(output []byte, encoding string) (string, string) {
+	if encoding == OutputEncodingBase64 || !utf8.Valid(output) {
+		return base64.StdEncoding.EncodeToString(output), OutputEncodingBase64
+	}
+
+	return string(output), OutputEncodingUTF8
+}
@@ -1,6 +1,39 @@
 package models
 
+import "regexp"
+
+// correlationIDPattern limits the correlation IDs chosen by clients to what is safe to log.
+var correlationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
 type TaskRequest struct {
+	// ID is chosen by the client to match pipelined requests with their results.
+	// Requests without an ID are answered in order.
+	ID      string   `json:"id,omitempty"`
 	Command []string `json:"command"`
 	Timeout int      `json:"timeout"`
+	// OutputEncoding is utf8 (default) or base64. Output that isn't valid UTF-8 is sent as base64 anyway.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// Env adds variables to the environment the command inherits from the server.
+	Env map[string]string `json:"env,omitempty"`
+	// Cwd is the working directory of the command, the server's when empty.
+	Cwd string `json:"cwd,omitempty"`
+	// Traceparent and Tracestate are the W3C trace context of the client, the spans of the
+	// request are added to that trace.
+	Traceparent string `json:"traceparent,omitempty"`
+	Tracestate  string `json:"tracestate,omitempty"`
+	// CorrelationID is used instead of the correlation ID generated by the server, to find the
+	// request in the server logs. It can have up to 128 letters, digits, '.', '_', ':' or '-'.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Task runs a task registered on the server by name instead of Command, with the values of
+	// its parameters in Params.
+	Task   string                 `json:"task,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	// Shell is a script run by the interpreter of the server instead of Command. The server
+	// policy must allow the client to use it.
+	Shell string `json:"shell,omitempty"`
+}
+
+// ValidCorrelationID reports whether a correlation ID chosen by a client can be used.
+func ValidCorrelationID(id string) bool {
+	return correlationIDPattern.MatchString(id)
 }
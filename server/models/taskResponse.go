@@ -1,10 +1,33 @@
 package models
 
 type TaskResult struct {
+	ID         string   `json:"id,omitempty"`
 	Command    []string `json:"command"`
 	ExecutedAt int64    `json:"executed_at"`
 	DurationMs float64  `json:"duration_ms"`
 	ExitCode   int      `json:"exit_code"`
 	Output     string   `json:"output"`
 	Error      string   `json:"error"`
+	// Task is the name of the task run, when the request named one.
+	Task string `json:"task,omitempty"`
+	// OutputEncoding is set to base64 when Output is base64 encoded.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// ErrorEncoding is set to base64 when Error carries the stderr of the command base64 encoded.
+	ErrorEncoding string `json:"error_encoding,omitempty"`
+
+	// CorrelationID identifies the request in the server logs, ServerID the server that executed it.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	ServerID      string `json:"server_id,omitempty"`
+	// QueueWaitMs is how long the request waited for a free slot before it started.
+	QueueWaitMs float64 `json:"queue_wait_ms,omitempty"`
+	// StartedAtNs and FinishedAtNs are when the server started and finished executing the request,
+	// in nanoseconds since the Unix epoch.
+	StartedAtNs  int64 `json:"started_at_ns,omitempty"`
+	FinishedAtNs int64 `json:"finished_at_ns,omitempty"`
 }
+
+// Errors set in TaskResult.Error when the server stops a command.
+const (
+	ErrorTimeoutExceeded  = "timeout exceeded"
+	ErrorCommandCancelled = "command cancelled"
+)
@@ -2,25 +2,61 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const exitCodeErrorGeneral = -1
+
 type Network interface {
 	HandleConnection(ctx context.Context, conn net.Conn, callback func(ctx context.Context, req []byte) interface{})
 }
 
 type networkImpl struct {
-	common common.Common
+	common    common.Common
+	config    networkConfig
+	configMu  sync.RWMutex
+	scheduler *scheduler
+	metrics   *metrics
+	activity  *activity
+	redactor  *redactor
+	// auth rejects the connections without the client token in their hello, when one is configured.
+	auth *clientAuth
+}
+
+type networkConfig struct {
+	serverID string
+
+	maxRequestSize       int
+	maxPipelinedRequests int
+	compressionThreshold int
+
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	drainTimeout time.Duration
 }
 
-func newNetwork() Network {
+func newNetwork(config networkConfig, scheduler *scheduler, metrics *metrics, activity *activity, redactor *redactor, auth *clientAuth) Network {
 	return &networkImpl{
-		common: common.NewCommonLib(),
+		common:    common.NewCommonLib(),
+		config:    config,
+		scheduler: scheduler,
+		metrics:   metrics,
+		activity:  activity,
+		redactor:  redactor,
+		auth:      auth,
 	}
 }
 
@@ -31,44 +67,394 @@ func (network *networkImpl) HandleConnection(ctx context.Context, conn net.Conn,
 	}
 
 	defer conn.Close()
+
+	// Limits changed while the connection is open apply to the next connections
+	config := network.currentConfig()
+
+	// Wake up a connection waiting for requests when the server stops, so it closes once its requests finish
+	stopWaking := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stopWaking()
+
 	correlationID := uuid.New().String()
+	connLogger := logger
 	logger = logger.With(zap.String("CID", correlationID))
 	ctxHandleConn, cancelCtxHandleConn := context.WithCancel(context.WithValue(context.Background(), "logger", logger))
 
 	defer cancelCtxHandleConn()
 
+	// The accept span started by the server ends once the connection is ready for requests,
+	// the requests of the connection link to it
+	acceptSpan := trace.SpanFromContext(ctx)
+	acceptSpan.SetAttributes(attributeCorrelationID.String(correlationID))
+	var remoteAddr string
+	if peer := conn.RemoteAddr(); peer != nil {
+		remoteAddr = peer.String()
+		acceptSpan.SetAttributes(attributePeerAddress.String(remoteAddr))
+	}
+	acceptLink := trace.Link{SpanContext: acceptSpan.SpanContext()}
+
+	// Operators can kill the connection from the admin endpoint, aborting its requests
+	network.activity.connect(correlationID, transportTCP, remoteAddr, func() {
+		logger.Infow("Closing connection", "Reason", "killed by an operator")
+		cancelCtxHandleConn()
+		conn.Close()
+	})
+	defer network.activity.disconnect(correlationID)
+
+	conn.SetReadDeadline(deadline(config.idleTimeout))
+	framer, err := network.common.NewFramer(conn, config.maxFrameSize())
+	if err != nil {
+		recordError(acceptSpan, err)
+		acceptSpan.End()
+
+		if isTimeout(err) {
+			logger.Infow("Closing connection", "Reason", "idle timeout exceeded", "IdleTimeout", config.idleTimeout)
+		} else if err != io.EOF {
+			logger.Errorw("Error negotiating framing", "Error", err)
+		}
+		return
+	}
+	acceptSpan.End()
+
+	logger.Debugw("Connection framing selected", "Framing", framer.Mode())
+
+	connection := newConnection(conn, framer, network.common, config, logger)
+	connection.redactor = network.redactor
+
+	// Let pipelined requests finish before the connection is closed
+	defer connection.inFlight.Wait()
+
 	for {
-		select {
-		case <-ctx.Done():
+		// Wait for the next request under the idle timeout, then give the client
+		// the read timeout to send the rest of it. The server stopping is checked once the
+		// deadline is armed, so the wake up set when it stops can't be overwritten.
+		conn.SetReadDeadline(deadline(config.idleTimeout))
+		if ctx.Err() != nil {
+			// Stop reading, the requests in flight finish within the drain timeout
+			logger.Infow("Closing connection", "Reason", "server stopping")
+			connection.drain(cancelCtxHandleConn)
 			return
-		default:
-			request, err := network.common.ReadUntilNewline(conn)
-			if err != nil {
-				if err == io.EOF {
-					cancelCtxHandleConn()
-					return
-				}
+		}
+
+		if err := connection.framer.WaitFrame(); err != nil {
+			// Woken up by the server stopping, or idle while pipelined requests run
+			if isTimeout(err) && (ctx.Err() != nil || connection.busy()) {
+				continue
+			}
 
-				logger.Errorw("Error decoding request", "Error", err)
+			if isTimeout(err) {
+				logger.Infow("Closing connection", "Reason", "idle timeout exceeded", "IdleTimeout", config.idleTimeout)
+			} else if err != io.EOF {
+				logger.Errorw("Error waiting for request", "Error", err)
+				cancelCtxHandleConn()
+			}
+			return
+		}
+
+		conn.SetReadDeadline(deadline(config.readTimeout))
+		receivedAt := time.Now()
+		request, err := connection.framer.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
 				return
 			}
 
-			logger.Infow("Received Request", "Request", string(request))
+			cancelCtxHandleConn()
 
-			result := callback(ctxHandleConn, request)
+			if isTimeout(err) {
+				logger.Infow("Closing connection", "Reason", "read timeout exceeded", "ReadTimeout", config.readTimeout)
+				return
+			}
+
+			if errors.Is(err, common.ErrFrameTooLarge) {
+				logger.Warnw("Closing connection, request too large", "Error", err)
+				network.metrics.connectionRejected(rejectedRequestTooLarge)
+				connection.writeResult(models.TaskResult{
+					ExitCode: exitCodeErrorGeneral,
+					Error:    fmt.Sprintf("request too large: limit is %d bytes", config.maxFrameSize()),
+				})
+				return
+			}
+
+			logger.Errorw("Error decoding request", "Error", err)
+			return
+		}
+
+		conn.SetReadDeadline(time.Time{})
+		logger.Infow("Received Request", "Request", network.redactor.payload(connection.codec, request))
+
+		firstMessage := connection.messages == 0
+		connection.messages++
+
+		var envelope models.Envelope
+		if err := connection.codec.Unmarshal(request, &envelope); err != nil {
+			envelope = models.Envelope{}
+		}
+		decodedAt := time.Now()
+
+		if envelope.Type == models.MessageTypeHello {
+			if err := network.handshake(connection, request, firstMessage); err != nil {
+				return
+			}
+			continue
+		}
+
+		// Servers with a client token only accept messages once the hello presented it
+		if network.auth.required() && !connection.authenticated {
+			logger.Warnw("Rejecting unauthenticated client")
+			network.metrics.connectionRejected(rejectedAuth)
+			connection.writeResult(models.TaskResult{
+				ID:       envelope.ID,
+				ExitCode: exitCodeErrorGeneral,
+				Error:    "authentication required: send the client token in a hello",
+			})
+			return
+		}
+
+		if envelope.Type == models.MessageTypeCancel {
+			if !connection.cancel(envelope.ID) {
+				logger.Infow("Cancel for a request not in flight", "RequestID", envelope.ID)
+			}
+			continue
+		}
+
+		// Requests use the correlation ID of the connection unless the client chose one
+		requestCID := requestCorrelationID(envelope.CorrelationID, correlationID)
+		requestLogger := logger
+		if requestCID != correlationID {
+			requestLogger = connLogger.With(zap.String("CID", requestCID))
+		}
+		info := newRequestInfo(requestCID, config.serverID).describe(transportTCP, correlationID, remoteAddr, envelope)
+
+		// Requests are traced from the moment they were received, as part of the trace of the client
+		spanCtx, span := startRequestSpan(ctxHandleConn, transportTCP, envelope,
+			trace.WithTimestamp(receivedAt),
+			trace.WithLinks(acceptLink),
+			trace.WithAttributes(attributeCorrelationID.String(requestCID)))
+		_, decodeSpan := tracer().Start(spanCtx, spanDecode, trace.WithTimestamp(receivedAt))
+		decodeSpan.End(trace.WithTimestamp(decodedAt))
+
+		// Requests without an ID are answered in order, pipelined ones run concurrently
+		if envelope.ID == "" {
+			err := network.execute(context.WithValue(spanCtx, "logger", requestLogger), connection, request, info, callback)
+			recordError(span, err)
+			span.End()
 
-			responseData, err := network.common.Marshal(result)
 			if err != nil {
-				logger.Errorw("Error on Marshall Response", "Error", err)
 				return
 			}
+			continue
+		}
 
-			logger.Infow("Return Result", "Result", string(responseData))
+		requestCtx, cancelRequest := context.WithCancel(context.WithValue(spanCtx, "logger", requestLogger.With(zap.String("RequestID", envelope.ID))))
+		if !connection.track(envelope.ID, cancelRequest) {
+			cancelRequest()
+			span.SetStatus(codes.Error, "request already in flight")
+			span.End()
 
-			if err := network.common.Write(conn, append(responseData, '\n')); err != nil {
-				logger.Errorw("Error on Sending Response", "Error", err)
+			err := connection.writeResult(info.annotate(models.TaskResult{
+				ID:       envelope.ID,
+				ExitCode: exitCodeErrorGeneral,
+				Error:    fmt.Sprintf("request %q is already in flight", envelope.ID),
+			}))
+			if err != nil {
 				return
 			}
+			continue
+		}
+
+		connection.pipeline <- struct{}{}
+		connection.inFlight.Add(1)
+		go func(requestID string) {
+			defer connection.inFlight.Done()
+			defer func() { <-connection.pipeline }()
+			defer connection.untrack(requestID)
+			defer cancelRequest()
+
+			err := network.execute(requestCtx, connection, request, info, callback)
+			if err != nil && ctxHandleConn.Err() == nil && requestCtx.Err() != nil {
+				// Cancelled by the client while it was waiting for the scheduler
+				err = connection.writeResult(info.annotate(cancelledBeforeStart(requestID)))
+			}
+
+			recordError(span, err)
+			span.End()
+
+			if err != nil {
+				// The response stream is broken, stop reading and abort the other requests
+				cancelCtxHandleConn()
+				conn.Close()
+			}
+		}(envelope.ID)
+	}
+}
+
+// handshake answers a hello message and switches the connection to the negotiated protocol.
+// An error means the connection must be closed.
+func (network *networkImpl) handshake(connection *connection, request []byte, firstMessage bool) error {
+	if !firstMessage {
+		return connection.writeResult(models.HelloResult{
+			Type:  models.MessageTypeHello,
+			Error: "hello must be the first message of a connection",
+		})
+	}
+
+	var hello models.Hello
+	if err := connection.codec.Unmarshal(request, &hello); err != nil {
+		connection.writeResult(models.HelloResult{
+			Type:  models.MessageTypeHello,
+			Error: fmt.Sprintf("Invalid hello: %v", err),
+		})
+		return err
+	}
+
+	result, err := negotiate(hello, connection.framer.Mode())
+	if err != nil {
+		connection.logger.Warnw("Rejecting incompatible client", "Error", err)
+		network.metrics.connectionRejected(rejectedProtocol)
+		result.Error = err.Error()
+		connection.writeResult(result)
+		return err
+	}
+	if !network.auth.valid(hello.Token) {
+		connection.logger.Warnw("Rejecting client with an invalid token")
+		network.metrics.connectionRejected(rejectedAuth)
+		connection.writeResult(models.HelloResult{
+			Type:  models.MessageTypeHello,
+			Error: errInvalidClientToken,
+		})
+		return errors.New(errInvalidClientToken)
+	}
+	connection.authenticated = true
+
+	// Clients keep their pipelined requests under the limit, the server stops reading past it
+	result.MaxPipelined = cap(connection.pipeline)
+
+	// The answer is sent with the current framing, the negotiated one is used from the next message on
+	if err := connection.writeResult(result); err != nil {
+		return err
+	}
+
+	if result.Framing != connection.framer.Mode() {
+		framer, err := network.common.SwitchFramer(connection.framer, result.Framing)
+		if err != nil {
+			connection.logger.Errorw("Error switching framing", "Error", err)
+			return err
+		}
+		connection.framer = framer
+	}
+
+	codec, err := network.common.NewCodec(result.Codec)
+	if err != nil {
+		connection.logger.Errorw("Error creating codec", "Error", err)
+		return err
+	}
+	connection.codec = codec
+
+	if result.Compression != common.CompressionNone {
+		compressor, err := network.common.NewCompressor(result.Compression)
+		if err != nil {
+			connection.logger.Errorw("Error creating compressor", "Error", err)
+			return err
+		}
+		connection.framer = common.NewCompressedFramer(connection.framer, compressor, connection.config.compressionThreshold, connection.config.maxFrameSize())
+	}
+
+	connection.protocol = result
+	connection.logger.Infow("Protocol negotiated", "Version", result.Version, "Framing", result.Framing, "Codec", result.Codec, "Compression", result.Compression, "Features", result.Features)
+
+	return nil
+}
+
+// execute runs the callback for a single request once the scheduler has a free slot,
+// then writes its result back to the client, along with the correlation ID and timing of the request.
+func (network *networkImpl) execute(ctx context.Context, connection *connection, request []byte, info *requestInfo, callback func(ctx context.Context, req []byte) interface{}) error {
+	ctx = network.activity.queue(ctx, info)
+	if network.scheduler != nil {
+		if err := traceAcquire(ctx, network.scheduler); err != nil {
+			network.activity.finish(info)
+
+			// Requests killed by an operator are answered, the connection goes on
+			if info.killed.Load() {
+				return connection.writeResult(info.annotate(cancelledBeforeStart(info.requestID)))
+			}
+			return err
 		}
 	}
+
+	ctx = WithCodec(ctx, connection.codec)
+	if connection.streaming() {
+		ctx = WithOutputWriter(ctx, func(chunk models.OutputChunk) {
+			connection.writeMessage(chunk)
+		})
+	}
+
+	network.activity.start(info)
+	result := traceExecution(ctx, callback, request)
+	network.activity.finish(info)
+
+	if network.scheduler != nil {
+		network.scheduler.release()
+	}
+
+	_, span := tracer().Start(ctx, spanWrite)
+	defer span.End()
+
+	err := connection.writeResult(info.annotate(result))
+	recordError(span, err)
+
+	return err
+}
+
+func (network *networkImpl) currentConfig() networkConfig {
+	network.configMu.RLock()
+	defer network.configMu.RUnlock()
+
+	return network.config
+}
+
+// setConfig replaces the configuration of the next connections.
+func (network *networkImpl) setConfig(config networkConfig) {
+	network.configMu.Lock()
+	defer network.configMu.Unlock()
+
+	network.config = config
+}
+
+func (config networkConfig) maxFrameSize() int {
+	if config.maxRequestSize <= 0 {
+		return common.DefaultMaxFrameSize
+	}
+
+	return config.maxRequestSize
+}
+
+// logPayload renders a request for the logs, binary codecs are decoded so the logs stay readable.
+func logPayload(codec common.Codec, request []byte) interface{} {
+	if !codec.Binary() {
+		return string(request)
+	}
+
+	var payload interface{}
+	if err := codec.Unmarshal(request, &payload); err != nil {
+		return request
+	}
+
+	return payload
+}
+
+// deadline converts a timeout into a connection deadline. Timeouts <= 0 disable the deadline.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
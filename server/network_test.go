@@ -1,21 +1,33 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
 	"github.com/stretchr/testify/assert"
 )
 
 type mockNetwork struct {
-	onHandleConnectionCount int
+	onHandleConnectionCalls atomic.Int32
 }
 
 func (m *mockNetwork) HandleConnection(ctx context.Context, conn net.Conn, callback func(ctx context.Context, req []byte) interface{}) {
-	m.onHandleConnectionCount++
+	m.onHandleConnectionCalls.Add(1)
+}
+
+func (m *mockNetwork) onHandleConnectionCount() int {
+	return int(m.onHandleConnectionCalls.Load())
 }
 
 func TestHandleConnection_SUCCESS(t *testing.T) {
@@ -31,9 +43,9 @@ func TestHandleConnection_SUCCESS(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return "result-mock"
 	}
 
@@ -43,11 +55,11 @@ func TestHandleConnection_SUCCESS(t *testing.T) {
 	time.Sleep(2 * time.Second)
 	cancel()
 
-	assert.GreaterOrEqual(t, mockLib.onReadUntilNewline, 1, "Expected OneadUntilNewLine function to be called at least one time")
-	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount, 1, "Expected Marshal function to be called at least one time")
-	assert.Equal(t, callbackWasCalled, true, "Expected callback to be called at least 1 time")
-	assert.NotEmpty(t, conn.writeBuffer.String(), "The connection result should be empty")
-	assert.Contains(t, conn.writeBuffer.String(), "result-mock", "The connection result should be the same as the callback result")
+	assert.GreaterOrEqual(t, mockLib.onReadFrameCalledCount(), 1, "Expected ReadFrame function to be called at least one time")
+	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount(), 1, "Expected Marshal function to be called at least one time")
+	assert.Equal(t, callbackWasCalled.Load(), true, "Expected callback to be called at least 1 time")
+	assert.NotEmpty(t, string(conn.written()), "The connection result should be empty")
+	assert.Contains(t, string(conn.written()), "result-mock", "The connection result should be the same as the callback result")
 }
 
 func TestHandleConnection_ERROR_Context_Closed(t *testing.T) {
@@ -62,9 +74,9 @@ func TestHandleConnection_ERROR_Context_Closed(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -74,7 +86,7 @@ func TestHandleConnection_ERROR_Context_Closed(t *testing.T) {
 	go newNetwork.HandleConnection(ctx, conn, callback)
 
 	time.Sleep(1 * time.Second)
-	assert.Equal(t, callbackWasCalled, false, "Expected callback to be called 0 times")
+	assert.Equal(t, callbackWasCalled.Load(), false, "Expected callback to be called 0 times")
 }
 
 func TestHandleConnection_ERROR_Invalid_Json(t *testing.T) {
@@ -89,9 +101,9 @@ func TestHandleConnection_ERROR_Invalid_Json(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -101,15 +113,15 @@ func TestHandleConnection_ERROR_Invalid_Json(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	defer cancel()
 
-	assert.GreaterOrEqual(t, mockLib.onReadUntilNewline, 1, "Expected OneadUntilNewLine function to be called at least one time")
-	assert.Equal(t, true, callbackWasCalled, "Expected callback to be called 0 times")
-	assert.NotEmpty(t, conn.writeBuffer.String(), "The connection result should be empty")
+	assert.GreaterOrEqual(t, mockLib.onReadFrameCalledCount(), 1, "Expected ReadFrame function to be called at least one time")
+	assert.Equal(t, true, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+	assert.NotEmpty(t, string(conn.written()), "The connection result should be empty")
 }
 
 func TestHandleConnection_ERROR_Lost_Connection_IOF(t *testing.T) {
 	t.Parallel()
 	mockLib := &mockCommon{
-		shouldReturnErrorOnReadUntilNewLine: true,
+		shouldReturnErrorOnReadFrame: true,
 	}
 
 	newNetwork := &networkImpl{
@@ -121,9 +133,9 @@ func TestHandleConnection_ERROR_Lost_Connection_IOF(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -133,8 +145,8 @@ func TestHandleConnection_ERROR_Lost_Connection_IOF(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	defer cancel()
 
-	assert.GreaterOrEqual(t, mockLib.onReadUntilNewline, 1, "Expected OneadUntilNewLine function to be called at least one time")
-	assert.Equal(t, callbackWasCalled, false, "Expected callback to be called 0 times")
+	assert.GreaterOrEqual(t, mockLib.onReadFrameCalledCount(), 1, "Expected ReadFrame function to be called at least one time")
+	assert.Equal(t, callbackWasCalled.Load(), false, "Expected callback to be called 0 times")
 }
 
 func TestHandleConnection_ERROR_Marshal(t *testing.T) {
@@ -152,9 +164,9 @@ func TestHandleConnection_ERROR_Marshal(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -164,10 +176,10 @@ func TestHandleConnection_ERROR_Marshal(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	defer cancel()
 
-	assert.GreaterOrEqual(t, mockLib.onReadUntilNewline, 1, "Expected OneadUntilNewLine function to be called at least one time")
-	assert.Equal(t, true, callbackWasCalled, "Expected callback to be called 0 times")
-	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount, 1, "Expected Marshal function to be called at least one time")
-	assert.Equal(t, "", conn.writeBuffer.String(), "The connection result should be empty")
+	assert.GreaterOrEqual(t, mockLib.onReadFrameCalledCount(), 1, "Expected ReadFrame function to be called at least one time")
+	assert.Equal(t, true, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount(), 1, "Expected Marshal function to be called at least one time")
+	assert.Equal(t, "", string(conn.written()), "The connection result should be empty")
 }
 
 func TestHandleConnection_ERROR_WriteConn(t *testing.T) {
@@ -185,9 +197,452 @@ func TestHandleConnection_ERROR_WriteConn(t *testing.T) {
 		writeBuffer: &bytes.Buffer{},
 	}
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	defer cancel()
+
+	assert.GreaterOrEqual(t, mockLib.onReadFrameCalledCount(), 1, "Expected ReadFrame function to be called at least one time")
+	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount(), 1, "Expected Marshal function to be called at least one time")
+	assert.Equal(t, callbackWasCalled.Load(), true, "Expected callback to be called at least 1 time")
+	assert.Equal(t, string(conn.written()), "", "The connection result should be empty")
+}
+
+func TestHandleConnection_SUCCESS_Length_Prefixed_Framing(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	request := []byte("{\"command\":[\"echo\",\"line1\\nline2\"]}")
+	readBuffer := bytes.NewBuffer(common.LengthPrefixedPreamble)
+	binary.Write(readBuffer, binary.BigEndian, uint32(len(request)))
+	readBuffer.Write(request)
+
+	conn := &mockConn{
+		readBuffer:  readBuffer,
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var receivedRequest []byte
+	callback := func(ctx context.Context, req []byte) interface{} {
+		receivedRequest = req
+		return "result-mock"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	cancel()
+
+	assert.Equal(t, request, receivedRequest, "The callback should receive the frame without the length prefix")
+
+	response := conn.written()
+	assert.GreaterOrEqual(t, len(response), 4, "The response should carry a length prefix")
+	size := binary.BigEndian.Uint32(response[:4])
+	assert.Equal(t, int(size), len(response)-4, "The length prefix should match the response size")
+	assert.Equal(t, "\"result-mock\"", string(response[4:]), "The response frame should be the marshalled callback result")
+}
+
+func TestHandleConnection_ERROR_Invalid_Framing_Preamble(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	conn := &mockConn{
+		readBuffer:  bytes.NewBuffer([]byte{0x00, 'X', 'Y', 'Z', '{', '}', '\n'}),
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	defer cancel()
+
+	assert.Equal(t, false, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+	assert.Equal(t, true, conn.isClosed(), "The connection should be closed after an invalid preamble")
+}
+
+func TestHandleConnection_ERROR_Request_Too_Large(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxRequestSize: 16,
+		},
+	}
+
+	conn := &mockConn{
+		readBuffer:  bytes.NewBufferString(strings.Repeat("a", 10000)),
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	defer cancel()
+
+	var result models.TaskResult
+	err := json.Unmarshal(conn.written(), &result)
+	assert.Nil(t, err, "The connection should receive a structured error")
+	assert.Equal(t, false, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+	assert.Equal(t, -1, result.ExitCode, "The error result should have the general error exit code")
+	assert.Contains(t, result.Error, "request too large", "The error result should explain the request was too large")
+	assert.Equal(t, true, conn.isClosed(), "The connection should be closed after a request too large")
+}
+
+func TestHandleConnection_ERROR_Length_Prefixed_Frame_Too_Large(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxRequestSize: 16,
+		},
+	}
+
+	readBuffer := bytes.NewBuffer(common.LengthPrefixedPreamble)
+	binary.Write(readBuffer, binary.BigEndian, uint32(1<<30))
+
+	conn := &mockConn{
+		readBuffer:  readBuffer,
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	defer cancel()
+
+	assert.Equal(t, false, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+	assert.Contains(t, string(conn.written()), "request too large", "The connection should receive a structured error")
+	assert.Equal(t, true, conn.isClosed(), "The connection should be closed after a frame too large")
+}
+
+func TestHandleConnection_ERROR_Idle_Timeout(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			idleTimeout: 200 * time.Millisecond,
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return nil
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		newNetwork.HandleConnection(context.Background(), serverConn, callback)
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("An idle connection should've been closed after the idle timeout")
+	}
+}
+
+func TestHandleConnection_ERROR_Read_Timeout(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			idleTimeout: 5 * time.Second,
+			readTimeout: 200 * time.Millisecond,
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return nil
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		newNetwork.HandleConnection(context.Background(), serverConn, callback)
+		close(handled)
+	}()
+
+	// Start a request and never finish it
+	_, err := clientConn.Write([]byte(`{"command":`))
+	assert.Nil(t, err, "writing a partial request should not return error")
+
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("A connection with a partial request should've been closed after the read timeout")
+	}
+
+	assert.Equal(t, false, callbackWasCalled.Load(), "Expected callback to be called 0 times")
+}
+
+func TestHandleConnection_SUCCESS_Pipelined_Requests(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxPipelinedRequests: 4,
+		},
+		scheduler: newScheduler(4),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+
+		if request.ID == "slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		return models.TaskResult{ID: request.ID}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	go func() {
+		clientConn.Write([]byte("{\"id\":\"slow\",\"command\":[\"a\"]}\n"))
+		clientConn.Write([]byte("{\"id\":\"fast\",\"command\":[\"b\"]}\n"))
+	}()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	scanner := bufio.NewScanner(clientConn)
+
+	var ids []string
+	for len(ids) < 2 && scanner.Scan() {
+		var result models.TaskResult
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		assert.Nil(t, err, "Every response should be a valid result")
+		ids = append(ids, result.ID)
+	}
+
+	assert.Equal(t, []string{"fast", "slow"}, ids, "Pipelined responses should be tagged with their ID and returned as they finish")
+}
+
+func TestHandleConnection_SUCCESS_Drain_Pipelined_Requests(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxPipelinedRequests: 4,
+			idleTimeout:          time.Minute,
+			drainTimeout:         5 * time.Second,
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	started := make(chan struct{})
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+		close(started)
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+			return models.TaskResult{ID: request.ID, Output: "done"}
+		case <-ctx.Done():
+			return models.TaskResult{ID: request.ID, ExitCode: -1, Error: "command cancelled"}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{})
+	go func() {
+		newNetwork.HandleConnection(ctx, serverConn, callback)
+		close(handled)
+	}()
+
+	go clientConn.Write([]byte("{\"id\":\"slow\",\"command\":[\"sleep\"]}\n"))
+	<-started
+	cancel()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var result models.TaskResult
+	err := json.NewDecoder(clientConn).Decode(&result)
+	assert.Nil(t, err, "The request in flight should be answered")
+	assert.Equal(t, "slow", result.ID, "The result should be tagged with the request ID")
+	assert.Equal(t, "done", result.Output, "Requests in flight should finish when the server stops")
+	assert.Empty(t, result.Error, "Requests in flight should not be aborted when the server stops")
+
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The connection should've been closed once its requests finished")
+	}
+}
+
+func TestHandleConnection_ERROR_Drain_Timeout(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxPipelinedRequests: 4,
+			idleTimeout:          time.Minute,
+			drainTimeout:         200 * time.Millisecond,
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	started := make(chan struct{})
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+		close(started)
+
+		<-ctx.Done()
+		return models.TaskResult{ID: request.ID, ExitCode: -1, Error: "command cancelled"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, serverConn, callback)
+
+	go clientConn.Write([]byte("{\"id\":\"stuck\",\"command\":[\"sleep\"]}\n"))
+	<-started
+	cancel()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var result models.TaskResult
+	err := json.NewDecoder(clientConn).Decode(&result)
+	assert.Nil(t, err, "The request in flight should be answered")
+	assert.Equal(t, "command cancelled", result.Error, "Requests still running after the drain timeout should be aborted")
+}
+
+func TestHandleConnection_SUCCESS_Requests_Without_ID_In_Order(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common:    common.NewCommonLib(),
+		scheduler: newScheduler(4),
+	}
+
+	conn := &mockConn{
+		readBuffer:  bytes.NewBufferString("{\"command\":[\"slow\"]}\n{\"command\":[\"fast\"]}\n"),
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+
+		if request.Command[0] == "slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+
+		return models.TaskResult{Command: request.Command}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go newNetwork.HandleConnection(ctx, conn, callback)
+
+	time.Sleep(1 * time.Second)
+	defer cancel()
+
+	lines := strings.Split(strings.TrimSpace(string(conn.written())), "\n")
+	assert.Equal(t, 2, len(lines), "Both requests should be answered")
+	assert.Contains(t, lines[0], "slow", "Requests without ID should be answered in order")
+	assert.Contains(t, lines[1], "fast", "Requests without ID should be answered in order")
+}
+
+func TestHandleConnection_SUCCESS_Hello_Switches_Framing(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return "result-mock"
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	clientConn.SetDeadline(time.Now().Add(3 * time.Second))
+	go clientConn.Write([]byte("{\"type\":\"hello\",\"versions\":[1],\"framing\":[\"length-prefixed\"]}\n"))
+
+	reader := bufio.NewReader(clientConn)
+	line, err := reader.ReadBytes('\n')
+	assert.Nil(t, err, "The hello should be answered with the current framing")
+
+	var hello models.HelloResult
+	err = json.Unmarshal(line, &hello)
+	assert.Nil(t, err, "The hello answer should be a valid HelloResult")
+	assert.Equal(t, "", hello.Error, "A compatible hello should be accepted")
+	assert.Equal(t, common.FramingLengthPrefixed, hello.Framing, "The requested framing should be negotiated")
+
+	request := []byte("{\"command\":[\"echo\"]}")
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(request)))
+	go clientConn.Write(append(frame, request...))
+
+	prefix := make([]byte, 4)
+	_, err = io.ReadFull(reader, prefix)
+	assert.Nil(t, err, "The response should be length-prefixed after the hello")
+
+	response := make([]byte, binary.BigEndian.Uint32(prefix))
+	_, err = io.ReadFull(reader, response)
+	assert.Nil(t, err, "The response should be fully read")
+	assert.Equal(t, "\"result-mock\"", string(response), "The response should be the marshalled callback result")
+}
+
+func TestHandleConnection_ERROR_Hello_Incompatible_Version(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	conn := &mockConn{
+		readBuffer:  bytes.NewBufferString("{\"type\":\"hello\",\"versions\":[99]}\n{\"command\":[\"echo\"]}\n"),
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -197,8 +652,272 @@ func TestHandleConnection_ERROR_WriteConn(t *testing.T) {
 	time.Sleep(1 * time.Second)
 	defer cancel()
 
-	assert.GreaterOrEqual(t, mockLib.onReadUntilNewline, 1, "Expected OneadUntilNewLine function to be called at least one time")
-	assert.GreaterOrEqual(t, mockLib.OnMarshalCalledCount, 1, "Expected Marshal function to be called at least one time")
-	assert.Equal(t, callbackWasCalled, true, "Expected callback to be called at least 1 time")
-	assert.Equal(t, conn.writeBuffer.String(), "", "The connection result should be empty")
+	var hello models.HelloResult
+	err := json.Unmarshal(conn.written(), &hello)
+	assert.Nil(t, err, "The rejection should be a valid HelloResult")
+	assert.Contains(t, hello.Error, "unsupported protocol versions", "The rejection should explain the incompatibility")
+	assert.Equal(t, false, callbackWasCalled.Load(), "Requests after a rejected hello should not be executed")
+	assert.Equal(t, true, conn.isClosed(), "The connection should be closed after a rejected hello")
+}
+
+func TestHandleConnection_ERROR_Client_Token(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		auth:   newClientAuth("secret"),
+	}
+
+	tests := map[string]string{
+		"without hello":   "{\"command\":[\"echo\"]}\n",
+		"invalid token":   "{\"type\":\"hello\",\"versions\":[1],\"token\":\"wrong\"}\n{\"command\":[\"echo\"]}\n",
+		"token forgotten": "{\"type\":\"hello\",\"versions\":[1]}\n{\"command\":[\"echo\"]}\n",
+	}
+
+	for name, messages := range tests {
+		conn := &mockConn{
+			readBuffer:  bytes.NewBufferString(messages),
+			writeBuffer: &bytes.Buffer{},
+		}
+
+		var callbackWasCalled atomic.Bool
+		callback := func(ctx context.Context, req []byte) interface{} {
+			callbackWasCalled.Store(true)
+			return nil
+		}
+
+		newNetwork.HandleConnection(context.Background(), conn, callback)
+
+		assert.Contains(t, string(conn.written()), "token", "The rejection should ask for the client token: "+name)
+		assert.Equal(t, false, callbackWasCalled.Load(), "Requests of unauthenticated clients should not be executed: "+name)
+		assert.Equal(t, true, conn.isClosed(), "Unauthenticated connections should be closed: "+name)
+	}
+}
+
+func TestHandleConnection_SUCCESS_Client_Token(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		auth:   newClientAuth("secret"),
+	}
+
+	conn := &mockConn{
+		readBuffer:  bytes.NewBufferString("{\"type\":\"hello\",\"versions\":[1],\"token\":\"secret\"}\n{\"command\":[\"echo\"]}\n"),
+		writeBuffer: &bytes.Buffer{},
+	}
+
+	var callbackWasCalled atomic.Bool
+	callback := func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled.Store(true)
+		return "result-mock"
+	}
+
+	newNetwork.HandleConnection(context.Background(), conn, callback)
+
+	assert.Equal(t, true, callbackWasCalled.Load(), "Requests after a hello with the client token should be executed")
+	assert.Contains(t, string(conn.written()), "result-mock", "The connection should receive the callback result")
+}
+
+func TestHandleConnection_SUCCESS_MessagePack_Codec(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	var receivedRequest models.TaskRequest
+	callback := func(ctx context.Context, req []byte) interface{} {
+		CodecFromContext(ctx).Unmarshal(req, &receivedRequest)
+		return models.TaskResult{ID: receivedRequest.ID, Output: "binary\n\x00output"}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	clientConn.SetDeadline(time.Now().Add(3 * time.Second))
+	clientFramer := common.NewLengthPrefixedFramer(clientConn, 1<<20)
+	codec := common.NewMsgPackCodec()
+
+	go func() {
+		clientConn.Write(common.LengthPrefixedPreamble)
+		clientFramer.WriteFrame([]byte(`{"type":"hello","versions":[1],"codecs":["msgpack"]}`))
+	}()
+
+	frame, err := clientFramer.ReadFrame()
+	assert.Nil(t, err, "The hello should be answered")
+
+	var hello models.HelloResult
+	json.Unmarshal(frame, &hello)
+	assert.Equal(t, common.CodecMsgPack, hello.Codec, "The requested codec should be negotiated")
+
+	request, err := codec.Marshal(models.TaskRequest{ID: "1", Command: []string{"cat"}, Timeout: 10})
+	assert.Nil(t, err, "Encoding the request should not return error")
+	go clientFramer.WriteFrame(request)
+
+	frame, err = clientFramer.ReadFrame()
+	assert.Nil(t, err, "The request should be answered")
+
+	var result models.TaskResult
+	err = codec.Unmarshal(frame, &result)
+	assert.Nil(t, err, "The result should be encoded with the negotiated codec")
+	assert.Equal(t, "1", result.ID, "The result should carry the request id")
+	assert.Equal(t, "binary\n\x00output", result.Output, "The result should be decoded with the same schema")
+	assert.Equal(t, []string{"cat"}, receivedRequest.Command, "The callback should decode the request with the negotiated codec")
+}
+
+func TestHandleConnection_SUCCESS_Compression(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			compressionThreshold: 512,
+		},
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	largeOutput := strings.Repeat("journal line\n", 1000)
+	var receivedRequest models.TaskRequest
+	callback := func(ctx context.Context, req []byte) interface{} {
+		json.Unmarshal(req, &receivedRequest)
+		if receivedRequest.ID == "small" {
+			return models.TaskResult{ID: receivedRequest.ID, Output: "ok"}
+		}
+		return models.TaskResult{ID: receivedRequest.ID, Output: largeOutput}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	clientConn.SetDeadline(time.Now().Add(3 * time.Second))
+	clientFramer := common.NewLengthPrefixedFramer(clientConn, 1<<20)
+	compressor := common.NewGzipCompressor()
+
+	go func() {
+		clientConn.Write(common.LengthPrefixedPreamble)
+		clientFramer.WriteFrame([]byte(`{"type":"hello","versions":[1],"compression":["gzip"]}`))
+	}()
+
+	frame, err := clientFramer.ReadFrame()
+	assert.Nil(t, err, "The hello should be answered")
+
+	var hello models.HelloResult
+	json.Unmarshal(frame, &hello)
+	assert.Equal(t, common.CompressionGzip, hello.Compression, "The requested compression should be negotiated")
+
+	// Requests may be compressed by the client too
+	compressedRequest, _ := compressor.Compress([]byte(`{"id":"large","command":["journalctl"]}`))
+	go clientFramer.WriteFrame(append([]byte{0x01}, compressedRequest...))
+
+	frame, err = clientFramer.ReadFrame()
+	assert.Nil(t, err, "The request should be answered")
+	assert.Equal(t, byte(0x01), frame[0], "Large responses should be compressed")
+	assert.Less(t, len(frame), len(largeOutput), "The compressed response should be smaller than the output")
+
+	decompressed, err := compressor.Decompress(frame[1:], 1<<20)
+	assert.Nil(t, err, "The response should be compressed with the negotiated compression")
+
+	var result models.TaskResult
+	json.Unmarshal(decompressed, &result)
+	assert.Equal(t, "large", result.ID, "The compressed request should be decoded")
+	assert.Equal(t, largeOutput, result.Output, "The output should survive compression")
+
+	go clientFramer.WriteFrame(append([]byte{0x00}, `{"id":"small","command":["true"]}`...))
+
+	frame, err = clientFramer.ReadFrame()
+	assert.Nil(t, err, "The request should be answered")
+	assert.Equal(t, byte(0x00), frame[0], "Responses under the threshold should not be compressed")
+
+	json.Unmarshal(frame[1:], &result)
+	assert.Equal(t, "ok", result.Output, "The uncompressed response should be sent as is")
+}
+
+func TestHandleConnection_SUCCESS_Cancel_Pipelined_Request(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			maxPipelinedRequests: 4,
+		},
+		scheduler: newScheduler(1),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	started := make(chan struct{})
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+		close(started)
+
+		<-ctx.Done()
+		return models.TaskResult{ID: request.ID, ExitCode: -1, Error: "command cancelled"}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	go func() {
+		clientConn.Write([]byte("{\"id\":\"running\",\"command\":[\"sleep\"]}\n"))
+		<-started
+		clientConn.Write([]byte("{\"id\":\"queued\",\"command\":[\"sleep\"]}\n"))
+		time.Sleep(100 * time.Millisecond)
+		clientConn.Write([]byte("{\"type\":\"cancel\",\"id\":\"queued\"}\n"))
+		clientConn.Write([]byte("{\"type\":\"cancel\",\"id\":\"running\"}\n"))
+	}()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	scanner := bufio.NewScanner(clientConn)
+
+	cancelled := map[string]string{}
+	for len(cancelled) < 2 && scanner.Scan() {
+		var result models.TaskResult
+		err := json.Unmarshal(scanner.Bytes(), &result)
+		assert.Nil(t, err, "Every response should be a valid result")
+		cancelled[result.ID] = result.Error
+	}
+
+	assert.Equal(t, "command cancelled before it started", cancelled["queued"], "Cancelled requests waiting for the scheduler should be answered")
+	assert.Equal(t, "command cancelled", cancelled["running"], "Running requests should be cancelled through their context")
+}
+
+func TestHandleConnection_SUCCESS_Streaming_Feature(t *testing.T) {
+	t.Parallel()
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		var request models.TaskRequest
+		json.Unmarshal(req, &request)
+
+		writeOutput, ok := OutputWriterFromContext(ctx)
+		assert.True(t, ok, "Connections that negotiated streaming should receive an OutputWriter")
+		writeOutput(models.OutputChunk{Type: models.MessageTypeOutput, ID: request.ID, Stream: models.StreamStdout, Data: "partial"})
+
+		return models.TaskResult{ID: request.ID, Output: "partial"}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	go func() {
+		clientConn.Write([]byte("{\"type\":\"hello\",\"versions\":[1],\"features\":[\"streaming\"]}\n"))
+		clientConn.Write([]byte("{\"id\":\"1\",\"command\":[\"tail\"]}\n"))
+	}()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	scanner := bufio.NewScanner(clientConn)
+
+	var lines []string
+	for len(lines) < 3 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.Len(t, lines, 3, "The hello, the output and the result should be sent")
+	assert.Contains(t, lines[0], "\"features\":[\"streaming\"]", "Streaming should be negotiated")
+	assert.Equal(t, "{\"type\":\"output\",\"id\":\"1\",\"stream\":\"stdout\",\"data\":\"partial\"}", lines[1], "The output should be sent while the request runs")
+	assert.Contains(t, lines[2], "\"output\":\"partial\"", "The result should follow the output")
 }
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.uber.org/zap"
+)
+
+// defaultShellInterpreter runs the shell scripts when the policy doesn't set an interpreter.
+var defaultShellInterpreter = []string{"/bin/sh", "-c"}
+
+// Policy restricts the commands clients can run. Commands and entries are matched by the absolute
+// path of their executable, names without a path are looked up in the PATH of the server like the
+// commands run. Denied entries without a path also match any executable with that name.
+type Policy struct {
+	// AllowedCommands, when not empty, are the only commands that can run.
+	AllowedCommands []string
+	// DeniedCommands can't run, even when allowed.
+	DeniedCommands []string
+
+	// ShellClients are the IP addresses or CIDR ranges (e.g. 10.0.0.0/8) of the clients allowed
+	// to send a shell script instead of a command. Scripts can run any command, the allowed and
+	// denied commands don't apply to them. Shell mode is disabled when empty.
+	ShellClients []string
+	// ShellInterpreter runs the scripts, given as its last argument. Defaults to /bin/sh -c.
+	ShellInterpreter []string
+
+	// ClientEnv are the names of the environment variables clients can set on their commands.
+	// Variables like LD_PRELOAD or PATH change what a command runs, allowing them lets clients
+	// run any code. Clients can't set the environment when empty.
+	ClientEnv []string
+	// ClientCwd are the absolute directories clients can run their commands in, their subdirectories
+	// included. Clients can't choose the working directory when empty.
+	ClientCwd []string
+}
+
+// Validate returns an error when a shell client isn't an IP address or a CIDR range, or a client
+// env or cwd is invalid.
+func (p Policy) Validate() error {
+	var errs []error
+	for _, client := range p.ShellClients {
+		if _, err := parseClientPrefix(client); err != nil {
+			errs = append(errs, fmt.Errorf("shell client %q is not an IP address or a CIDR range", client))
+		}
+	}
+
+	if len(p.ShellInterpreter) > 0 && p.ShellInterpreter[0] == "" {
+		errs = append(errs, errors.New("the shell interpreter can't be empty"))
+	}
+
+	for _, name := range p.ClientEnv {
+		if name == "" || strings.ContainsRune(name, '=') {
+			errs = append(errs, fmt.Errorf("client env %q is not the name of an environment variable", name))
+		}
+	}
+
+	for _, dir := range p.ClientCwd {
+		if !filepath.IsAbs(dir) {
+			errs = append(errs, fmt.Errorf("client cwd %q is not an absolute directory", dir))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func parseClientPrefix(client string) (netip.Prefix, error) {
+	if strings.Contains(client, "/") {
+		return netip.ParsePrefix(client)
+	}
+
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// checkShell returns an error when the policy doesn't let the client at remoteAddr run scripts.
+func (p *Policy) checkShell(remoteAddr string) error {
+	if p == nil || len(p.ShellClients) == 0 {
+		return errors.New("shell mode is disabled by the server policy")
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		addr = addr.Unmap()
+		for _, client := range p.ShellClients {
+			if prefix, err := parseClientPrefix(client); err == nil && prefix.Contains(addr) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("shell mode is not allowed for client %s by the server policy", host)
+}
+
+// shellCommand returns the command running script with the interpreter of the policy.
+func (p *Policy) shellCommand(script string) []string {
+	interpreter := defaultShellInterpreter
+	if p != nil && len(p.ShellInterpreter) > 0 {
+		interpreter = p.ShellInterpreter
+	}
+
+	return append(slices.Clone(interpreter), script)
+}
+
+// checkEnvironment returns an error when the policy doesn't let the client set env or cwd.
+func (p *Policy) checkEnvironment(env map[string]string, cwd string) error {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if p == nil || !slices.Contains(p.ClientEnv, name) {
+			return fmt.Errorf("environment variable %q is not allowed by the server policy", name)
+		}
+	}
+
+	if cwd == "" {
+		return nil
+	}
+
+	if p != nil && filepath.IsAbs(cwd) {
+		dir := filepath.Clean(cwd)
+		for _, allowed := range p.ClientCwd {
+			if rel, err := filepath.Rel(filepath.Clean(allowed), dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("working directory %q is not allowed by the server policy", cwd)
+}
+
+// check returns an error when the policy doesn't let command run. Relative paths are always
+// rejected, they would run what the client put in its working directory.
+func (p *Policy) check(command []string) error {
+	if len(command) == 0 {
+		return nil
+	}
+
+	executable := command[0]
+	if strings.ContainsRune(executable, filepath.Separator) && !filepath.IsAbs(executable) {
+		return fmt.Errorf("command %q must be a name or an absolute path", executable)
+	}
+
+	if p == nil {
+		return nil
+	}
+
+	// Commands not found can't match an entry, they fail to start when only denied commands are set
+	path, _ := executablePath(executable)
+
+	denied := slices.ContainsFunc(p.DeniedCommands, func(entry string) bool {
+		return entry == filepath.Base(executable) || matchExecutable(entry, path)
+	})
+	if denied {
+		return fmt.Errorf("command %q is denied by the server policy", executable)
+	}
+
+	allowed := slices.ContainsFunc(p.AllowedCommands, func(entry string) bool {
+		return matchExecutable(entry, path)
+	})
+	if len(p.AllowedCommands) > 0 && !allowed {
+		return fmt.Errorf("command %q is not allowed by the server policy", executable)
+	}
+
+	return nil
+}
+
+// matchExecutable reports whether the entry of a policy names the executable at path.
+func matchExecutable(entry string, path string) bool {
+	if path == "" {
+		return false
+	}
+
+	entryPath, err := executablePath(entry)
+	return err == nil && entryPath == path
+}
+
+// executablePath returns the absolute path of the executable the server runs for name, names without
+// a path are looked up in the PATH of the server like exec.Command does. Symlinks of the directory are
+// resolved, so /bin/ls matches /usr/bin/ls when /bin links to /usr/bin, but not those of the executable
+// itself: tools like busybox link every command to the same binary.
+func executablePath(name string) (string, error) {
+	if !strings.ContainsRune(name, filepath.Separator) {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", err
+		}
+		name = path
+	}
+
+	if !filepath.IsAbs(name) {
+		return "", fmt.Errorf("%q is not an absolute path", name)
+	}
+
+	dir := filepath.Dir(filepath.Clean(name))
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+
+	return filepath.Join(dir, filepath.Base(name)), nil
+}
+
+// enforce wraps a callback to reject the requests the current policy doesn't allow, commands, env
+// and cwd, and to run the shell scripts with the interpreter of the policy. The policy is read on every
+// request, so it can be replaced while the server runs. Every request checked is recorded by audit,
+// when not nil.
+func enforce(policy *atomic.Pointer[Policy], audit *zap.SugaredLogger, callback func(ctx context.Context, req []byte) interface{}) func(ctx context.Context, req []byte) interface{} {
+	return func(ctx context.Context, req []byte) interface{} {
+		codec := CodecFromContext(ctx)
+
+		var request models.TaskRequest
+		if err := codec.Unmarshal(req, &request); err != nil {
+			// Invalid requests are answered by the callback
+			return callback(ctx, req)
+		}
+
+		info, _ := ctx.Value(requestInfoCtxKey).(*requestInfo)
+		current := policy.Load()
+
+		var err error
+		switch {
+		case request.Shell != "" && len(request.Command) > 0:
+			err = errors.New("a request can't set both command and shell")
+		case request.Shell != "":
+			if err = current.checkShell(info.clientAddr()); err == nil {
+				request.Command = current.shellCommand(request.Shell)
+				req, err = codec.Marshal(request)
+			}
+		default:
+			err = current.check(request.Command)
+		}
+
+		// The env and cwd of named tasks come from the registry of the server, not from the client
+		if _, isTask := ctx.Value(taskCtxKey).(string); err == nil && !isTask {
+			err = current.checkEnvironment(request.Env, request.Cwd)
+		}
+
+		if err != nil {
+			auditRequest(audit, info, request, "Request denied", "Reason", err.Error())
+			return models.TaskResult{
+				ID:       request.ID,
+				Command:  request.Command,
+				ExitCode: exitCodeErrorGeneral,
+				Error:    err.Error(),
+			}
+		}
+
+		result := callback(ctx, req)
+
+		exitCode := exitCodeErrorGeneral
+		if taskResult, ok := result.(models.TaskResult); ok {
+			exitCode = taskResult.ExitCode
+		}
+		auditRequest(audit, info, request, "Request executed", "ExitCode", exitCode)
+
+		return result
+	}
+}
+
+// auditRequest records a request checked by the policy, with its command or script as sent by the client.
+func auditRequest(audit *zap.SugaredLogger, info *requestInfo, request models.TaskRequest, message string, keysAndValues ...interface{}) {
+	if audit == nil {
+		return
+	}
+
+	fields := []interface{}{
+		"ID", request.ID,
+		"Command", request.Command,
+	}
+	if request.Task != "" {
+		fields = append(fields, "Task", request.Task)
+	}
+	if request.Shell != "" {
+		fields = append(fields, "Shell", request.Shell)
+	}
+	if info != nil {
+		fields = append(fields, "CID", info.correlationID, "Transport", info.transport, "RemoteAddr", info.remoteAddr)
+	}
+
+	audit.Infow(message, append(fields, keysAndValues...)...)
+}
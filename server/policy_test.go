@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// executables creates empty executables named names in dir.
+func executables(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0o755)
+		assert.Nil(t, err, "The executable should be created")
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	bin := t.TempDir()
+	executables(t, bin, "echo", "ls", "journalctl")
+	t.Setenv("PATH", bin)
+
+	policy := &Policy{
+		AllowedCommands: []string{"echo", "ls", filepath.Join(bin, "journalctl")},
+		DeniedCommands:  []string{"ls"},
+	}
+
+	assert.Nil(t, policy.check([]string{"echo", "hi"}), "Allowed commands should run")
+	assert.Nil(t, policy.check([]string{filepath.Join(bin, "echo")}), "Commands should be matched by the path found in the PATH of the server")
+	assert.Nil(t, policy.check([]string{filepath.Join(bin, "journalctl")}), "Entries with a path should match the full path")
+	assert.NotNil(t, policy.check([]string{"/opt/journalctl"}), "Entries with a path should not match other paths")
+	assert.NotNil(t, policy.check([]string{"ls"}), "Denied commands should not run, even when allowed")
+	assert.NotNil(t, policy.check([]string{"rm"}), "Commands not allowed should not run")
+	assert.Nil(t, (&Policy{}).check([]string{"rm"}), "An empty policy should allow every command")
+}
+
+func TestPolicy_Check_Bypasses(t *testing.T) {
+	bin := t.TempDir()
+	uploads := t.TempDir()
+	executables(t, bin, "echo", "ls")
+	executables(t, uploads, "echo", "ls")
+	t.Setenv("PATH", bin)
+
+	policy := &Policy{AllowedCommands: []string{"echo"}, DeniedCommands: []string{"ls"}}
+
+	assert.NotNil(t, policy.check([]string{filepath.Join(uploads, "echo")}), "Executables named like an allowed command elsewhere should not run")
+	assert.NotNil(t, policy.check([]string{"./echo"}), "Relative paths should not run, they depend on the working directory")
+	assert.NotNil(t, policy.check([]string{"uploads/echo"}), "Relative paths should not run, they depend on the working directory")
+	assert.NotNil(t, (&Policy{}).check([]string{"./echo"}), "Relative paths should not run, even without restrictions")
+	assert.NotNil(t, policy.check([]string{filepath.Join(uploads, "ls")}), "Denied names should match any executable with that name")
+
+	var current atomic.Pointer[Policy]
+	current.Store(&Policy{AllowedCommands: []string{"echo"}, ClientCwd: []string{uploads}})
+
+	callbackWasCalled := false
+	callback := enforce(&current, nil, func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled = true
+		return models.TaskResult{}
+	})
+
+	result := callback(context.Background(), []byte(`{"command":["./echo"],"cwd":"`+uploads+`"}`)).(models.TaskResult)
+	assert.Equal(t, `command "./echo" must be a name or an absolute path`, result.Error, "Executables of the working directory of the client should not run")
+
+	result = callback(context.Background(), []byte(`{"command":["echo"],"env":{"LD_PRELOAD":"`+uploads+`/x.so"}}`)).(models.TaskResult)
+	assert.Equal(t, `environment variable "LD_PRELOAD" is not allowed by the server policy`, result.Error, "Allowed commands should not load the code of the client")
+	assert.False(t, callbackWasCalled, "Requests bypassing the policy should not reach the callback")
+
+	callback(context.Background(), []byte(`{"command":["echo"],"cwd":"`+uploads+`"}`))
+	assert.True(t, callbackWasCalled, "Allowed commands should run in the allowed directories")
+}
+
+func TestEnforce_ERROR_Denied(t *testing.T) {
+	var policy atomic.Pointer[Policy]
+	policy.Store(&Policy{DeniedCommands: []string{"rm"}})
+
+	callbackWasCalled := false
+	callback := enforce(&policy, nil, func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled = true
+		return models.TaskResult{}
+	})
+
+	result := callback(context.Background(), []byte(`{"id":"a","command":["rm","-rf","/"]}`)).(models.TaskResult)
+	assert.False(t, callbackWasCalled, "Denied commands should not reach the callback")
+	assert.Equal(t, "a", result.ID, "The result should be tagged with the request ID")
+	assert.Equal(t, exitCodeErrorGeneral, result.ExitCode, "Denied commands should fail")
+	assert.Contains(t, result.Error, "denied by the server policy", "The error should explain the command was denied")
+
+	policy.Store(&Policy{})
+	callback(context.Background(), []byte(`{"command":["rm"]}`))
+	assert.True(t, callbackWasCalled, "A replaced policy should apply to the next requests")
+}
+
+func TestPolicy_Check_Shell(t *testing.T) {
+	policy := &Policy{ShellClients: []string{"10.0.0.0/8", "192.168.1.7", "::1"}}
+
+	assert.Nil(t, policy.checkShell("10.1.2.3:5000"), "Clients in an allowed range should use shell mode")
+	assert.Nil(t, policy.checkShell("192.168.1.7:5000"), "Allowed addresses should use shell mode")
+	assert.Nil(t, policy.checkShell("[::1]:5000"), "Allowed IPv6 addresses should use shell mode")
+	assert.Nil(t, policy.checkShell("[::ffff:10.0.0.1]:5000"), "IPv4 clients mapped to IPv6 should match their IPv4 range")
+	assert.NotNil(t, policy.checkShell("192.168.1.8:5000"), "Other clients should not use shell mode")
+	assert.NotNil(t, policy.checkShell("pipe"), "Clients without an IP address should not use shell mode")
+	assert.NotNil(t, (&Policy{}).checkShell("10.1.2.3:5000"), "Shell mode should be disabled by default")
+
+	assert.Equal(t, []string{"/bin/sh", "-c", "ls | wc -l"}, policy.shellCommand("ls | wc -l"), "Scripts should run with /bin/sh by default")
+	policy.ShellInterpreter = []string{"/bin/bash", "-c"}
+	assert.Equal(t, []string{"/bin/bash", "-c", "ls | wc -l"}, policy.shellCommand("ls | wc -l"), "Scripts should run with the interpreter of the policy")
+
+	assert.NotNil(t, Policy{ShellClients: []string{"localhost"}}.Validate(), "Shell clients should be IP addresses or ranges")
+	assert.NotNil(t, Policy{ShellInterpreter: []string{""}}.Validate(), "The interpreter should not be empty")
+	assert.Nil(t, policy.Validate(), "A valid policy should be accepted")
+}
+
+func TestEnforce_SUCCESS_Shell_Audited(t *testing.T) {
+	var policy atomic.Pointer[Policy]
+	policy.Store(&Policy{ShellClients: []string{"127.0.0.1"}, DeniedCommands: []string{"sh"}})
+
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	var received models.TaskRequest
+	callback := enforce(&policy, zap.New(core).Sugar(), func(ctx context.Context, req []byte) interface{} {
+		CodecFromContext(ctx).Unmarshal(req, &received)
+		return models.TaskResult{Command: received.Command}
+	})
+
+	info := newRequestInfo("cid", "server").describe(transportTCP, "cid", "127.0.0.1:4000", models.Envelope{})
+	ctx := context.WithValue(context.Background(), requestInfoCtxKey, info)
+
+	result := callback(ctx, []byte(`{"shell":"ls | wc -l"}`)).(models.TaskResult)
+	assert.Empty(t, result.Error, "An allowed client should run scripts, the denied commands don't apply to them")
+	assert.Equal(t, []string{"/bin/sh", "-c", "ls | wc -l"}, received.Command, "The script should run with the interpreter")
+
+	info.remoteAddr = "10.0.0.1:4000"
+	result = callback(ctx, []byte(`{"shell":"rm -rf /"}`)).(models.TaskResult)
+	assert.Equal(t, "shell mode is not allowed for client 10.0.0.1 by the server policy", result.Error, "Other clients should not run scripts")
+
+	result = callback(ctx, []byte(`{"shell":"ls","command":["ls"]}`)).(models.TaskResult)
+	assert.Equal(t, "a request can't set both command and shell", result.Error, "A request should not set both a command and a script")
+
+	entries := logs.All()
+	assert.Len(t, entries, 3, "Every request should be audited")
+	assert.Equal(t, "Request executed", entries[0].Message, "Executed requests should be audited")
+	assert.Equal(t, "ls | wc -l", entries[0].ContextMap()["Shell"], "The audit should record the script")
+	assert.Equal(t, "127.0.0.1:4000", entries[0].ContextMap()["RemoteAddr"], "The audit should record the client")
+	assert.Equal(t, "Request denied", entries[1].Message, "Denied requests should be audited")
+	assert.Equal(t, "rm -rf /", entries[1].ContextMap()["Shell"], "The audit should record the denied script")
+}
+
+func TestPolicy_Check_Environment(t *testing.T) {
+	policy := &Policy{ClientEnv: []string{"LANG"}, ClientCwd: []string{"/srv/reports"}}
+
+	assert.Nil(t, policy.checkEnvironment(map[string]string{"LANG": "C"}, "/srv/reports/daily"), "Allowed variables and directories should be accepted")
+	assert.Nil(t, policy.checkEnvironment(nil, "/srv/reports"), "The allowed directory itself should be accepted")
+	assert.NotNil(t, policy.checkEnvironment(map[string]string{"LANG": "C", "LD_PRELOAD": "/tmp/x.so"}, ""), "Variables not allowed should be rejected")
+	assert.NotNil(t, policy.checkEnvironment(nil, "/srv/reports/../../tmp"), "Directories escaping the allowed ones should be rejected")
+	assert.NotNil(t, policy.checkEnvironment(nil, "/srv/reports-old"), "Directories sharing a prefix with an allowed one should be rejected")
+	assert.NotNil(t, policy.checkEnvironment(nil, "reports"), "Relative directories should be rejected")
+
+	assert.NotNil(t, (&Policy{}).checkEnvironment(map[string]string{"LANG": "C"}, ""), "Clients should not set the environment by default")
+	assert.NotNil(t, (&Policy{}).checkEnvironment(nil, "/tmp"), "Clients should not choose the working directory by default")
+	assert.Nil(t, (&Policy{}).checkEnvironment(nil, ""), "Requests without env and cwd should be accepted")
+
+	assert.NotNil(t, Policy{ClientEnv: []string{"A=B"}}.Validate(), "Client env entries should be names of variables")
+	assert.NotNil(t, Policy{ClientCwd: []string{"srv"}}.Validate(), "Client cwd entries should be absolute")
+	assert.Nil(t, policy.Validate(), "A valid policy should be accepted")
+}
+
+func TestEnforce_ERROR_Client_Environment(t *testing.T) {
+	var policy atomic.Pointer[Policy]
+	policy.Store(&Policy{})
+
+	callbackWasCalled := false
+	callback := enforce(&policy, nil, func(ctx context.Context, req []byte) interface{} {
+		callbackWasCalled = true
+		return models.TaskResult{}
+	})
+
+	result := callback(context.Background(), []byte(`{"command":["ls"],"env":{"LD_PRELOAD":"/tmp/x.so"}}`)).(models.TaskResult)
+	assert.False(t, callbackWasCalled, "Requests setting the environment should not reach the callback by default")
+	assert.Equal(t, `environment variable "LD_PRELOAD" is not allowed by the server policy`, result.Error, "The error should name the variable")
+
+	result = callback(context.Background(), []byte(`{"command":["ls"],"cwd":"/tmp"}`)).(models.TaskResult)
+	assert.False(t, callbackWasCalled, "Requests choosing the working directory should not reach the callback by default")
+	assert.Equal(t, `working directory "/tmp" is not allowed by the server policy`, result.Error, "The error should name the directory")
+
+	taskCtx := context.WithValue(context.Background(), taskCtxKey, "report")
+	callback(taskCtx, []byte(`{"command":["ls"],"env":{"LANG":"C"},"cwd":"/tmp"}`))
+	assert.True(t, callbackWasCalled, "The env and cwd of named tasks should be accepted")
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+// Fields of the requests and results that can be redacted from the logs.
+const (
+	RedactCommand = "command"
+	RedactEnv     = "env"
+	RedactCwd     = "cwd"
+	RedactOutput  = "output"
+	RedactError   = "error"
+	RedactParams  = "params"
+)
+
+// redacted replaces the secrets in the logs.
+const redacted = "[REDACTED]"
+
+// Redaction hides secrets of the requests and results from the logs and the admin API.
+type Redaction struct {
+	// Fields are replaced as a whole: command (and shell script), env, cwd, params, output, error,
+	// or env.<NAME> and params.<NAME> for the value of a single environment variable or task parameter.
+	Fields []string
+	// Patterns are regular expressions replaced in the command, the env values, the cwd,
+	// the task parameters, the output and the error.
+	Patterns []string
+}
+
+// Validate returns an error when a field is unknown or a pattern isn't a valid regular expression.
+func (r Redaction) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+func (r Redaction) compile() (*redactionRules, error) {
+	rules := &redactionRules{fields: map[string]bool{}}
+
+	for _, field := range r.Fields {
+		switch {
+		case field == RedactCommand, field == RedactEnv, field == RedactCwd, field == RedactParams, field == RedactOutput, field == RedactError:
+		case strings.HasPrefix(field, RedactEnv+".") && len(field) > len(RedactEnv)+1:
+		case strings.HasPrefix(field, RedactParams+".") && len(field) > len(RedactParams)+1:
+		default:
+			return nil, fmt.Errorf("unknown redaction field %q, it must be command, env, env.<NAME>, cwd, params, params.<NAME>, output or error", field)
+		}
+		rules.fields[field] = true
+	}
+
+	for _, pattern := range r.Patterns {
+		expression, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		rules.patterns = append(rules.patterns, expression)
+	}
+
+	return rules, nil
+}
+
+type redactionRules struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// redactor applies the redaction rules to what is logged. Its rules can be replaced while the
+// server runs, and a nil *redactor logs everything as it is.
+type redactor struct {
+	rules atomic.Pointer[redactionRules]
+}
+
+func newRedactor(redaction Redaction) (*redactor, error) {
+	r := &redactor{}
+	if err := r.set(redaction); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// set replaces the rules applied to the next logs.
+func (r *redactor) set(redaction Redaction) error {
+	rules, err := redaction.compile()
+	if err != nil {
+		return err
+	}
+
+	r.rules.Store(rules)
+	return nil
+}
+
+func (r *redactor) load() *redactionRules {
+	if r == nil {
+		return nil
+	}
+
+	return r.rules.Load()
+}
+
+// payload renders a message received from a client for the logs. Task requests are decoded to
+// be redacted, hellos hide their client token and the other messages carry no secrets.
+func (r *redactor) payload(codec common.Codec, message []byte) interface{} {
+	var envelope models.Envelope
+	if err := codec.Unmarshal(message, &envelope); err == nil && envelope.Type != "" && envelope.Type != models.MessageTypeTask {
+		if envelope.Type == models.MessageTypeHello {
+			return helloPayload(codec, message)
+		}
+		return logPayload(codec, message)
+	}
+
+	var request models.TaskRequest
+	if err := codec.Unmarshal(message, &request); err != nil {
+		if codec.Binary() {
+			return message
+		}
+		return r.load().text(string(message))
+	}
+
+	return r.request(request)
+}
+
+// helloPayload returns a hello with its client token hidden, even without redaction rules.
+func helloPayload(codec common.Codec, message []byte) interface{} {
+	var hello models.Hello
+	if err := codec.Unmarshal(message, &hello); err != nil {
+		return redacted
+	}
+
+	if hello.Token != "" {
+		hello.Token = redacted
+	}
+
+	return hello
+}
+
+// request returns a copy of the request with its secrets redacted.
+func (r *redactor) request(request models.TaskRequest) models.TaskRequest {
+	rules := r.load()
+	if rules == nil {
+		return request
+	}
+
+	request.Command = rules.command(request.Command)
+	request.Shell = rules.field(RedactCommand, request.Shell)
+	request.Cwd = rules.field(RedactCwd, request.Cwd)
+
+	if len(request.Env) > 0 {
+		env := maps.Clone(request.Env)
+		for name, value := range env {
+			if rules.fields[RedactEnv+"."+name] {
+				env[name] = redacted
+				continue
+			}
+			env[name] = rules.field(RedactEnv, value)
+		}
+		request.Env = env
+	}
+
+	if len(request.Params) > 0 {
+		params := maps.Clone(request.Params)
+		for name, value := range params {
+			text, ok := value.(string)
+			switch {
+			case rules.fields[RedactParams+"."+name], rules.fields[RedactParams] && !ok:
+				params[name] = redacted
+			case ok:
+				params[name] = rules.field(RedactParams, text)
+			}
+		}
+		request.Params = params
+	}
+
+	return request
+}
+
+// result returns a copy of a TaskResult with its secrets redacted, other results are returned as they are.
+func (r *redactor) result(result interface{}) interface{} {
+	taskResult, ok := result.(models.TaskResult)
+	rules := r.load()
+	if !ok || rules == nil {
+		return result
+	}
+
+	taskResult.Command = rules.command(taskResult.Command)
+	taskResult.Output = rules.field(RedactOutput, taskResult.Output)
+	taskResult.Error = rules.field(RedactError, taskResult.Error)
+
+	return taskResult
+}
+
+func (rules *redactionRules) command(command []string) []string {
+	if len(command) == 0 {
+		return command
+	}
+
+	if rules.fields[RedactCommand] {
+		return []string{redacted}
+	}
+
+	redactedCommand := make([]string, len(command))
+	for i, arg := range command {
+		redactedCommand[i] = rules.text(arg)
+	}
+
+	return redactedCommand
+}
+
+// field redacts the whole value when the field is redacted, or the matches of the patterns.
+func (rules *redactionRules) field(name string, value string) string {
+	if value == "" {
+		return value
+	}
+
+	if rules.fields[name] {
+		return redacted
+	}
+
+	return rules.text(value)
+}
+
+func (rules *redactionRules) text(value string) string {
+	if rules == nil {
+		return value
+	}
+
+	for _, pattern := range rules.patterns {
+		value = pattern.ReplaceAllString(value, redacted)
+	}
+
+	return value
+}
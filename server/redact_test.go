@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactor_SUCCESS_Fields(t *testing.T) {
+	redactor, err := newRedactor(Redaction{Fields: []string{RedactOutput, "env.TOKEN", "params.password"}})
+	assert.Nil(t, err, "Valid fields should be accepted")
+
+	request := models.TaskRequest{
+		Command: []string{"echo", "hi"},
+		Env:     map[string]string{"TOKEN": "secret", "LANG": "C"},
+		Params:  map[string]interface{}{"password": "secret", "user": "me"},
+	}
+	redactedRequest := redactor.request(request)
+	assert.Equal(t, map[string]string{"TOKEN": redacted, "LANG": "C"}, redactedRequest.Env, "Only the redacted env variable should be hidden")
+	assert.Equal(t, map[string]interface{}{"password": redacted, "user": "me"}, redactedRequest.Params, "Only the redacted parameter should be hidden")
+	assert.Equal(t, "secret", request.Env["TOKEN"], "The request should not be changed")
+
+	result := redactor.result(models.TaskResult{Command: []string{"echo", "hi"}, Output: "hi"}).(models.TaskResult)
+	assert.Equal(t, redacted, result.Output, "The output should be hidden")
+	assert.Equal(t, []string{"echo", "hi"}, result.Command, "Fields not redacted should be kept")
+
+	result = redactor.result(models.TaskResult{ExitCode: 1}).(models.TaskResult)
+	assert.Empty(t, result.Output, "An empty output should stay empty")
+
+	assert.Equal(t, "other", redactor.result("other"), "Other results should be returned as they are")
+}
+
+func TestRedactor_SUCCESS_Patterns(t *testing.T) {
+	redactor, err := newRedactor(Redaction{Patterns: []string{`password=\S+`}})
+	assert.Nil(t, err, "Valid patterns should be accepted")
+
+	request := redactor.request(models.TaskRequest{
+		Command: []string{"login", "password=hunter2"},
+		Env:     map[string]string{"ARGS": "user=me password=hunter2"},
+	})
+	assert.Equal(t, []string{"login", redacted}, request.Command, "The matches in the command should be hidden")
+	assert.Equal(t, "user=me "+redacted, request.Env["ARGS"], "The matches in the env values should be hidden")
+
+	result := redactor.result(models.TaskResult{Output: "ok password=hunter2", Error: "password=hunter2 refused"}).(models.TaskResult)
+	assert.Equal(t, "ok "+redacted, result.Output, "The matches in the output should be hidden")
+	assert.Equal(t, redacted+" refused", result.Error, "The matches in the error should be hidden")
+
+	payload := redactor.payload(common.NewJSONCodec(), []byte(`{"command":["login","password=hunter2"]`))
+	assert.Equal(t, `{"command":["login","`+redacted, payload, "The matches in invalid requests should be hidden")
+}
+
+func TestRedactor_SUCCESS_Nil(t *testing.T) {
+	var redactor *redactor
+
+	request := models.TaskRequest{Command: []string{"echo", "secret"}}
+	assert.Equal(t, request, redactor.request(request), "A nil redactor should log requests as they are")
+
+	payload := redactor.payload(common.NewJSONCodec(), []byte(`{"type":"cancel","id":"1"}`))
+	assert.Equal(t, `{"type":"cancel","id":"1"}`, payload, "Messages other than tasks should be logged as they are")
+}
+
+func TestRedactor_SUCCESS_Hello_Token(t *testing.T) {
+	var redactor *redactor
+
+	payload := redactor.payload(common.NewJSONCodec(), []byte(`{"type":"hello","versions":[1],"token":"secret"}`))
+	assert.Equal(t, models.Hello{Type: models.MessageTypeHello, Versions: []int{1}, Token: "[REDACTED]"}, payload, "The client token should never be logged")
+}
+
+func TestRedactor_ERROR_Invalid(t *testing.T) {
+	_, err := newRedactor(Redaction{Fields: []string{"stdout"}})
+	assert.NotNil(t, err, "Unknown fields should be rejected")
+
+	_, err = newRedactor(Redaction{Fields: []string{"env."}})
+	assert.NotNil(t, err, "Env fields without a name should be rejected")
+
+	_, err = newRedactor(Redaction{Patterns: []string{"("}})
+	assert.NotNil(t, err, "Invalid patterns should be rejected")
+}
+
+func TestHandleConnection_SUCCESS_Redacted_Logs(t *testing.T) {
+	redactor, err := newRedactor(Redaction{Fields: []string{RedactEnv, RedactOutput}})
+	assert.Nil(t, err, "Valid fields should be accepted")
+
+	newNetwork := &networkImpl{
+		common:   common.NewCommonLib(),
+		redactor: redactor,
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := context.WithValue(context.Background(), "logger", zap.New(core).Sugar())
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{Command: []string{"env"}, Output: "TOKEN=secret"}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go newNetwork.HandleConnection(ctx, serverConn, callback)
+
+	clientConn.SetDeadline(time.Now().Add(3 * time.Second))
+	clientConn.Write([]byte(`{"command":["env"],"env":{"TOKEN":"secret"}}` + "\n"))
+
+	var result models.TaskResult
+	err = json.NewDecoder(bufio.NewReader(clientConn)).Decode(&result)
+	assert.Nil(t, err, "The request should be answered")
+	assert.Equal(t, "TOKEN=secret", result.Output, "The client should receive the output as it is")
+
+	received := logs.FilterMessage("Received Request").All()
+	assert.Len(t, received, 1, "The request should be logged")
+	assert.Equal(t, map[string]string{"TOKEN": redacted}, received[0].ContextMap()["Request"].(models.TaskRequest).Env, "The env should be redacted from the logs")
+
+	returned := logs.FilterMessage("Return Result").All()
+	assert.Len(t, returned, 1, "The result should be logged")
+	assert.Equal(t, redacted, returned[0].ContextMap()["Result"].(models.TaskResult).Output, "The output should be redacted from the logs")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+// requestInfo identifies a request and records how the server handled it, to report both
+// back to the client in the TaskResult.
+type requestInfo struct {
+	correlationID string
+	serverID      string
+
+	// Describe the request on the admin endpoint, see activity.
+	id           string
+	requestID    string
+	connectionID string
+	remoteAddr   string
+	transport    string
+	command      []string
+	task         string
+	shell        string
+	pid          atomic.Int64
+	cancel       context.CancelFunc
+	killed       atomic.Bool
+
+	queuedAt   time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+func newRequestInfo(correlationID string, serverID string) *requestInfo {
+	return &requestInfo{
+		correlationID: correlationID,
+		serverID:      serverID,
+	}
+}
+
+// describe records where a request comes from and what it runs, to list it on the admin endpoint.
+func (info *requestInfo) describe(transport string, connectionID string, remoteAddr string, envelope models.Envelope) *requestInfo {
+	info.transport = transport
+	info.connectionID = connectionID
+	info.remoteAddr = remoteAddr
+	info.requestID = envelope.ID
+	info.command = envelope.Command
+	info.task = envelope.Task
+	info.shell = envelope.Shell
+
+	return info
+}
+
+// clientAddr returns the address of the client that sent the request, empty when unknown.
+func (info *requestInfo) clientAddr() string {
+	if info == nil {
+		return ""
+	}
+
+	return info.remoteAddr
+}
+
+// requestCorrelationID returns the correlation ID chosen by the client when it is valid, or fallback.
+func requestCorrelationID(requested string, fallback string) string {
+	if models.ValidCorrelationID(requested) {
+		return requested
+	}
+
+	return fallback
+}
+
+// annotate adds the correlation ID, server ID and timing of the request to a callback result.
+// Results that aren't a TaskResult are returned as they are.
+func (info *requestInfo) annotate(result interface{}) interface{} {
+	taskResult, ok := result.(models.TaskResult)
+	if !ok {
+		return result
+	}
+
+	taskResult.CorrelationID = info.correlationID
+	taskResult.ServerID = info.serverID
+
+	if !info.startedAt.IsZero() {
+		taskResult.QueueWaitMs = float64(info.startedAt.Sub(info.queuedAt)) / float64(time.Millisecond)
+		taskResult.StartedAtNs = info.startedAt.UnixNano()
+		taskResult.FinishedAtNs = info.finishedAt.UnixNano()
+	}
+
+	return taskResult
+}
+
+// cancelledBeforeStart is the result of a request cancelled while it waited for the scheduler.
+func cancelledBeforeStart(id string) models.TaskResult {
+	return models.TaskResult{
+		ID:       id,
+		ExitCode: exitCodeErrorGeneral,
+		Error:    "command cancelled before it started",
+	}
+}
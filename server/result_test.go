@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRequestCorrelationID(t *testing.T) {
+	assert.Equal(t, "deploy-42", requestCorrelationID("deploy-42", "fallback"), "A valid ID chosen by the client should be used")
+	assert.Equal(t, "fallback", requestCorrelationID("", "fallback"), "Requests without an ID should use the fallback")
+	assert.Equal(t, "fallback", requestCorrelationID("bad id\n", "fallback"), "Invalid IDs should be replaced by the fallback")
+	assert.Equal(t, "fallback", requestCorrelationID(strings.Repeat("a", 129), "fallback"), "IDs longer than 128 characters should be replaced by the fallback")
+}
+
+func TestAnnotate_SUCCESS(t *testing.T) {
+	info := newRequestInfo("cid", "server-1")
+	info.queuedAt = time.Unix(0, 1_000_000)
+	info.startedAt = time.Unix(0, 3_500_000)
+	info.finishedAt = time.Unix(0, 10_000_000)
+
+	result, ok := info.annotate(models.TaskResult{Output: "hello"}).(models.TaskResult)
+
+	assert.True(t, ok, "A TaskResult should stay a TaskResult")
+	assert.Equal(t, "hello", result.Output, "The result should be kept")
+	assert.Equal(t, "cid", result.CorrelationID, "The result should carry the correlation ID")
+	assert.Equal(t, "server-1", result.ServerID, "The result should carry the server ID")
+	assert.Equal(t, 2.5, result.QueueWaitMs, "The queue wait should be the time between queueing and start")
+	assert.Equal(t, int64(3_500_000), result.StartedAtNs, "The start should have nanosecond precision")
+	assert.Equal(t, int64(10_000_000), result.FinishedAtNs, "The end should have nanosecond precision")
+}
+
+func TestAnnotate_SUCCESS_Not_Started(t *testing.T) {
+	info := newRequestInfo("cid", "server-1")
+
+	result := info.annotate(models.TaskResult{}).(models.TaskResult)
+
+	assert.Equal(t, "cid", result.CorrelationID, "Requests that didn't run should still carry the correlation ID")
+	assert.Zero(t, result.StartedAtNs, "Requests that didn't run should have no timing")
+	assert.Equal(t, "other", info.annotate("other"), "Results that aren't a TaskResult should be returned as they are")
+}
+
+func TestHandleConnection_SUCCESS_Correlation_And_Timing(t *testing.T) {
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+		config: networkConfig{
+			serverID: "server-1",
+		},
+		scheduler: newScheduler(1),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		time.Sleep(10 * time.Millisecond)
+		return models.TaskResult{}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	go func() {
+		clientConn.Write([]byte(`{"command":["a"]}` + "\n"))
+		clientConn.Write([]byte(`{"command":["b"]}` + "\n"))
+		clientConn.Write([]byte(`{"command":["c"],"correlation_id":"deploy-42"}` + "\n"))
+	}()
+
+	clientConn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	decoder := json.NewDecoder(bufio.NewReader(clientConn))
+
+	results := make([]models.TaskResult, 3)
+	for i := range results {
+		err := decoder.Decode(&results[i])
+		assert.Nil(t, err, "Every request should be answered")
+	}
+
+	assert.NotEmpty(t, results[0].CorrelationID, "Results should carry the correlation ID of the connection")
+	assert.Equal(t, results[0].CorrelationID, results[1].CorrelationID, "Requests of a connection should share its correlation ID")
+	assert.Equal(t, "deploy-42", results[2].CorrelationID, "The correlation ID chosen by the client should be used")
+
+	for _, result := range results {
+		assert.Equal(t, "server-1", result.ServerID, "Results should carry the server ID")
+		assert.GreaterOrEqual(t, result.QueueWaitMs, 0.0, "Results should report the queue wait")
+		assert.NotZero(t, result.StartedAtNs, "Results should report when the command started")
+		assert.GreaterOrEqual(t, result.FinishedAtNs-result.StartedAtNs, int64(10*time.Millisecond), "Results should report when the command finished")
+	}
+}
+
+func TestGateway_SUCCESS_Request_ID_Header(t *testing.T) {
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{}
+	}
+
+	handler := newGateway(gatewayConfig{serverID: "server-1", maxRequestSize: 1024}, newScheduler(1), callback, zap.NewNop().Sugar()).routes()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"command":["echo"]}`))
+	request.Header.Set(headerRequestID, "deploy-42")
+	handler.ServeHTTP(recorder, request)
+
+	var result models.TaskResult
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+
+	assert.Nil(t, err, "The response body should be a valid TaskResult")
+	assert.Equal(t, "deploy-42", result.CorrelationID, "The ID of the X-Request-ID header should be used")
+	assert.Equal(t, "deploy-42", recorder.Header().Get(headerRequestID), "The correlation ID should be echoed in the response")
+	assert.Equal(t, "server-1", result.ServerID, "The result should carry the server ID")
+	assert.NotZero(t, result.StartedAtNs, "The result should report when the command started")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// scheduler limits how many requests are executed at the same time across all connections.
+// Requests waiting for a free slot are admitted in arrival order.
+type scheduler struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting *list.List
+
+	// metrics records how long requests wait for a slot, when not nil.
+	metrics *metrics
+}
+
+func newScheduler(limit int) *scheduler {
+	return &scheduler{
+		limit:   limit,
+		waiting: list.New(),
+	}
+}
+
+// acquire blocks until a slot is free or ctx is done. Every successful acquire must be
+// followed by a release.
+func (s *scheduler) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.active < s.limit && s.waiting.Len() == 0 {
+		s.active++
+		s.mu.Unlock()
+		s.metrics.observeWait(semaphoreRequests, 0)
+		return nil
+	}
+
+	waitStart := time.Now()
+
+	ready := make(chan struct{})
+	waiter := s.waiting.PushBack(ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		s.metrics.observeWait(semaphoreRequests, time.Since(waitStart))
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// The slot was granted while giving up, hand it to the next in line.
+			s.mu.Unlock()
+			s.release()
+		default:
+			s.waiting.Remove(waiter)
+			s.mu.Unlock()
+		}
+
+		return ctx.Err()
+	}
+}
+
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active--
+	s.admit()
+}
+
+// admit hands free slots to the oldest waiters. Must be called with s.mu held.
+func (s *scheduler) admit() {
+	for s.active < s.limit && s.waiting.Len() > 0 {
+		front := s.waiting.Front()
+		s.waiting.Remove(front)
+		s.active++
+		close(front.Value.(chan struct{}))
+	}
+}
+
+// usage returns the slots in use, the limit, and how many requests are waiting for a slot.
+func (s *scheduler) usage() (active int, limit int, waiting int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.active, s.limit, s.waiting.Len()
+}
+
+// setLimit changes how many requests are executed at the same time. Requests already running
+// keep their slot when the limit is lowered.
+func (s *scheduler) setLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limit = limit
+	s.admit()
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler_SUCCESS_Limit(t *testing.T) {
+	t.Parallel()
+	scheduler := newScheduler(2)
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := scheduler.acquire(context.Background())
+			assert.Nil(t, err, "Acquiring a slot without deadline should not return error")
+
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+
+			scheduler.release()
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 2, maxActive, "The scheduler should never run more requests than its limit")
+	assert.Equal(t, 0, scheduler.active, "Every slot should've been released")
+}
+
+func TestScheduler_SUCCESS_Arrival_Order(t *testing.T) {
+	t.Parallel()
+	scheduler := newScheduler(1)
+
+	err := scheduler.acquire(context.Background())
+	assert.Nil(t, err, "Acquiring a free slot should not return error")
+
+	admitted := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			scheduler.acquire(context.Background())
+			admitted <- i
+			scheduler.release()
+		}(i)
+
+		// Make sure the waiters are queued in order
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	scheduler.release()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, i, <-admitted, "Waiting requests should be admitted in arrival order")
+	}
+}
+
+func TestScheduler_ERROR_Context_Cancelled(t *testing.T) {
+	t.Parallel()
+	scheduler := newScheduler(1)
+
+	err := scheduler.acquire(context.Background())
+	assert.Nil(t, err, "Acquiring a free slot should not return error")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = scheduler.acquire(ctx)
+	assert.NotNil(t, err, "Acquiring a slot should fail when the context is done")
+	assert.Equal(t, 0, scheduler.waiting.Len(), "A cancelled request should leave the queue")
+
+	scheduler.release()
+	assert.Equal(t, 0, scheduler.active, "Every slot should've been released")
+}
+
+func TestScheduler_SUCCESS_Set_Limit(t *testing.T) {
+	t.Parallel()
+	scheduler := newScheduler(1)
+	scheduler.acquire(context.Background())
+
+	admitted := make(chan struct{})
+	go func() {
+		scheduler.acquire(context.Background())
+		close(admitted)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_, _, waiting := scheduler.usage()
+	assert.Equal(t, 1, waiting, "The second request should wait for a slot")
+
+	scheduler.setLimit(2)
+
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("Raising the limit should admit the waiting requests")
+	}
+
+	scheduler.setLimit(1)
+	active, limit, _ := scheduler.usage()
+	assert.Equal(t, 2, active, "Lowering the limit should not stop the requests running")
+	assert.Equal(t, 1, limit, "The new limit should be reported")
+}
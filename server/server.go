@@ -2,9 +2,21 @@ package server
 
 import (
 	"context"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/taskpb"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	// Lets gRPC clients compress their calls with gzip
+	_ "google.golang.org/grpc/encoding/gzip"
 )
 
 type Server struct {
@@ -12,9 +24,44 @@ type Server struct {
 	addr     string
 	protocol string
 	maxConn  int
+	serverID string
+
+	maxRequests          int
+	maxRequestSize       int
+	maxPipelinedRequests int
+	compressionThreshold int
+
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	drainTimeout time.Duration
+
+	network   Network
+	listener  Listener
+	scheduler *scheduler
+	jobs      *jobRegistry
+	metrics   *metrics
+	activity  *activity
+	redactor  *redactor
+	tasks     *taskResolver
+	auth      *clientAuth
+
+	httpAddr     string
+	httpListener net.Listener
 
-	network  Network
-	listener Listener
+	grpcAddr     string
+	grpcListener net.Listener
+
+	metricsAddr     string
+	metricsListener net.Listener
+
+	adminAddr     string
+	admin         *admin
+	adminListener net.Listener
+
+	// policy is read by every request, Reload replaces it.
+	policy atomic.Pointer[Policy]
+	audit  *zap.SugaredLogger
 }
 
 type ServerConfig struct {
@@ -22,10 +69,72 @@ type ServerConfig struct {
 	Addr     string
 	Protocol string
 	MaxConn  int
+
+	// ServerID identifies this instance in the results it returns. Defaults to the hostname.
+	ServerID string
+
+	// MaxRequests is the number of requests executed at the same time across all connections.
+	// Defaults to MaxConn.
+	MaxRequests int
+	// MaxPipelinedRequests is the number of requests with an ID a single connection can have in flight.
+	MaxPipelinedRequests int
+
+	// MaxRequestSize is the largest request, in bytes, accepted from a client.
+	// Connections sending bigger requests receive an error and are closed.
+	MaxRequestSize int
+
+	// CompressionThreshold is the smallest response, in bytes, compressed on connections that negotiated compression.
+	CompressionThreshold int
+
+	// IdleTimeout closes connections that don't start a new request in time. Idle, read and write
+	// timeouts use their default when 0, and are disabled when negative.
+	IdleTimeout time.Duration
+	// ReadTimeout limits how long a client can take to send a request once it started.
+	ReadTimeout time.Duration
+	// WriteTimeout limits how long a client can take to receive a response.
+	WriteTimeout time.Duration
+	// DrainTimeout limits how long the server waits for open connections to finish when it stops.
+	DrainTimeout time.Duration
+
+	// HTTPAddr enables the HTTP gateway on this address (e.g. localhost:8080) when not empty.
+	HTTPAddr string
+	// GRPCAddr enables the gRPC TaskService on this address (e.g. localhost:9090) when not empty.
+	GRPCAddr string
+
+	// MetricsAddr serves Prometheus metrics on /metrics at this address (e.g. localhost:9100) when not empty.
+	MetricsAddr string
+	// MetricsMaxCommands is the number of distinct command basenames used as metric labels,
+	// further commands are labelled as "other". Defaults to 100.
+	MetricsMaxCommands int
+
+	// AdminAddr serves the admin API, listing connections, processes and the queue, at this address
+	// (e.g. localhost:9200) when not empty. It can kill connections and requests, keep it private.
+	AdminAddr string
+	// AdminToken is required as a bearer token by the admin API when not empty.
+	AdminToken string
+	// ClientToken is required from every client when not empty: TCP clients send it in their hello,
+	// the HTTP gateway and gRPC clients as a bearer token.
+	ClientToken string
+
+	// Policy restricts the commands clients can run, every command is allowed by default and shell
+	// mode is disabled.
+	Policy Policy
+	// AuditLogger records every request checked by the policy, with the command or the shell script
+	// sent by the client. Nothing is recorded when nil.
+	AuditLogger *zap.SugaredLogger
+
+	// Redaction hides secrets of the requests and results from the logs and the admin API, nothing is
+	// hidden by default.
+	Redaction Redaction
+
+	// Tasks are the command lines clients can run by name, with their parameters.
+	Tasks Tasks
+	// TasksOnly rejects the requests sending a command instead of the name of a task.
+	TasksOnly bool
 }
 
-// NewServer create a new instance of server
-func NewServer(config ServerConfig) (*Server, error) {
+// withDefaults fills the settings left empty with their default.
+func (config ServerConfig) withDefaults() ServerConfig {
 	if config.Protocol == "" {
 		config.Protocol = "tcp"
 	}
@@ -42,14 +151,128 @@ func NewServer(config ServerConfig) (*Server, error) {
 		config.Addr = "0.0.0.0"
 	}
 
+	if config.ServerID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "unknown"
+		}
+		config.ServerID = hostname
+	}
+
+	if config.MaxRequests <= 0 {
+		config.MaxRequests = config.MaxConn
+	}
+
+	if config.MaxPipelinedRequests <= 0 {
+		config.MaxPipelinedRequests = 16
+	}
+
+	if config.MaxRequestSize <= 0 {
+		config.MaxRequestSize = 1 << 20
+	}
+
+	if config.CompressionThreshold <= 0 {
+		config.CompressionThreshold = common.DefaultCompressionThreshold
+	}
+
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 2 * time.Minute
+	}
+
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = 30 * time.Second
+	}
+
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 30 * time.Second
+	}
+
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = 30 * time.Second
+	}
+
+	if config.MetricsMaxCommands <= 0 {
+		config.MetricsMaxCommands = 100
+	}
+
+	return config
+}
+
+// networkConfig returns the settings of the TCP connections.
+func (config ServerConfig) networkConfig() networkConfig {
+	return networkConfig{
+		serverID:             config.ServerID,
+		maxRequestSize:       config.MaxRequestSize,
+		maxPipelinedRequests: config.MaxPipelinedRequests,
+		compressionThreshold: config.CompressionThreshold,
+
+		idleTimeout:  config.IdleTimeout,
+		readTimeout:  config.ReadTimeout,
+		writeTimeout: config.WriteTimeout,
+		drainTimeout: config.DrainTimeout,
+	}
+}
+
+// NewServer create a new instance of server
+func NewServer(config ServerConfig) (*Server, error) {
+	config = config.withDefaults()
+
+	metrics := newMetrics(config.MetricsMaxCommands)
+	scheduler := newScheduler(config.MaxRequests)
+	scheduler.metrics = metrics
+	activity := newActivity()
+
+	if err := config.Policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	redactor, err := newRedactor(config.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	activity.redactor = redactor
+
+	tasks, err := newTaskResolver(config.Tasks, config.TasksOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := newClientAuth(config.ClientToken)
+
 	newServer := Server{
 		port:     config.Port,
 		addr:     config.Addr,
 		protocol: config.Protocol,
 		maxConn:  config.MaxConn,
+		serverID: config.ServerID,
+
+		maxRequests:          config.MaxRequests,
+		maxRequestSize:       config.MaxRequestSize,
+		maxPipelinedRequests: config.MaxPipelinedRequests,
+		compressionThreshold: config.CompressionThreshold,
+
+		idleTimeout:  config.IdleTimeout,
+		readTimeout:  config.ReadTimeout,
+		writeTimeout: config.WriteTimeout,
+		drainTimeout: config.DrainTimeout,
+
+		network:   newNetwork(config.networkConfig(), scheduler, metrics, activity, redactor, auth),
+		scheduler: scheduler,
+		jobs:      newJobRegistry(),
+		metrics:   metrics,
+		activity:  activity,
+		redactor:  redactor,
+		tasks:     tasks,
+		auth:      auth,
 
-		network: newNetwork(),
+		httpAddr:    config.HTTPAddr,
+		grpcAddr:    config.GRPCAddr,
+		metricsAddr: config.MetricsAddr,
+		adminAddr:   config.AdminAddr,
+		admin:       newAdmin(activity, config.AdminToken, zap.NewNop().Sugar()),
 	}
+	newServer.policy.Store(&config.Policy)
+	newServer.audit = config.AuditLogger
 
 	newListener, err := newListener(newServer.port, newServer.addr, newServer.protocol)
 	if err != nil {
@@ -58,9 +281,63 @@ func NewServer(config ServerConfig) (*Server, error) {
 
 	newServer.listener = newListener
 
+	// Release the ports already opened when a later listener fails
+	listening := false
+	defer func() {
+		if !listening {
+			newServer.closeListeners()
+		}
+	}()
+
+	if newServer.httpAddr != "" {
+		httpListener, err := net.Listen("tcp", newServer.httpAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		newServer.httpListener = httpListener
+	}
+
+	if newServer.grpcAddr != "" {
+		grpcListener, err := net.Listen("tcp", newServer.grpcAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		newServer.grpcListener = grpcListener
+	}
+
+	if newServer.metricsAddr != "" {
+		metricsListener, err := net.Listen("tcp", newServer.metricsAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		newServer.metricsListener = metricsListener
+	}
+
+	if newServer.adminAddr != "" {
+		adminListener, err := net.Listen("tcp", newServer.adminAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		newServer.adminListener = adminListener
+	}
+
+	listening = true
 	return &newServer, nil
 }
 
+// closeListeners closes every listener opened by NewServer.
+func (server *Server) closeListeners() {
+	for _, listener := range []io.Closer{server.listener, server.httpListener, server.grpcListener, server.metricsListener, server.adminListener} {
+		if listener != nil {
+			listener.Close()
+		}
+	}
+}
+
 // Start initializes the TCP server to listen for incoming connections and handle them concurrently,
 // using the provided context and callback function for processing requests.
 func (server *Server) Start(ctx context.Context, callback func(ctx context.Context, req []byte) interface{}) {
@@ -69,26 +346,174 @@ func (server *Server) Start(ctx context.Context, callback func(ctx context.Conte
 		logger = zap.NewNop().Sugar()
 	}
 
+	// Every transport shares the callback, so requests are resolved, checked and measured the same way on all of them
+	callback = server.metrics.instrument(server.tasks.resolve(enforce(&server.policy, server.audit, callback)))
+
 	logger.Infow("Server Listening", "Port", server.port, "Protocol", server.protocol, "Address", server.addr)
 	var semaphore = make(chan int, server.maxConn)
+	health := newHealth(server.scheduler, semaphore, logger)
 	go func() {
 		for {
 			conn, err := server.listener.Accept()
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				logger.Warnw("Error accepting connection", "Error", err)
 				continue
 			}
+			server.metrics.connectionAccepted()
+
+			// The accept span covers the wait for a free connection slot, it is ended by HandleConnection
+			connCtx, _ := tracer().Start(ctx, spanAccept, trace.WithSpanKind(trace.SpanKindServer))
+
+			waitStart := time.Now()
 			semaphore <- 1
+			server.metrics.observeWait(semaphoreConnections, time.Since(waitStart))
+
 			go func(conn net.Conn) {
 				defer conn.Close()
+				server.metrics.connectionOpened()
+				defer server.metrics.connectionClosed()
 
-				server.network.HandleConnection(ctx, conn, callback)
+				server.network.HandleConnection(connCtx, conn, callback)
 
 				<-semaphore
 			}(conn)
 		}
 	}()
 
+	if server.httpListener != nil {
+		gateway := newGateway(gatewayConfig{
+			serverID:             server.serverID,
+			maxRequestSize:       server.maxRequestSize,
+			maxPipelinedRequests: server.maxPipelinedRequests,
+		}, server.scheduler, callback, logger)
+		gateway.activity = server.activity
+		gateway.redactor = server.redactor
+		gateway.auth = server.auth
+
+		httpServer := &http.Server{
+			Handler: health.routes(gateway.routes()),
+		}
+
+		logger.Infow("HTTP Gateway Listening", "Address", server.httpAddr)
+		go func() {
+			if err := httpServer.Serve(server.httpListener); err != nil && err != http.ErrServerClosed {
+				logger.Errorw("Error serving HTTP gateway", "Error", err)
+			}
+		}()
+		defer httpServer.Close()
+	}
+
+	if server.grpcListener != nil {
+		grpcServer := grpc.NewServer(
+			grpc.UnaryInterceptor(server.auth.unaryInterceptor),
+			grpc.StreamInterceptor(server.auth.streamInterceptor),
+		)
+		grpcService := newGRPCService(server.scheduler, server.jobs, server.serverID, callback, logger)
+		grpcService.activity = server.activity
+		grpcService.redactor = server.redactor
+		taskpb.RegisterTaskServiceServer(grpcServer, grpcService)
+
+		logger.Infow("gRPC Server Listening", "Address", server.grpcAddr)
+		go func() {
+			if err := grpcServer.Serve(server.grpcListener); err != nil {
+				logger.Errorw("Error serving gRPC", "Error", err)
+			}
+		}()
+		defer grpcServer.Stop()
+	}
+
+	if server.metricsListener != nil {
+		metricsServer := &http.Server{
+			Handler: health.routes(server.metrics.routes()),
+		}
+
+		logger.Infow("Metrics Listening", "Address", server.metricsAddr)
+		go func() {
+			if err := metricsServer.Serve(server.metricsListener); err != nil && err != http.ErrServerClosed {
+				logger.Errorw("Error serving metrics", "Error", err)
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	// The admin API keeps running while the server drains, to kill what holds it up
+	if server.adminListener != nil {
+		server.admin.logger = logger
+		adminServer := &http.Server{
+			Handler: server.admin.routes(),
+		}
+
+		logger.Infow("Admin Listening", "Address", server.adminAddr)
+		go func() {
+			if err := adminServer.Serve(server.adminListener); err != nil && err != http.ErrServerClosed {
+				logger.Errorw("Error serving admin", "Error", err)
+			}
+		}()
+		defer adminServer.Close()
+	}
+
 	<-ctx.Done()
+
+	// Stop accepting connections and let the open ones finish, reporting the server as not ready meanwhile
+	health.draining.Store(true)
+	server.listener.Close()
+
+	logger.Infow("Server draining", "Connections", len(semaphore), "DrainTimeout", server.drainTimeout)
+	if !server.drain(semaphore) {
+		logger.Warnw("Drain timeout exceeded, stopping with open connections", "Connections", len(semaphore))
+	}
+
 	logger.Infow("Server has stopped")
 }
+
+// Reload applies the settings that can change while the server runs without dropping connections:
+// the request limit, the policy, the tasks, the redaction and the admin and client tokens apply right away, the
+// request size, pipelining, compression threshold and timeouts apply to the next TCP connections. Other
+// settings are ignored. Nothing is applied when the policy, the redaction or the tasks are invalid.
+func (server *Server) Reload(config ServerConfig) error {
+	config = config.withDefaults()
+	config.ServerID = server.serverID
+
+	if err := config.Policy.Validate(); err != nil {
+		return err
+	}
+	if err := config.Redaction.Validate(); err != nil {
+		return err
+	}
+	if err := server.tasks.set(config.Tasks, config.TasksOnly); err != nil {
+		return err
+	}
+	server.redactor.set(config.Redaction)
+
+	server.scheduler.setLimit(config.MaxRequests)
+	if network, ok := server.network.(*networkImpl); ok {
+		network.setConfig(config.networkConfig())
+	}
+	server.admin.setToken(config.AdminToken)
+	server.auth.set(config.ClientToken)
+	server.policy.Store(&config.Policy)
+
+	return nil
+}
+
+// drain waits until the connections holding the semaphore are closed, or the drain timeout is exceeded.
+func (server *Server) drain(semaphore chan int) bool {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(server.drainTimeout)
+	defer timeout.Stop()
+
+	for len(semaphore) > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			return false
+		}
+	}
+
+	return true
+}
@@ -4,8 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -52,9 +57,53 @@ func TestNewServer_SUCCESS_Default_Values(t *testing.T) {
 	assert.Equal(t, 5, server.maxConn, "The default maxConn should've been assigned to 5")
 	assert.Equal(t, 3000, server.port, "The default port should've been assigned to 3000")
 	assert.Equal(t, "0.0.0.0", server.addr, "The default addr should've been assigned to 0.0.0.0")
+	assert.Equal(t, 5, server.maxRequests, "The default maxRequests should've been assigned to maxConn")
+	assert.Equal(t, 16, server.maxPipelinedRequests, "The default maxPipelinedRequests should've been assigned to 16")
+	assert.Equal(t, 1<<20, server.maxRequestSize, "The default maxRequestSize should've been assigned to 1 MiB")
+	assert.Equal(t, 1024, server.compressionThreshold, "The default compressionThreshold should've been assigned to 1 KiB")
+	assert.Equal(t, 2*time.Minute, server.idleTimeout, "The default idleTimeout should've been assigned to 2 minutes")
+	assert.Equal(t, 30*time.Second, server.readTimeout, "The default readTimeout should've been assigned to 30 seconds")
+	assert.Equal(t, 30*time.Second, server.writeTimeout, "The default writeTimeout should've been assigned to 30 seconds")
+	assert.Equal(t, 30*time.Second, server.drainTimeout, "The default drainTimeout should've been assigned to 30 seconds")
+	hostname, _ := os.Hostname()
+	assert.Equal(t, hostname, server.serverID, "The default serverID should've been assigned to the hostname")
 
 }
 
+func TestServerConfig_SUCCESS_Disabled_Timeouts(t *testing.T) {
+	config := ServerConfig{IdleTimeout: -1, ReadTimeout: -1, WriteTimeout: -1}.withDefaults()
+
+	assert.Equal(t, time.Duration(-1), config.IdleTimeout, "A negative idleTimeout should be kept to disable it")
+	assert.Equal(t, time.Duration(-1), config.ReadTimeout, "A negative readTimeout should be kept to disable it")
+	assert.Equal(t, time.Duration(-1), config.WriteTimeout, "A negative writeTimeout should be kept to disable it")
+	assert.True(t, deadline(config.IdleTimeout).IsZero(), "Disabled timeouts should not set a deadline")
+}
+
+func TestNewServer_ERROR_Listener_Releases_Ports(t *testing.T) {
+	taken, err := net.Listen("tcp", "localhost:0")
+	assert.Nil(t, err, "Opening a port should not return error")
+	defer taken.Close()
+
+	port := randomPort()
+	httpAddr := fmt.Sprintf("localhost:%v", port+1000)
+
+	_, err = NewServer(ServerConfig{
+		Port:      port,
+		Addr:      "localhost",
+		HTTPAddr:  httpAddr,
+		AdminAddr: taken.Addr().String(),
+	})
+	assert.NotNil(t, err, "A listener failing should fail the server")
+
+	for _, addr := range []string{fmt.Sprintf("localhost:%v", port), httpAddr} {
+		listener, err := net.Listen("tcp", addr)
+		assert.Nil(t, err, "The port "+addr+" should've been released")
+		if listener != nil {
+			listener.Close()
+		}
+	}
+}
+
 func TestNewServer_SUCCESS_Invalid_Addr(t *testing.T) {
 	port := randomPort()
 	address := "--invalid--"
@@ -70,6 +119,47 @@ func TestNewServer_SUCCESS_Invalid_Addr(t *testing.T) {
 	assert.NotNil(t, err, "Invalid configuration should return an error")
 }
 
+func TestReload_SUCCESS(t *testing.T) {
+	server, err := NewServer(ServerConfig{
+		Port:     randomPort(),
+		Addr:     "localhost",
+		ServerID: "server-1",
+		MaxConn:  4,
+	})
+	assert.Nil(t, err, "Opening server connection should not return error")
+	defer server.listener.Close()
+
+	err = server.Reload(ServerConfig{
+		ServerID:       "server-2",
+		MaxConn:        4,
+		MaxRequests:    2,
+		MaxRequestSize: 512,
+		IdleTimeout:    time.Second,
+		AdminToken:     "secret",
+		Policy:         Policy{DeniedCommands: []string{"rm"}},
+		Redaction:      Redaction{Fields: []string{RedactOutput}},
+	})
+	assert.Nil(t, err, "A valid configuration should be reloaded")
+
+	_, limit, _ := server.scheduler.usage()
+	assert.Equal(t, 2, limit, "The request limit should be reloaded")
+
+	config := server.network.(*networkImpl).currentConfig()
+	assert.Equal(t, 512, config.maxRequestSize, "The request size of the next connections should be reloaded")
+	assert.Equal(t, time.Second, config.idleTimeout, "The timeouts of the next connections should be reloaded")
+	assert.Equal(t, 30*time.Second, config.readTimeout, "Settings left empty should be reloaded with their default")
+	assert.Equal(t, "server-1", config.serverID, "The server ID should not change until a restart")
+
+	assert.Equal(t, "secret", *server.admin.token.Load(), "The admin token should be reloaded")
+	assert.Equal(t, []string{"rm"}, server.policy.Load().DeniedCommands, "The policy should be reloaded")
+	assert.True(t, server.redactor.load().fields[RedactOutput], "The redaction should be reloaded")
+
+	err = server.Reload(ServerConfig{MaxConn: 4, MaxRequests: 3, Redaction: Redaction{Patterns: []string{"("}}})
+	assert.NotNil(t, err, "An invalid redaction pattern should not be reloaded")
+	_, limit, _ = server.scheduler.usage()
+	assert.Equal(t, 2, limit, "Nothing should be reloaded from an invalid configuration")
+}
+
 func TestStart_SUCCESS(t *testing.T) {
 	port := randomPort()
 	address := "localhost"
@@ -82,9 +172,9 @@ func TestStart_SUCCESS(t *testing.T) {
 	})
 	assert.Nil(t, err, "Opening server connection should not return error")
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -109,7 +199,7 @@ func TestStart_SUCCESS(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 
-	assert.Equal(t, callbackWasCalled, true, "Expected callback to be called at least one time")
+	assert.Equal(t, callbackWasCalled.Load(), true, "Expected callback to be called at least one time")
 
 	cancel()
 }
@@ -126,9 +216,9 @@ func TestStart_ERROR_Client_lost_connection(t *testing.T) {
 	})
 	assert.Nil(t, err, "Opening server connection should not return error")
 
-	callbackWasCalled := false
+	var callbackWasCalled atomic.Bool
 	callback := func(ctx context.Context, req []byte) interface{} {
-		callbackWasCalled = true
+		callbackWasCalled.Store(true)
 		return nil
 	}
 
@@ -141,7 +231,7 @@ func TestStart_ERROR_Client_lost_connection(t *testing.T) {
 	conn.Close()
 	time.Sleep(1 * time.Second)
 
-	assert.Equal(t, callbackWasCalled, false, "Expected callback not be called")
+	assert.Equal(t, callbackWasCalled.Load(), false, "Expected callback not be called")
 
 	defer cancel()
 }
@@ -176,11 +266,153 @@ func TestStart_ERROR_On_Accept_Listener(t *testing.T) {
 	time.Sleep(300 * time.Millisecond)
 	cancel()
 
-	assert.GreaterOrEqual(t, mockListener.onAcceptCount, 1, "The Accept() function should've been called at least 1 time")
-	assert.Equal(t, mockNetwork.onHandleConnectionCount, 0, "The handleConnection should've been called 0 times")
+	assert.GreaterOrEqual(t, mockListener.onAcceptCount(), 1, "The Accept() function should've been called at least 1 time")
+	assert.Equal(t, mockNetwork.onHandleConnectionCount(), 0, "The handleConnection should've been called 0 times")
 }
 
 func randomPort() int {
 	rand.Seed(time.Now().UnixNano())
 	return rand.Intn(2001) + 3000
 }
+
+func TestStart_SUCCESS_HTTP_Gateway(t *testing.T) {
+	port := randomPort()
+	httpPort := port + 1000
+	address := "localhost"
+
+	server, err := NewServer(ServerConfig{
+		Port:     port,
+		Addr:     address,
+		HTTPAddr: fmt.Sprintf("%s:%v", address, httpPort),
+	})
+	assert.Nil(t, err, "Opening server connection should not return error")
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{Output: "gateway"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, callback)
+
+	time.Sleep(300 * time.Millisecond)
+
+	response, err := http.Post(fmt.Sprintf("http://%s:%v/tasks", address, httpPort), "application/json", strings.NewReader(`{"command":["echo"]}`))
+	assert.Nil(t, err, "Sending a request to the HTTP gateway should not return error")
+	defer response.Body.Close()
+
+	var result models.TaskResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	assert.Nil(t, err, "The HTTP gateway should answer with a TaskResult")
+	assert.Equal(t, "gateway", result.Output, "The HTTP gateway should use the same callback as the TCP server")
+}
+
+func TestStart_SUCCESS_Metrics(t *testing.T) {
+	port := randomPort()
+	metricsPort := port + 1000
+	address := "localhost"
+
+	server, err := NewServer(ServerConfig{
+		Port:        port,
+		Addr:        address,
+		MetricsAddr: fmt.Sprintf("%s:%v", address, metricsPort),
+	})
+	assert.Nil(t, err, "Opening server connection should not return error")
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{Command: []string{"echo"}, Output: "metrics"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Start(ctx, callback)
+
+	time.Sleep(300 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%v", address, port))
+	assert.Nil(t, err, "Opening client connection should not return error")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"command":["echo"]}` + "\n"))
+	assert.Nil(t, err, "writing request to connection should not return error")
+
+	response := make([]byte, 1024)
+	_, err = conn.Read(response)
+	assert.Nil(t, err, "The request should be answered before scraping the metrics")
+
+	scrape, err := http.Get(fmt.Sprintf("http://%s:%v/metrics", address, metricsPort))
+	assert.Nil(t, err, "Scraping the metrics should not return error")
+	defer scrape.Body.Close()
+
+	body, err := io.ReadAll(scrape.Body)
+	assert.Nil(t, err, "Reading the metrics should not return error")
+	assert.Contains(t, string(body), "sumologic_server_connections_accepted_total 1", "Accepted connections should be counted")
+	assert.Contains(t, string(body), "sumologic_server_connections_active 1", "Connections being served should be counted")
+	assert.Contains(t, string(body), `sumologic_server_requests_total{command="echo",outcome="success"} 1`, "Requests should be counted by command and outcome")
+}
+
+func TestStart_SUCCESS_Draining(t *testing.T) {
+	port := randomPort()
+	httpPort := port + 1000
+	address := "localhost"
+
+	server, err := NewServer(ServerConfig{
+		Port:     port,
+		Addr:     address,
+		HTTPAddr: fmt.Sprintf("%s:%v", address, httpPort),
+	})
+	assert.Nil(t, err, "Opening server connection should not return error")
+
+	release := make(chan struct{})
+	callback := func(ctx context.Context, req []byte) interface{} {
+		<-release
+		return models.TaskResult{Output: "drained"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		server.Start(ctx, callback)
+		close(stopped)
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+
+	readyz := fmt.Sprintf("http://%s:%v/readyz", address, httpPort)
+	response, err := http.Get(readyz)
+	assert.Nil(t, err, "Probing readiness should not return error")
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode, "An idle server should be ready")
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%v", address, port))
+	assert.Nil(t, err, "Opening client connection should not return error")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(`{"command":["sleep"]}` + "\n"))
+	assert.Nil(t, err, "writing request to connection should not return error")
+
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	time.Sleep(300 * time.Millisecond)
+
+	response, err = http.Get(readyz)
+	assert.Nil(t, err, "Probing readiness while draining should not return error")
+	var health models.Health
+	json.NewDecoder(response.Body).Decode(&health)
+	response.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode, "A draining server should not be ready")
+	assert.True(t, health.Draining, "The server should report it is draining")
+
+	close(release)
+
+	var result models.TaskResult
+	err = json.NewDecoder(conn).Decode(&result)
+	assert.Nil(t, err, "The request in flight should be answered while draining")
+	assert.Equal(t, "drained", result.Output, "The request in flight should finish")
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("The server should stop once its connections are closed")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+const outputWriterCtxKey = "outputWriter"
+
+// OutputWriter receives the output of a command while it is running. It is called
+// concurrently for stdout and stderr.
+type OutputWriter func(chunk models.OutputChunk)
+
+// WithOutputWriter returns a context asking the callback to stream the command output to writer.
+func WithOutputWriter(ctx context.Context, writer OutputWriter) context.Context {
+	return context.WithValue(ctx, outputWriterCtxKey, writer)
+}
+
+// OutputWriterFromContext returns the OutputWriter of a streaming request, if any.
+func OutputWriterFromContext(ctx context.Context) (OutputWriter, bool) {
+	writer, ok := ctx.Value(outputWriterCtxKey).(OutputWriter)
+	return writer, ok && writer != nil
+}
+
+// NewOutputStream adapts an OutputWriter to an io.Writer for one of the streams of a command.
+// Chunks are encoded like the final output, see models.EncodeOutput.
+func NewOutputStream(writer OutputWriter, id string, stream string, encoding string) io.Writer {
+	return &outputStream{
+		writer:   writer,
+		id:       id,
+		stream:   stream,
+		encoding: encoding,
+	}
+}
+
+type outputStream struct {
+	writer   OutputWriter
+	id       string
+	stream   string
+	encoding string
+}
+
+func (s *outputStream) Write(p []byte) (int, error) {
+	data, encoding := models.EncodeOutput(p, s.encoding)
+
+	chunk := models.OutputChunk{
+		Type:   models.MessageTypeOutput,
+		ID:     s.id,
+		Stream: s.stream,
+		Data:   data,
+	}
+	if encoding == models.OutputEncodingBase64 {
+		chunk.Encoding = encoding
+	}
+
+	s.writer(chunk)
+
+	return len(p), nil
+}
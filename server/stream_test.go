@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputStream_UTF8(t *testing.T) {
+	var chunks []models.OutputChunk
+	stream := NewOutputStream(func(chunk models.OutputChunk) { chunks = append(chunks, chunk) }, "a", models.StreamStdout, "")
+
+	n, err := stream.Write([]byte("hello"))
+	assert.NoError(t, err, "Writing to the stream should not fail")
+	assert.Equal(t, 5, n, "All bytes should be written")
+	assert.Equal(t, []models.OutputChunk{{Type: models.MessageTypeOutput, ID: "a", Stream: models.StreamStdout, Data: "hello"}}, chunks, "Text output should be sent as is")
+}
+
+func TestOutputStream_Base64Requested(t *testing.T) {
+	var chunks []models.OutputChunk
+	stream := NewOutputStream(func(chunk models.OutputChunk) { chunks = append(chunks, chunk) }, "a", models.StreamStdout, models.OutputEncodingBase64)
+
+	stream.Write([]byte("hello"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello")), chunks[0].Data, "Output should be base64 encoded when requested")
+	assert.Equal(t, models.OutputEncodingBase64, chunks[0].Encoding, "The chunk should be flagged as base64")
+}
+
+func TestOutputStream_InvalidUTF8(t *testing.T) {
+	var chunks []models.OutputChunk
+	stream := NewOutputStream(func(chunk models.OutputChunk) { chunks = append(chunks, chunk) }, "a", models.StreamStderr, models.OutputEncodingUTF8)
+
+	binary := []byte{0xff, 0x00, 0xfe}
+	stream.Write(binary)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(binary), chunks[0].Data, "Binary output should switch to base64")
+	assert.Equal(t, models.OutputEncodingBase64, chunks[0].Encoding, "The chunk should be flagged as base64")
+}
+
+func TestChunkData(t *testing.T) {
+	binary := []byte{0xff, 0x00, 0xfe}
+	encoded := models.OutputChunk{Data: base64.StdEncoding.EncodeToString(binary), Encoding: models.OutputEncodingBase64}
+
+	assert.Equal(t, binary, chunkData(encoded), "gRPC chunks should carry the raw bytes")
+	assert.Equal(t, []byte("hi"), chunkData(models.OutputChunk{Data: "hi"}), "Text chunks should be sent as is")
+}
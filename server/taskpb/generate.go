@@ -0,0 +1,4 @@
+// Package taskpb holds the gRPC definition of the task execution API.
+package taskpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tasks.proto
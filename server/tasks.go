@@ -0,0 +1,451 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+)
+
+// Types of the parameters of a Task.
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+)
+
+var (
+	taskNamePattern  = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// placeholderPattern matches {name} and {name|modifier} in the command line of a task.
+	placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\|([A-Za-z]+))?\}`)
+)
+
+// placeholderQuote quotes the value for a POSIX shell, for tasks running their command with sh -c.
+const placeholderQuote = "quote"
+
+// taskCtxKey holds the name of the task a request runs, once the resolver rendered it.
+const taskCtxKey = "task"
+
+// Task is a command line registered on the server that clients run by name, sending the values
+// of its parameters instead of the command.
+type Task struct {
+	Description string
+	// Command is run by the task. Its arguments can hold {name} placeholders replaced by the value
+	// of the parameter: no shell is involved, so a value stays within its argument whatever it holds.
+	// An argument that is only the placeholder of a parameter without value is left out, and
+	// {name|quote} quotes the value for the scripts given to a shell.
+	Command []string
+	Params  map[string]TaskParam
+	// Env and Cwd are the environment added to the command and its working directory, clients can't change them.
+	Env map[string]string
+	Cwd string
+	// Timeout is used when the client doesn't send one, and is the longest it can ask for. No limit when 0.
+	Timeout time.Duration
+	// MaxConcurrent is the number of requests of the task that can run at once, no limit when 0.
+	MaxConcurrent int
+}
+
+// TaskParam validates a parameter of a Task.
+type TaskParam struct {
+	Description string
+	// Type is string (default), int or bool.
+	Type     string
+	Required bool
+	// Default is used when the client doesn't send the parameter.
+	Default string
+	// Enum, when not empty, lists the only values accepted.
+	Enum []string
+	// Pattern is a regular expression the whole value must match. String values can't start
+	// with '-' without a pattern, so they are never taken as an option of the command.
+	Pattern string
+	// MaxLength limits the number of characters of string values, when > 0.
+	MaxLength int
+	// Min and Max bound int values, when set.
+	Min *int
+	Max *int
+}
+
+// Tasks is the registry of tasks, by name.
+type Tasks map[string]Task
+
+// Validate returns the errors of every invalid task, so they can all be fixed at once.
+func (tasks Tasks) Validate() error {
+	_, err := tasks.compile(false)
+	return err
+}
+
+func (tasks Tasks) compile(only bool) (*taskRegistry, error) {
+	registry := &taskRegistry{tasks: map[string]*compiledTask{}, only: only}
+
+	var errs []error
+	for name, task := range tasks {
+		compiled, err := compileTask(name, task)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("task %q: %w", name, err))
+			continue
+		}
+		registry.tasks[name] = compiled
+	}
+
+	// Map iteration order is random, keep the errors stable
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+type taskRegistry struct {
+	tasks map[string]*compiledTask
+	// only rejects the requests sending a command instead of the name of a task.
+	only bool
+}
+
+type compiledTask struct {
+	Task
+	name     string
+	patterns map[string]*regexp.Regexp
+}
+
+func compileTask(name string, task Task) (*compiledTask, error) {
+	if !taskNamePattern.MatchString(name) {
+		return nil, errors.New("the name can only have letters, digits, '.', '_' or '-'")
+	}
+
+	if len(task.Command) == 0 || task.Command[0] == "" {
+		return nil, errors.New("the command is mandatory")
+	}
+
+	if task.Timeout < 0 || task.MaxConcurrent < 0 {
+		return nil, errors.New("the timeout and max concurrent can't be negative")
+	}
+
+	compiled := &compiledTask{Task: task, name: name, patterns: map[string]*regexp.Regexp{}}
+
+	for paramName, param := range task.Params {
+		if !paramNamePattern.MatchString(paramName) {
+			return nil, fmt.Errorf("parameter %q: the name can only have letters, digits or '_'", paramName)
+		}
+
+		switch param.Type {
+		case "", ParamString, ParamInt, ParamBool:
+		default:
+			return nil, fmt.Errorf("parameter %q: unknown type %q, it must be string, int or bool", paramName, param.Type)
+		}
+
+		if param.Pattern != "" {
+			pattern, err := regexp.Compile(`^(?:` + param.Pattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: invalid pattern: %w", paramName, err)
+			}
+			compiled.patterns[paramName] = pattern
+		}
+
+		if param.Min != nil && param.Max != nil && *param.Min > *param.Max {
+			return nil, fmt.Errorf("parameter %q: min is greater than max", paramName)
+		}
+
+		if param.Required && param.Default != "" {
+			return nil, fmt.Errorf("parameter %q: a required parameter can't have a default", paramName)
+		}
+
+		if param.Default != "" {
+			if _, err := compiled.validate(paramName, param.Default); err != nil {
+				return nil, fmt.Errorf("invalid default: %w", err)
+			}
+		}
+	}
+
+	for _, arg := range task.Command {
+		for _, placeholder := range placeholderPattern.FindAllStringSubmatch(arg, -1) {
+			if _, ok := task.Params[placeholder[1]]; !ok {
+				return nil, fmt.Errorf("the command uses the undeclared parameter %q", placeholder[1])
+			}
+			if placeholder[2] != "" && placeholder[2] != placeholderQuote {
+				return nil, fmt.Errorf("unknown modifier %q, it must be quote", placeholder[2])
+			}
+		}
+	}
+
+	return compiled, nil
+}
+
+// validate checks the value of a parameter and returns it normalized.
+func (task *compiledTask) validate(name string, value string) (string, error) {
+	param := task.Params[name]
+
+	switch param.Type {
+	case ParamInt:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %q: %q is not an integer", name, value)
+		}
+		if param.Min != nil && number < *param.Min {
+			return "", fmt.Errorf("parameter %q: %d is lower than %d", name, number, *param.Min)
+		}
+		if param.Max != nil && number > *param.Max {
+			return "", fmt.Errorf("parameter %q: %d is greater than %d", name, number, *param.Max)
+		}
+		value = strconv.Itoa(number)
+	case ParamBool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("parameter %q: %q is not a boolean", name, value)
+		}
+		value = strconv.FormatBool(enabled)
+	default:
+		if strings.ContainsRune(value, 0) {
+			return "", fmt.Errorf("parameter %q: the value can't contain NUL characters", name)
+		}
+		if param.MaxLength > 0 && utf8.RuneCountInString(value) > param.MaxLength {
+			return "", fmt.Errorf("parameter %q: the value is longer than %d characters", name, param.MaxLength)
+		}
+		if param.Pattern == "" && strings.HasPrefix(value, "-") {
+			return "", fmt.Errorf("parameter %q: the value can't start with '-'", name)
+		}
+	}
+
+	if len(param.Enum) > 0 && !slices.Contains(param.Enum, value) {
+		return "", fmt.Errorf("parameter %q: %q is not one of %s", name, value, strings.Join(param.Enum, ", "))
+	}
+
+	if pattern, ok := task.patterns[name]; ok && !pattern.MatchString(value) {
+		return "", fmt.Errorf("parameter %q: %q doesn't match %s", name, value, param.Pattern)
+	}
+
+	return value, nil
+}
+
+// render returns the request running the command of the task with the parameters of the client.
+func (task *compiledTask) render(request models.TaskRequest) (models.TaskRequest, error) {
+	if len(request.Command) > 0 || request.Shell != "" || len(request.Env) > 0 || request.Cwd != "" {
+		return request, errors.New("a task request can't set the command, shell, env or cwd")
+	}
+
+	for name := range request.Params {
+		if _, ok := task.Params[name]; !ok {
+			return request, fmt.Errorf("unknown parameter %q for task %q", name, task.name)
+		}
+	}
+
+	values := map[string]string{}
+	for name, param := range task.Params {
+		raw, ok := request.Params[name]
+		if !ok {
+			if param.Required {
+				return request, fmt.Errorf("parameter %q is mandatory", name)
+			}
+			if param.Default != "" {
+				values[name] = param.Default
+			}
+			continue
+		}
+
+		value, err := paramString(raw)
+		if err != nil {
+			return request, fmt.Errorf("parameter %q: %w", name, err)
+		}
+
+		if values[name], err = task.validate(name, value); err != nil {
+			return request, err
+		}
+	}
+
+	timeout := int(task.Timeout.Milliseconds())
+	if request.Timeout > 0 {
+		if timeout > 0 && request.Timeout > timeout {
+			return request, fmt.Errorf("the timeout can't exceed %dms for task %q", timeout, task.name)
+		}
+		timeout = request.Timeout
+	}
+
+	command := make([]string, 0, len(task.Command))
+	for _, arg := range task.Command {
+		// Optional parameters left empty are removed with their argument
+		if whole := placeholderPattern.FindStringSubmatch(arg); whole != nil && whole[0] == arg && whole[2] == "" {
+			if _, ok := values[whole[1]]; !ok {
+				continue
+			}
+		}
+
+		command = append(command, placeholderPattern.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			match := placeholderPattern.FindStringSubmatch(placeholder)
+			if match[2] == placeholderQuote {
+				return shellQuote(values[match[1]])
+			}
+			return values[match[1]]
+		}))
+	}
+
+	request.Command = command
+	request.Env = task.Env
+	request.Cwd = task.Cwd
+	request.Timeout = timeout
+	request.Params = nil
+
+	return request, nil
+}
+
+// paramString converts a parameter decoded by any codec to its text.
+func paramString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return strconv.FormatInt(int64(value), 10), nil
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case float32:
+		return paramString(float64(value))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("must be a string, a number or a boolean")
+	}
+}
+
+// shellQuote quotes value so a POSIX shell reads it as a single word.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// taskResolver runs the named tasks of the registry. The registry can be replaced while the server
+// runs, and a nil *taskResolver lets every request through.
+type taskResolver struct {
+	registry atomic.Pointer[taskRegistry]
+
+	// running counts the requests of each task, to enforce MaxConcurrent. It survives reloads.
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func newTaskResolver(tasks Tasks, only bool) (*taskResolver, error) {
+	resolver := &taskResolver{running: map[string]int{}}
+	if err := resolver.set(tasks, only); err != nil {
+		return nil, err
+	}
+
+	return resolver, nil
+}
+
+// set replaces the registry used by the next requests.
+func (r *taskResolver) set(tasks Tasks, only bool) error {
+	registry, err := tasks.compile(only)
+	if err != nil {
+		return err
+	}
+
+	r.registry.Store(registry)
+	return nil
+}
+
+// resolve wraps a callback to run the requests naming a task with the command line of the task.
+func (r *taskResolver) resolve(callback func(ctx context.Context, req []byte) interface{}) func(ctx context.Context, req []byte) interface{} {
+	if r == nil {
+		return callback
+	}
+
+	return func(ctx context.Context, req []byte) interface{} {
+		codec := CodecFromContext(ctx)
+
+		var request models.TaskRequest
+		if err := codec.Unmarshal(req, &request); err != nil {
+			// Invalid requests are answered by the callback
+			return callback(ctx, req)
+		}
+
+		registry := r.registry.Load()
+		if request.Task == "" {
+			if registry.only {
+				return taskError(request, "Task is mandatory, this server only runs named tasks.")
+			}
+			return callback(ctx, req)
+		}
+
+		task, ok := registry.tasks[request.Task]
+		if !ok {
+			return taskError(request, fmt.Sprintf("unknown task %q", request.Task))
+		}
+
+		rendered, err := task.render(request)
+		if err != nil {
+			return taskError(request, err.Error())
+		}
+
+		if !r.acquire(task) {
+			return taskError(request, fmt.Sprintf("task %q is at its limit of %d concurrent requests", task.name, task.MaxConcurrent))
+		}
+		defer r.release(task)
+
+		data, err := codec.Marshal(rendered)
+		if err != nil {
+			return taskError(request, err.Error())
+		}
+
+		result := callback(context.WithValue(ctx, taskCtxKey, task.name), data)
+		if taskResult, ok := result.(models.TaskResult); ok {
+			taskResult.Task = task.name
+			return taskResult
+		}
+
+		return result
+	}
+}
+
+func (r *taskResolver) acquire(task *compiledTask) bool {
+	if task.MaxConcurrent <= 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running[task.name] >= task.MaxConcurrent {
+		return false
+	}
+
+	r.running[task.name]++
+	return true
+}
+
+func (r *taskResolver) release(task *compiledTask) {
+	if task.MaxConcurrent <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.running[task.name]--
+	if r.running[task.name] <= 0 {
+		delete(r.running, task.name)
+	}
+}
+
+func taskError(request models.TaskRequest, message string) models.TaskResult {
+	return models.TaskResult{
+		ID:       request.ID,
+		Task:     request.Task,
+		ExitCode: exitCodeErrorGeneral,
+		Error:    message,
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func intParam(value int) *int {
+	return &value
+}
+
+var testTasks = Tasks{
+	"disk-usage": {
+		Command: []string{"du", "-sh", "{path}", "--max-depth={depth}"},
+		Params: map[string]TaskParam{
+			"path":  {Required: true, Pattern: `/[\w/.-]*`},
+			"depth": {Type: ParamInt, Default: "1", Min: intParam(0), Max: intParam(5)},
+		},
+		Timeout: 10 * time.Second,
+	},
+	"greet": {
+		Command: []string{"sh", "-c", "echo {message|quote}", "{name}"},
+		Params: map[string]TaskParam{
+			"message": {Required: true, MaxLength: 32},
+			"name":    {},
+		},
+		Env: map[string]string{"LANG": "C"},
+	},
+	"restart": {
+		Command: []string{"systemctl", "restart", "{service}"},
+		Params: map[string]TaskParam{
+			"service": {Required: true, Enum: []string{"nginx", "redis"}},
+		},
+		MaxConcurrent: 1,
+	},
+}
+
+func resolveRequest(t *testing.T, resolver *taskResolver, request string) (models.TaskRequest, models.TaskResult) {
+	var received models.TaskRequest
+	callback := resolver.resolve(func(ctx context.Context, req []byte) interface{} {
+		err := json.Unmarshal(req, &received)
+		assert.Nil(t, err, "The callback should receive a valid request")
+		return models.TaskResult{Command: received.Command}
+	})
+
+	return received, callback(context.Background(), []byte(request)).(models.TaskResult)
+}
+
+func TestTaskResolver_SUCCESS_Render(t *testing.T) {
+	resolver, err := newTaskResolver(testTasks, false)
+	assert.Nil(t, err, "Valid tasks should be accepted")
+
+	request, result := resolveRequest(t, resolver, `{"task":"disk-usage","params":{"path":"/var/log; rm -rf /"}}`)
+	assert.Equal(t, `parameter "path": "/var/log; rm -rf /" doesn't match /[\w/.-]*`, result.Error, "Values not matching the pattern should be rejected")
+
+	request, result = resolveRequest(t, resolver, `{"task":"disk-usage","params":{"path":"/var/log","depth":3}}`)
+	assert.Empty(t, result.Error, "A valid task request should run")
+	assert.Equal(t, []string{"du", "-sh", "/var/log", "--max-depth=3"}, request.Command, "The parameters should be rendered in the command")
+	assert.Equal(t, 10000, request.Timeout, "The timeout of the task should be used by default")
+	assert.Equal(t, "disk-usage", result.Task, "The result should name the task")
+
+	request, _ = resolveRequest(t, resolver, `{"task":"disk-usage","params":{"path":"/tmp"}}`)
+	assert.Equal(t, []string{"du", "-sh", "/tmp", "--max-depth=1"}, request.Command, "The default of a parameter should be used when it isn't sent")
+
+	request, result = resolveRequest(t, resolver, `{"task":"greet","params":{"message":"it's $HOME"}}`)
+	assert.Empty(t, result.Error, "A valid task request should run")
+	assert.Equal(t, []string{"sh", "-c", `echo 'it'\''s $HOME'`}, request.Command, "Quoted values should be escaped for the shell and optional arguments left out")
+	assert.Equal(t, map[string]string{"LANG": "C"}, request.Env, "The env of the task should be used")
+
+	request, _ = resolveRequest(t, resolver, `{"command":["echo","hi"]}`)
+	assert.Equal(t, []string{"echo", "hi"}, request.Command, "Requests without a task should run as they are")
+}
+
+func TestTaskResolver_ERROR_Invalid_Requests(t *testing.T) {
+	resolver, err := newTaskResolver(testTasks, false)
+	assert.Nil(t, err, "Valid tasks should be accepted")
+
+	tests := map[string]string{
+		`{"task":"unknown"}`:    `unknown task "unknown"`,
+		`{"task":"disk-usage"}`: `parameter "path" is mandatory`,
+		`{"task":"disk-usage","params":{"path":"/tmp","depth":9}}`:        `parameter "depth": 9 is greater than 5`,
+		`{"task":"disk-usage","params":{"path":"/tmp","depth":"x"}}`:      `parameter "depth": "x" is not an integer`,
+		`{"task":"disk-usage","params":{"path":"/tmp","other":1}}`:        `unknown parameter "other" for task "disk-usage"`,
+		`{"task":"disk-usage","params":{"path":"/tmp"},"timeout":60000}`:  `the timeout can't exceed 10000ms for task "disk-usage"`,
+		`{"task":"disk-usage","params":{"path":"/tmp"},"command":["rm"]}`: "a task request can't set the command, shell, env or cwd",
+		`{"task":"greet","params":{"message":"hi","name":"--help"}}`:      `parameter "name": the value can't start with '-'`,
+		`{"task":"greet","params":{"message":{"nested":true}}}`:           `parameter "message": must be a string, a number or a boolean`,
+		`{"task":"restart","params":{"service":"sshd"}}`:                  `parameter "service": "sshd" is not one of nginx, redis`,
+	}
+
+	for request, expected := range tests {
+		_, result := resolveRequest(t, resolver, request)
+		assert.Equal(t, expected, result.Error, "The request "+request+" should be rejected")
+		assert.Equal(t, exitCodeErrorGeneral, result.ExitCode, "A rejected request should fail")
+	}
+}
+
+func TestTaskResolver_ERROR_Tasks_Only(t *testing.T) {
+	resolver, err := newTaskResolver(testTasks, true)
+	assert.Nil(t, err, "Valid tasks should be accepted")
+
+	_, result := resolveRequest(t, resolver, `{"command":["echo","hi"]}`)
+	assert.Equal(t, "Task is mandatory, this server only runs named tasks.", result.Error, "Commands should be rejected when only tasks can run")
+}
+
+func TestTaskResolver_ERROR_Max_Concurrent(t *testing.T) {
+	resolver, err := newTaskResolver(testTasks, false)
+	assert.Nil(t, err, "Valid tasks should be accepted")
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	callback := resolver.resolve(func(ctx context.Context, req []byte) interface{} {
+		close(started)
+		<-finish
+		return models.TaskResult{}
+	})
+
+	request := []byte(`{"task":"restart","params":{"service":"nginx"}}`)
+	go callback(context.Background(), request)
+	<-started
+
+	result := callback(context.Background(), request).(models.TaskResult)
+	assert.Equal(t, `task "restart" is at its limit of 1 concurrent requests`, result.Error, "Requests over the limit of the task should be rejected")
+
+	close(finish)
+	time.Sleep(100 * time.Millisecond)
+
+	resolver.mu.Lock()
+	assert.Zero(t, resolver.running["restart"], "Finished requests should release the task")
+	resolver.mu.Unlock()
+}
+
+func TestTasks_ERROR_Validate(t *testing.T) {
+	tests := map[string]Tasks{
+		"a task without command":         {"empty": {}},
+		"an undeclared parameter":        {"ls": {Command: []string{"ls", "{path}"}}},
+		"an unknown modifier":            {"ls": {Command: []string{"ls", "{path|raw}"}, Params: map[string]TaskParam{"path": {}}}},
+		"an unknown type":                {"ls": {Command: []string{"ls"}, Params: map[string]TaskParam{"path": {Type: "float"}}}},
+		"an invalid pattern":             {"ls": {Command: []string{"ls"}, Params: map[string]TaskParam{"path": {Pattern: "("}}}},
+		"an invalid default":             {"ls": {Command: []string{"ls"}, Params: map[string]TaskParam{"depth": {Type: ParamInt, Default: "x"}}}},
+		"a min greater than the max":     {"ls": {Command: []string{"ls"}, Params: map[string]TaskParam{"depth": {Type: ParamInt, Min: intParam(2), Max: intParam(1)}}}},
+		"an invalid task name":           {"ls all": {Command: []string{"ls"}}},
+		"a required parameter defaulted": {"ls": {Command: []string{"ls"}, Params: map[string]TaskParam{"path": {Required: true, Default: "/"}}}},
+	}
+
+	for name, tasks := range tests {
+		assert.NotNil(t, tasks.Validate(), "Tasks with "+name+" should be rejected")
+	}
+
+	assert.Nil(t, testTasks.Validate(), "Valid tasks should be accepted")
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans of the server. Spans are recorded by the global tracer provider,
+// so they cost nothing until the application configures one.
+const tracerName = "github.com/hriqueXimenes/sumo_logic_server/server"
+
+// Spans created for every connection and request.
+const (
+	spanAccept  = "accept"
+	spanRequest = "task.request"
+	spanDecode  = "task.decode"
+	spanQueue   = "task.queue"
+	spanExecute = "task.execute"
+	spanWrite   = "task.write"
+)
+
+// Attributes of the spans.
+const (
+	attributeCorrelationID = attribute.Key("correlation_id")
+	attributePeerAddress   = attribute.Key("network.peer.address")
+	attributeTransport     = attribute.Key("transport")
+	attributeRequestID     = attribute.Key("task.request_id")
+	attributeExitCode      = attribute.Key("task.exit_code")
+	attributeOutcome       = attribute.Key("task.outcome")
+)
+
+// Transports a request can arrive from.
+const (
+	transportTCP       = "tcp"
+	transportHTTP      = "http"
+	transportWebSocket = "websocket"
+	transportGRPC      = "grpc"
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startRequestSpan starts the span of a request, as a child of the trace context sent by the client
+// in the request when there is one.
+func startRequestSpan(ctx context.Context, transport string, envelope models.Envelope, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if envelope.Traceparent != "" {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{
+			"traceparent": envelope.Traceparent,
+			"tracestate":  envelope.Tracestate,
+		})
+	}
+
+	attributes := []attribute.KeyValue{attributeTransport.String(transport)}
+	if envelope.ID != "" {
+		attributes = append(attributes, attributeRequestID.String(envelope.ID))
+	}
+
+	options = append(options, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+
+	return tracer().Start(ctx, spanRequest, options...)
+}
+
+// traceExecution runs the callback in a span recording the exit code and outcome of the result.
+func traceExecution(ctx context.Context, callback func(ctx context.Context, req []byte) interface{}, request []byte) interface{} {
+	ctx, span := tracer().Start(ctx, spanExecute)
+	defer span.End()
+
+	result := callback(ctx, request)
+
+	if taskResult, ok := result.(models.TaskResult); ok {
+		resultOutcome := outcome(taskResult)
+		span.SetAttributes(attributeExitCode.Int(taskResult.ExitCode), attributeOutcome.String(resultOutcome))
+		if resultOutcome != outcomeSuccess {
+			span.SetStatus(codes.Error, resultOutcome)
+		}
+	}
+
+	return result
+}
+
+// traceAcquire waits for a free slot in the scheduler in a span, so the time spent in the queue is visible.
+func traceAcquire(ctx context.Context, scheduler *scheduler) error {
+	_, span := tracer().Start(ctx, spanQueue)
+	defer span.End()
+
+	err := scheduler.acquire(ctx)
+	recordError(span, err)
+
+	return err
+}
+
+// recordError marks a span as failed when err is not nil.
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
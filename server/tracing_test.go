@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	clientSpanID  = "00f067aa0ba902b7"
+)
+
+// recordSpans installs a tracer provider recording the spans ended during the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return recorder
+}
+
+// spansOfTrace returns the names of the recorded spans of a trace, with the span of every name.
+func spansOfTrace(recorder *tracetest.SpanRecorder, traceID string) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+
+	return spans
+}
+
+func TestHandleConnection_SUCCESS_Tracing(t *testing.T) {
+	recorder := recordSpans(t)
+
+	newNetwork := &networkImpl{
+		common:    common.NewCommonLib(),
+		scheduler: newScheduler(1),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{Command: []string{"false"}, ExitCode: 1}
+	}
+
+	ctx, acceptSpan := tracer().Start(context.Background(), spanAccept)
+	go newNetwork.HandleConnection(ctx, serverConn, callback)
+
+	request, _ := json.Marshal(models.TaskRequest{
+		Command:     []string{"false"},
+		Traceparent: "00-" + clientTraceID + "-" + clientSpanID + "-01",
+	})
+	clientConn.Write(append(request, '\n'))
+
+	var result models.TaskResult
+	err := json.NewDecoder(bufio.NewReader(clientConn)).Decode(&result)
+	assert.Nil(t, err, "The request should be answered")
+
+	clientConn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	spans := spansOfTrace(recorder, clientTraceID)
+	for _, name := range []string{spanRequest, spanDecode, spanQueue, spanExecute, spanWrite} {
+		assert.Contains(t, spans, name, "The request should be traced as part of the trace of the client")
+	}
+
+	requestSpan := spans[spanRequest]
+	assert.Equal(t, clientSpanID, requestSpan.Parent().SpanID().String(), "The request span should be a child of the span of the client")
+	assert.Len(t, requestSpan.Links(), 1, "The request span should link to the connection it was received on")
+	assert.Equal(t, acceptSpan.SpanContext().SpanID(), requestSpan.Links()[0].SpanContext.SpanID(), "The request span should link to the accept span")
+
+	for _, name := range []string{spanDecode, spanQueue, spanExecute, spanWrite} {
+		assert.Equal(t, requestSpan.SpanContext().SpanID(), spans[name].Parent().SpanID(), "Every step of the request should be a child of the request span")
+	}
+
+	assert.Equal(t, "Error", spans[spanExecute].Status().Code.String(), "A failed command should mark the execution span as failed")
+}
+
+func TestHandleConnection_SUCCESS_Tracing_Without_Client_Context(t *testing.T) {
+	recorder := recordSpans(t)
+
+	newNetwork := &networkImpl{
+		common: common.NewCommonLib(),
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	callback := func(ctx context.Context, req []byte) interface{} {
+		return models.TaskResult{}
+	}
+
+	go newNetwork.HandleConnection(context.Background(), serverConn, callback)
+
+	clientConn.Write([]byte(`{"command":["echo"]}` + "\n"))
+	bufio.NewReader(clientConn).ReadBytes('\n')
+	clientConn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	var requestSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == spanRequest {
+			requestSpan = span
+		}
+	}
+
+	assert.NotNil(t, requestSpan, "Requests without a trace context should still be traced")
+	assert.False(t, requestSpan.Parent().IsValid(), "Requests without a trace context should start a new trace")
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/hriqueXimenes/sumo_logic_server/common"
+	"github.com/hriqueXimenes/sumo_logic_server/server/models"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// handleWebSocket carries TaskRequest messages from browsers. Every request runs concurrently,
+// its output is sent as "output" messages while it runs and its TaskResult when it finishes,
+// both tagged with the request id.
+func (g *gateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	correlationID := uuid.New().String()
+	logger := g.logger.With(zap.String("CID", correlationID))
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warnw("Error upgrading WebSocket connection", "Error", err)
+		return
+	}
+	defer ws.Close()
+
+	ws.SetReadLimit(int64(g.config.maxRequestSize))
+
+	// Commands still running are cancelled when the browser goes away
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "logger", logger))
+	defer cancel()
+
+	g.activity.connect(correlationID, transportWebSocket, r.RemoteAddr, func() {
+		logger.Infow("Closing WebSocket connection", "Reason", "killed by an operator")
+		cancel()
+		ws.Close()
+	})
+	defer g.activity.disconnect(correlationID)
+
+	var writeMu sync.Mutex
+	writeMessage := func(v any) error {
+		data, err := g.common.Marshal(v)
+		if err != nil {
+			logger.Errorw("Error on Marshall Response", "Error", err)
+			return err
+		}
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+
+		return ws.WriteMessage(websocket.TextMessage, data)
+	}
+
+	maxPipelined := g.config.maxPipelinedRequests
+	if maxPipelined <= 0 {
+		maxPipelined = 1
+	}
+	pipeline := make(chan struct{}, maxPipelined)
+
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	for {
+		_, request, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Infow("Closing WebSocket connection", "Reason", err)
+			}
+			cancel()
+			return
+		}
+
+		logger.Infow("Received WebSocket Request", "Request", g.redactor.payload(common.NewJSONCodec(), request))
+
+		pipeline <- struct{}{}
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer func() { <-pipeline }()
+
+			var envelope models.Envelope
+			g.common.Unmarshal(request, &envelope)
+
+			requestCID := requestCorrelationID(envelope.CorrelationID, correlationID)
+			info := newRequestInfo(requestCID, g.config.serverID).describe(transportWebSocket, correlationID, r.RemoteAddr, envelope)
+
+			requestCtx, span := startRequestSpan(ctx, transportWebSocket, envelope, trace.WithAttributes(attributeCorrelationID.String(requestCID)))
+			defer span.End()
+
+			requestCtx = WithOutputWriter(requestCtx, func(chunk models.OutputChunk) {
+				writeMessage(chunk)
+			})
+
+			if requestCID != correlationID {
+				requestCtx = context.WithValue(requestCtx, "logger", g.logger.With(zap.String("CID", requestCID)))
+			}
+
+			requestCtx = g.activity.queue(requestCtx, info)
+			if err := traceAcquire(requestCtx, g.scheduler); err != nil {
+				g.activity.finish(info)
+				if info.killed.Load() {
+					writeMessage(info.annotate(cancelledBeforeStart(envelope.ID)))
+				}
+				return
+			}
+
+			g.activity.start(info)
+			result := traceExecution(requestCtx, g.callback, request)
+			g.activity.finish(info)
+			g.scheduler.release()
+
+			if err := writeMessage(info.annotate(result)); err != nil {
+				logger.Errorw("Error on Sending Response", "Error", err)
+			}
+		}()
+	}
+}